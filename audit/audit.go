@@ -0,0 +1,75 @@
+// Package audit writes noisybufferd's compliance trail: one JSON line per
+// deletion or retention change, saying who did what to which app and how
+// many submissions it touched. Entries never carry ciphertext, keys or
+// tokens. Unlike the operational log, app IDs are always recorded, since
+// an erasure record without its subject is worthless.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/collapsinghierarchy/noisybuffer/logging"
+)
+
+// Actors.
+const (
+	ActorOwner     = "owner"     // authenticated with the app's owner token
+	ActorJanitor   = "janitor"   // background retention enforcement
+	ActorOperator  = "operator"  // admin listener
+	ActorSubmitter = "submitter" // holder of a submission's deletion token
+)
+
+// Actions.
+const (
+	ActionRetentionSet = "retention.set"
+	ActionPurge        = "retention.purge"
+	ActionLegalHold    = "legal_hold.set"
+	ActionAck          = "submissions.ack"
+	ActionBurn         = "submissions.burn"
+	ActionErase        = "submissions.erase"
+	ActionEraseApp     = "app.erase"
+	ActionWithdraw     = "submission.withdraw"
+	ActionOwnerToken   = "owner_token.reset"
+	ActionFormSet      = "form.set"
+)
+
+// Event is one audit record.
+type Event struct {
+	Time      time.Time      `json:"time"`
+	Action    string         `json:"action"`
+	Actor     string         `json:"actor"`
+	AppID     uuid.UUID      `json:"appID"`
+	Count     int64          `json:"count,omitempty"` // submissions affected
+	Detail    map[string]any `json:"detail,omitempty"`
+	RequestID string         `json:"requestID,omitempty"`
+}
+
+// Log appends events to a writer. A nil *Log records nothing.
+type Log struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func New(w io.Writer) *Log { return &Log{enc: json.NewEncoder(w)} }
+
+// Record writes e, filling in the time and the request ID from ctx.
+func (l *Log) Record(ctx context.Context, e Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.RequestID == "" {
+		e.RequestID = logging.RequestID(ctx)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(e)
+}
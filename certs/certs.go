@@ -0,0 +1,156 @@
+// Package certs provides TLS server configs whose certificate (and client
+// CA pool, for mutual TLS) is re-read from disk when the files change or on
+// Reload, so renewed certificates take effect without a restart.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PollInterval is how often Watch checks the files for changes.
+const PollInterval = 10 * time.Second
+
+// Reloader holds the current key pair and, if a client CA file is set, the
+// pool used to verify client certificates.
+type Reloader struct {
+	certFile, keyFile, caFile string
+	cur                       atomic.Pointer[state]
+}
+
+type state struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool // nil without a CA file
+	stamp     string         // file sizes and mtimes at load time
+}
+
+// New loads the files once; an error here should stop startup. caFile may
+// be empty.
+func New(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the files. On error the previous certificate stays in use.
+func (r *Reloader) Reload() error {
+	stamp, err := r.stamp()
+	if err != nil {
+		return err
+	}
+	pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("certs: %w", err)
+	}
+	st := &state{cert: &pair, stamp: stamp}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("certs: %w", err)
+		}
+		st.clientCAs = x509.NewCertPool()
+		if !st.clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("certs: no certificates in %s", r.caFile)
+		}
+	}
+	r.cur.Store(st)
+	return nil
+}
+
+// NotAfter reports when the current certificate expires.
+func (r *Reloader) NotAfter() time.Time {
+	if leaf := r.cur.Load().cert.Leaf; leaf != nil {
+		return leaf.NotAfter
+	}
+	return time.Time{}
+}
+
+// Watch reloads whenever the files' size or modification time changes,
+// until ctx ends. Renewal tools replace the files in place or swap a
+// symlink; both show up here.
+func (r *Reloader) Watch(ctx context.Context, every time.Duration, log *slog.Logger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		stamp, err := r.stamp()
+		if err != nil || stamp == r.cur.Load().stamp {
+			continue // mid-rotation files are retried on the next tick
+		}
+		if err := r.Reload(); err != nil {
+			log.Error("TLS certificate reload failed; keeping the old one", "err", err)
+			continue
+		}
+		log.Info("TLS certificate reloaded", "notAfter", r.NotAfter())
+	}
+}
+
+func (r *Reloader) stamp() (string, error) {
+	var b strings.Builder
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", fmt.Errorf("certs: %w", err)
+		}
+		fmt.Fprintf(&b, "%d/%d;", fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cur.Load().cert, nil
+}
+
+// ServerConfig returns a config that serves the current certificate with
+// TLS 1.2+ and only forward-secret AEAD suites. Key exchange keeps Go's
+// defaults, which prefer the hybrid post-quantum X25519MLKEM768.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+		CipherSuites: []uint16{ // TLS 1.2 only; 1.3 suites are not configurable
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// ErrNoClientCA means MutualConfig was asked for without a CA file.
+var ErrNoClientCA = errors.New("certs: mutual TLS needs a client CA file")
+
+// MutualConfig is ServerConfig plus mandatory client certificates signed
+// by the CA file; the pool is picked per handshake so it reloads too.
+func (r *Reloader) MutualConfig() (*tls.Config, error) {
+	if r.caFile == "" {
+		return nil, ErrNoClientCA
+	}
+	base := r.ServerConfig()
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := r.ServerConfig()
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = r.cur.Load().clientCAs
+		return c, nil
+	}
+	return base, nil
+}
@@ -0,0 +1,172 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/certs"
+)
+
+// issue creates a key pair signed by parent (self-signed when parent is
+// nil) and returns the certificate, its key and both PEM encodings.
+func issue(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func write(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serverCN(t *testing.T, c *tls.Config) string {
+	t.Helper()
+	crt, err := c.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt.Leaf.Subject.CommonName
+}
+
+func TestReloader_PicksUpNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	_, _, crt, key := issue(t, "first", nil, nil)
+	write(t, certFile, crt)
+	write(t, keyFile, key)
+
+	r, err := certs.New(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	cfg := r.ServerConfig()
+	if cfg.MinVersion != tls.VersionTLS12 || serverCN(t, cfg) != "first" {
+		t.Fatalf("unexpected initial config")
+	}
+
+	// A broken write keeps the old certificate in service.
+	write(t, keyFile, []byte("not a key"))
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload should fail on a bad key")
+	}
+	if serverCN(t, cfg) != "first" {
+		t.Fatal("failed reload replaced the certificate")
+	}
+
+	// Watch notices the renewed pair without an explicit Reload.
+	_, _, crt, key = issue(t, "second", nil, nil)
+	write(t, certFile, crt)
+	write(t, keyFile, key)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	deadline := time.Now().Add(2 * time.Second)
+	for serverCN(t, cfg) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("Watch did not reload the renewed certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloader_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caPEM, _ := issue(t, "client-ca", nil, nil)
+	_, _, srvCrt, srvKey := issue(t, "server", ca, caKey)
+	_, _, cliCrt, cliKey := issue(t, "scraper", ca, caKey)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.pem")
+	write(t, certFile, srvCrt)
+	write(t, keyFile, srvKey)
+	write(t, caFile, caPEM)
+
+	plain, err := certs.New(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.MutualConfig(); !errors.Is(err, certs.ErrNoClientCA) {
+		t.Errorf("MutualConfig without CA: got %v", err)
+	}
+
+	r, err := certs.New(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	mtls, err := r.MutualConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = mtls
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs: roots, Certificates: certs, ServerName: "localhost",
+		}}}
+	}
+
+	if _, err := client().Get(srv.URL); err == nil {
+		t.Error("request without a client certificate should fail")
+	}
+	pair, err := tls.X509KeyPair(cliCrt, cliKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client(pair).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request with client certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "scraper" {
+		t.Errorf("server saw %q", body)
+	}
+}
@@ -1,8 +1,5 @@
-import { CipherSuite, Aes128Gcm, HkdfSha256 }
-  from "https://cdn.jsdelivr.net/npm/@hpke/core@1.7.2/+esm";
-
-import { HybridkemX25519Kyber768 }
-  from "https://cdn.jsdelivr.net/npm/@hpke/hybridkem-x25519-kyber768@1.6.1/+esm";
+import { CipherSuite, Aes128Gcm, HkdfSha256, HybridkemX25519Kyber768 }
+  from "./vendor/hpke.js"; // scripts/vendor-hpke.sh
 
 const out = document.getElementById("output");
 const enc = new TextEncoder(), dec = new TextDecoder();
@@ -32,10 +29,10 @@ async function loadOrCreateKeypair(appId) {
   // ---- hit in localStorage? ----------------------------------------
   const cached = localStorage.getItem(dbKey);
   if (cached) {
-    const { pubB64, privB64, kid } = JSON.parse(cached);
+    const { pubB64, privB64, kid, ownerToken } = JSON.parse(cached);
     const pubKey  = await suite.kem.deserializePublicKey(b64ToArray(pubB64));
     const privKey = await suite.kem.deserializePrivateKey(b64ToArray(privB64));
-    return { pubB64, privB64, pubKey, privKey, kid };
+    return { pubB64, privB64, pubKey, privKey, kid, ownerToken };
   }
 
   // ---- first run → generate & persist ------------------------------
@@ -98,6 +95,12 @@ document.getElementById("regForm").addEventListener("submit", async ev => {
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ appID: appId, kid, pub: pubB64 }),
   });
+  if (res.ok) {
+    // owner token is shown once — keep it with the key-pair
+    const { ownerToken } = await res.json();
+    const dbKey = `hpke:${appId}`;
+    localStorage.setItem(dbKey, JSON.stringify({ ...JSON.parse(localStorage.getItem(dbKey)), ownerToken }));
+  }
   out.textContent = `register: ${res.status} ${res.statusText}`;
 });
 
@@ -1,17 +1,18 @@
 /* --------------------------------------------------------------------
    Access-file import  +  Pull-and-decrypt
    -------------------------------------------------------------------- */
-import { CipherSuite, Aes128Gcm, HkdfSha256 }
-  from "https://cdn.jsdelivr.net/npm/@hpke/core@1.7.2/+esm";
-import { HybridkemX25519Kyber768 }
-  from "https://cdn.jsdelivr.net/npm/@hpke/hybridkem-x25519-kyber768@1.6.1/+esm";
+import { CipherSuite, Aes128Gcm, HkdfSha256, HybridkemX25519Kyber768 }
+  from "./vendor/hpke.js"; // scripts/vendor-hpke.sh
 
 const out   = document.getElementById("output");
+const list  = document.getElementById("messages");
 const dec   = new TextDecoder();
+const txt   = new TextEncoder();
 const file  = document.getElementById("accessFile");
 const pullF = document.getElementById("pullForm");
 
 const toArr  = b64 => Uint8Array.from(atob(b64), c => c.charCodeAt(0));
+const toB64  = arr => btoa(Array.from(arr, c => String.fromCharCode(c)).join(""));
 const cacheK = id  => `hpke:${id}`;
 const suite  = () => new CipherSuite({
   kem:  new HybridkemX25519Kyber768(),
@@ -30,7 +31,7 @@ fileInput.addEventListener("change", async () => {
   if (!f) return;
 
   try {
-    const meta = JSON.parse(await f.text());               // {pubB64, privB64, kid}
+    const meta = JSON.parse(await f.text());               // {pubB64, privB64, kid, ownerToken}
     const match = f.name.match(/^noisybuffer-keypair-([0-9a-f-]+)\.json$/i);
     const appID = meta.appID || (match && match[1]) || "";
 
@@ -40,7 +41,8 @@ fileInput.addEventListener("change", async () => {
     // store slim version (fits under 5 MB localStorage quota)
     localStorage.setItem(
       `hpke:${appID}`,
-      JSON.stringify({ kid: meta.kid ?? 0, pubB64: meta.pubB64, privB64: meta.privB64 })
+      JSON.stringify({ kid: meta.kid ?? 0, pubB64: meta.pubB64, privB64: meta.privB64,
+                       ownerToken: meta.ownerToken })
     );
 
     document.getElementById("pullAppId").value = appID;   // pre-fill pull form
@@ -78,8 +80,58 @@ pullF.addEventListener("submit", async ev => {
         msgs.push(dec.decode(await ctx.open(ct)));
       } catch { /* skip corrupt line */ }
     }
-    out.textContent = msgs.join("\\n") || "(no messages)";
+    out.textContent = msgs.length ? "" : "(no messages)";
+    list.replaceChildren(...msgs.map(m => message(appID, m)));
   } catch (e) {
     out.textContent = `decrypt error: ${e}`;
   }
 });
+
+/* ---------- 3. answer submitters who left a reply mailbox ---------- */
+// nb.js seals {mailbox, pub} into the submission as "_nb_reply" when the
+// form allows replies; the answer is sealed to pub and only the submitter's
+// browser can open it.
+const REPLY_INFO = txt.encode("noisybuffer reply");
+
+function message(appID, text) {
+  const item = document.createElement("div");
+  item.append(Object.assign(document.createElement("pre"), { textContent: text }));
+  let box;
+  try { box = JSON.parse(text)._nb_reply; } catch { /* not JSON */ }
+  if (!box || !box.mailbox || !box.pub) return item;
+
+  const form  = document.createElement("form");
+  const input = Object.assign(document.createElement("textarea"), { required: true, rows: 3 });
+  const state = document.createElement("span");
+  form.append(input, Object.assign(document.createElement("button"), { textContent: "Send encrypted reply" }), state);
+  form.addEventListener("submit", async ev => {
+    ev.preventDefault();
+    try {
+      await sendReply(appID, box, input.value);
+      state.textContent = " sent ✓";
+      form.reset();
+    } catch (e) {
+      state.textContent = ` reply failed: ${e}`;
+    }
+  });
+  item.append(form);
+  return item;
+}
+
+async function sendReply(appID, box, text) {
+  const { ownerToken } = JSON.parse(localStorage.getItem(cacheK(appID)) || "{}");
+  if (!ownerToken) throw "access file has no owner token";
+  const S      = suite();
+  const pub    = await S.kem.deserializePublicKey(toArr(box.pub));
+  const sender = await S.createSenderContext({ recipientPublicKey: pub, info: REPLY_INFO });
+  const ct     = new Uint8Array(await sender.seal(txt.encode(text)));
+  const blob   = new Uint8Array(sender.enc.byteLength + ct.length);
+  blob.set(new Uint8Array(sender.enc), 0); blob.set(ct, sender.enc.byteLength);
+
+  const rsp = await fetch("/api/nb/v1/reply", {
+    method: "POST",
+    headers: { "Content-Type": "application/json", "Authorization": `Bearer ${ownerToken}` },
+    body: JSON.stringify({ appID, mailbox: box.mailbox, blob: toB64(blob) }),
+  });
+  if (!rsp.ok) throw `HTTP ${rsp.status}`;
+}
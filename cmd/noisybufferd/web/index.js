@@ -0,0 +1,3 @@
+// index.js — wires the demo form; kept out of index.html so the page works
+// under the CSP's script-src 'self' (no inline scripts).
+NB.init({ appId: "bc8c5b3c-b496-4dcc-8551-575978214c44" });
@@ -6,20 +6,48 @@
  *      NB.init({ appId: "YOUR_UUID", apiBase: "/api/nb/v1" });
  *    </script>
  *
- *  All <form data-noisybuffer> elements are wired automatically.
+ *  All <form data-noisybuffer> elements are wired automatically. Add
+ *  burnAfterReading: true (or data-nb-burn on a single form) for one-off
+ *  secrets: the server deletes them as soon as the owner has read them.
+ *
+ *  deletionToken: "show" (or data-nb-deletion-token="show") gives the
+ *  submitter a withdrawal code after sending; "store" keeps it in
+ *  localStorage instead (see NB.deletionTokens). NB.withdraw(code) deletes
+ *  the submission again and resolves to the signed erasure receipt.
+ *
+ *  replies: true (or data-nb-replies) lets the owner answer privately: a
+ *  fresh reply key and a random mailbox ID are sealed into the submission
+ *  as "_nb_reply", and the key stays in this browser's localStorage.
+ *  NB.replies() fetches and decrypts whatever arrived since; the server
+ *  only ever sees the mailbox ID and ciphertext.
+ *
+ *  Pages that cannot run inline scripts (such as the hosted forms at
+ *  /f/{appID}) set data-nb-app and data-nb-api on the <script> tag
+ *  instead of calling NB.init; data-nb-success on a form replaces the
+ *  "Sent ✓" note.
+ *
+ *  data-nb-form="contact" tags a form's submissions with one of the app's
+ *  registered form IDs, so the owner can pull them separately.
+ *
+ *  Apps with a padding policy get every submission padded to one of the
+ *  allowed sizes before sealing; nothing to configure on the page.
  */
 ;(function (global) {
+  const SCRIPT = document.currentScript; // only set while nb.js first runs
   const txt = new TextEncoder();
-  const b64 = u8 => btoa(String.fromCharCode.apply(null, u8));
   const u8  = s  => Uint8Array.from(atob(s), c => c.charCodeAt(0));
+  const b64 = a  => btoa(Array.from(a, c => String.fromCharCode(c)).join(""));
+
+  // HPKE info for owner replies, so a reply can never pass for a submission
+  const REPLY_INFO = txt.encode("noisybuffer reply");
 
   // --- load HPKE libs dynamically so nb.js itself stays small ----------
-  async function loadSuite() {
-    const [{ CipherSuite, Aes128Gcm, HkdfSha256 },
-           { HybridkemX25519Kyber768 }] = await Promise.all([
-      import("https://cdn.jsdelivr.net/npm/@hpke/core@1.7.2/+esm"),
-      import("https://cdn.jsdelivr.net/npm/@hpke/hybridkem-x25519-kyber768@1.6.1/+esm"),
-    ]);
+  // The bundle is vendored and served by the same noisybufferd as the API
+  // (apiBase decides which one), so no third-party CDN sees the page.
+  async function loadSuite(api) {
+    const lib = new URL("/vendor/hpke.js", new URL(api, location.href));
+    const { CipherSuite, Aes128Gcm, HkdfSha256, HybridkemX25519Kyber768 } =
+      await import(lib.href);
     return new CipherSuite({
       kem:  new HybridkemX25519Kyber768(),
       kdf:  new HkdfSha256(),
@@ -27,6 +55,33 @@
     });
   }
 
+  // --- pad to the app's policy (advertised by /pub) ----------------------
+  // Sizes count the whole blob, so overhead is the encapsulated key plus
+  // the 16-byte GCM tag. Trailing spaces are ignored by JSON.parse.
+  function pad(plain, overhead, policy) {
+    if (!policy || !policy.size) return plain; // "off" has no size
+    const need = plain.length + overhead;
+    let size = policy.size;
+    if (policy.mode === "pow2") while (size < need) size *= 2;
+    if (size < need) throw new Error("too long for this form");
+    const out = new Uint8Array(size - overhead).fill(0x20);
+    out.set(plain);
+    return out;
+  }
+
+  // --- retry network failures and 5xx with a short backoff --------------
+  async function withRetry(send, attempts = 3) {
+    for (let i = 1; ; i++) {
+      try {
+        const res = await send();
+        if (res.status < 500 || i >= attempts) return res;
+      } catch (e) {
+        if (i >= attempts) throw e;
+      }
+      await new Promise(r => setTimeout(r, 500 * 2 ** (i - 1)));
+    }
+  }
+
   /* ------------------------------------------------ NB namespace ---- */
   const NB = global.NB || (global.NB = {});
 
@@ -37,18 +92,106 @@
     }
     const APP_ID = cfg.appId;
     const API    = cfg.apiBase || "/api/nb/v1";
+    const OPTS   = { burn: !!cfg.burnAfterReading, token: cfg.deletionToken || "",
+                     replies: !!cfg.replies };
 
     // run once DOM ready
     if (document.readyState === "loading") {
-      document.addEventListener("DOMContentLoaded", () => setup(APP_ID, API));
+      document.addEventListener("DOMContentLoaded", () => setup(APP_ID, API, OPTS));
     } else {
-      setup(APP_ID, API);
+      setup(APP_ID, API, OPTS);
+    }
+  };
+
+  /* ------------------------------------------------ withdrawal ------ */
+  // A withdrawal code is "<submission id>.<deletion token>".
+  const TOKENS = "nb:deletion-tokens";
+
+  NB.deletionTokens = function deletionTokens() {
+    try { return JSON.parse(localStorage.getItem(TOKENS)) || []; } catch { return []; }
+  };
+
+  function storeToken(appId, code) {
+    const all = NB.deletionTokens();
+    all.push({ appId, code, ts: new Date().toISOString() });
+    localStorage.setItem(TOKENS, JSON.stringify(all));
+  }
+
+  NB.withdraw = async function withdraw(code, apiBase = "/api/nb/v1") {
+    const [id, token] = String(code).trim().split(".");
+    const res = await fetch(`${apiBase}/submissions/${encodeURIComponent(id)}`, {
+      method:"DELETE",
+      headers:{ "Authorization":`Bearer ${token}` },
+    });
+    if (!res.ok) throw new Error(`withdraw ${res.status}`);
+    localStorage.setItem(TOKENS, JSON.stringify(
+      NB.deletionTokens().filter(t => t.code !== code)));
+    return res.json(); // {erasure, receipt}
+  };
+
+  /* ------------------------------------------------ replies --------- */
+  // Each reply-enabled submission gets a mailbox and a key pair derived
+  // from a random seed; only the seed is kept.
+  const BOXES = "nb:reply-boxes";
+
+  NB.replyBoxes = function replyBoxes() {
+    try { return JSON.parse(localStorage.getItem(BOXES)) || []; } catch { return []; }
+  };
+
+  async function newReplyBox(suite) {
+    const seed = crypto.getRandomValues(new Uint8Array(64));
+    const kp   = await suite.kem.deriveKeyPair(seed.buffer);
+    return {
+      mailbox: crypto.randomUUID(),
+      seed:    b64(seed),
+      pub:     b64(new Uint8Array(await suite.kem.serializePublicKey(kp.publicKey))),
+    };
+  }
+
+  function storeReplyBox(appId, box) {
+    const all = NB.replyBoxes();
+    all.push({ appId, mailbox: box.mailbox, seed: box.seed, ts: new Date().toISOString() });
+    localStorage.setItem(BOXES, JSON.stringify(all));
+  }
+
+  // NB.replies resolves to [{appId, mailbox, id, ts, text}] for every
+  // mailbox this browser holds a key for. Undecryptable replies are skipped.
+  NB.replies = async function replies(apiBase = "/api/nb/v1") {
+    const boxes = NB.replyBoxes();
+    if (!boxes.length) return [];
+    const suite = await loadSuite(apiBase);
+    const dec   = new TextDecoder();
+    const out   = [];
+    for (const box of boxes) {
+      const res = await fetch(`${apiBase}/reply/${encodeURIComponent(box.mailbox)}`);
+      if (!res.ok) throw new Error(`replies ${res.status}`);
+      const { replies } = await res.json();
+      if (!replies.length) continue;
+      const kp = await suite.kem.deriveKeyPair(u8(box.seed).buffer);
+      for (const r of replies) {
+        try {
+          const blob = u8(r.blob);
+          const ctx  = await suite.createRecipientContext({
+            recipientKey: kp, enc: blob.slice(0, suite.kem.encSize), info: REPLY_INFO });
+          const text = dec.decode(await ctx.open(blob.slice(suite.kem.encSize)));
+          out.push({ appId: box.appId, mailbox: box.mailbox, id: r.id, ts: r.ts, text });
+        } catch { /* not sealed to our key */ }
+      }
     }
+    return out;
+  };
+
+  // NB.forgetReplies empties the mailbox on the server and drops its key.
+  NB.forgetReplies = async function forgetReplies(mailbox, apiBase = "/api/nb/v1") {
+    const res = await fetch(`${apiBase}/reply/${encodeURIComponent(mailbox)}`, { method:"DELETE" });
+    if (!res.ok) throw new Error(`forget ${res.status}`);
+    localStorage.setItem(BOXES, JSON.stringify(
+      NB.replyBoxes().filter(b => b.mailbox !== mailbox)));
   };
 
   /* ------------------------------------------------ setup per page -- */
-  async function setup(APP_ID, API) {
-    const suite = await loadSuite();
+  async function setup(APP_ID, API, OPTS) {
+    const suite = await loadSuite(API);
 
     // 1. fetch & cache public key
     let cache;
@@ -56,10 +199,11 @@
       if (cache) return cache;
       const r = await fetch(`${API}/pub?appID=${encodeURIComponent(APP_ID)}`);
       if (!r.ok) throw new Error("public key fetch failed");
-      const { kid, pub } = await r.json();
+      const { kid, pub, padding } = await r.json();
       cache = {
         kid,
         pubKey: await suite.kem.deserializePublicKey(u8(pub)),
+        padding,
       };
       return cache;
     }
@@ -79,30 +223,54 @@
           form.dataset.state = "working";
           note.textContent   = "Encrypting…";
 
-          // collect fields
-          const plain = txt.encode(JSON.stringify(
-            Object.fromEntries(new FormData(form).entries())
-          ));
+          // collect fields, plus where and how to answer if asked for
+          const fields = Object.fromEntries(new FormData(form).entries());
+          const box = (OPTS.replies || "nbReplies" in form.dataset) ? await newReplyBox(suite) : null;
+          if (box) fields._nb_reply = { mailbox: box.mailbox, pub: box.pub };
+          const plain = txt.encode(JSON.stringify(fields));
 
           // seal
-          const { kid, pubKey } = await getKey();
+          const { kid, pubKey, padding } = await getKey();
           const sender = await suite.createSenderContext({ recipientPublicKey: pubKey });
-          const ct     = new Uint8Array(await sender.seal(plain));
+          const padded = pad(plain, sender.enc.byteLength + 16, padding);
+          const ct     = new Uint8Array(await sender.seal(padded));
 
           const blob = new Uint8Array(sender.enc.length + ct.length);
           blob.set(sender.enc, 0); blob.set(ct, sender.enc.length);
 
-          // push
-          const res = await fetch(`${API}/push`, {
+          // push raw ciphertext (no base64/JSON overhead); retries reuse
+          // the idempotency key so the server never stores a duplicate
+          const idemKey = crypto.randomUUID();
+          const headers = {
+            "Content-Type":"application/octet-stream",
+            "Idempotency-Key":idemKey,
+          };
+          if (OPTS.burn || "nbBurn" in form.dataset) headers["X-NB-Burn-After-Reading"] = "true";
+          const tokenMode = form.dataset.nbDeletionToken || OPTS.token;
+          if (tokenMode) headers["X-NB-Deletion-Token"] = "true";
+          if (form.dataset.nbForm) headers["X-NB-Form-ID"] = form.dataset.nbForm;
+          const res = await withRetry(() => fetch(`${API}/push/${encodeURIComponent(APP_ID)}/${kid}`, {
             method:"POST",
-            headers:{ "Content-Type":"application/json" },
-            body:JSON.stringify({ appID:APP_ID, kid, blob:b64(blob) })
-          });
+            headers,
+            body:blob
+          }));
           if (!res.ok) throw new Error(`push ${res.status}`);
+          const rcpt = await res.json();
 
           form.dataset.state = "success";
           note.style.color   = "#157347";
-          note.textContent   = "Sent ✓";
+          note.textContent   = form.dataset.nbSuccess || "Sent ✓";
+          if (box) storeReplyBox(APP_ID, box);
+          if (rcpt.deletionToken) {
+            const code = `${rcpt.id}.${rcpt.deletionToken}`;
+            if (tokenMode === "store") {
+              storeToken(APP_ID, code);
+            } else {
+              note.append(" — keep this code to withdraw it later: ",
+                Object.assign(document.createElement("code"),
+                  { textContent:code, style:"user-select:all;word-break:break-all;" }));
+            }
+          }
           form.reset();
         } catch (e) {
           console.error("NoisyBuffer:", e);
@@ -115,4 +283,10 @@
       });
     });
   }
+
+  /* ------------------------------------------------ auto-init ------- */
+  if (SCRIPT && SCRIPT.dataset.nbApp) {
+    NB.init({ appId: SCRIPT.dataset.nbApp, apiBase: SCRIPT.dataset.nbApi,
+              replies: "nbReplies" in SCRIPT.dataset });
+  }
 })(window);
@@ -1,8 +1,6 @@
 // register.js — minimal key‑registration helper for NoisyBuffer
-import { CipherSuite, Aes128Gcm, HkdfSha256 }
-  from "https://cdn.jsdelivr.net/npm/@hpke/core@1.7.2/+esm";
-import { HybridkemX25519Kyber768 }
-  from "https://cdn.jsdelivr.net/npm/@hpke/hybridkem-x25519-kyber768@1.6.1/+esm";
+import { CipherSuite, Aes128Gcm, HkdfSha256, HybridkemX25519Kyber768 }
+  from "./vendor/hpke.js"; // scripts/vendor-hpke.sh
 
 const out = document.getElementById("output");
 const MY_ID_KEY = "nb:my-app-id";
@@ -62,5 +60,11 @@ document.getElementById("regForm").addEventListener("submit", async (e) => {
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ appID: myAppId, kid, pub: pubB64 }),
   });
+  if (rsp.ok) {
+    // owner token is shown once — keep it with the key-pair / access file
+    const { ownerToken } = await rsp.json();
+    const key = `hpke:${myAppId}`;
+    localStorage.setItem(key, JSON.stringify({ ...JSON.parse(localStorage.getItem(key)), ownerToken }));
+  }
   out.textContent = `register: ${rsp.status} ${rsp.statusText}`;
 });
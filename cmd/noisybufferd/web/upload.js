@@ -0,0 +1,86 @@
+/* --------------------------------------------------------------------
+   Upload page for one-time links:  /upload.html#app=<appID>&token=<token>
+   The fragment never leaves the browser, so the token stays out of
+   server and proxy logs. It is dropped from the address bar on load.
+   -------------------------------------------------------------------- */
+import { CipherSuite, Aes128Gcm, HkdfSha256, HybridkemX25519Kyber768 }
+  from "./vendor/hpke.js"; // scripts/vendor-hpke.sh
+
+const API  = "/api/nb/v1";
+const out  = document.getElementById("output");
+const form = document.getElementById("uploadForm");
+const txt  = new TextEncoder();
+
+const toArr = b64 => Uint8Array.from(atob(b64), c => c.charCodeAt(0));
+const toB64 = arr => btoa(Array.from(arr, c => String.fromCharCode(c)).join(""));
+
+// Pads with spaces (ignored by JSON.parse) until enc || ct || tag is a
+// size the app's padding policy allows; same rule as nb.js.
+function pad(plain, overhead, policy) {
+  if (!policy || !policy.size) return plain;
+  const need = plain.length + overhead;
+  let size = policy.size;
+  if (policy.mode === "pow2") while (size < need) size *= 2;
+  if (size < need) throw "too large for this link";
+  const out = new Uint8Array(size - overhead).fill(0x20);
+  out.set(plain);
+  return out;
+}
+
+const params = new URLSearchParams(location.hash.slice(1));
+const appID  = params.get("app");
+const token  = params.get("token");
+history.replaceState(null, "", location.pathname);
+
+if (!appID || !token) {
+  form.hidden = true;
+  out.textContent = "This upload link is incomplete.";
+}
+
+form.addEventListener("submit", async ev => {
+  ev.preventDefault();
+  const message = document.getElementById("message").value;
+  const file    = document.getElementById("file").files[0];
+  if (!message && !file) return (out.textContent = "Nothing to send.");
+
+  try {
+    out.textContent = "Encrypting…";
+    const payload = { message };
+    if (file) {
+      payload.file = { name: file.name, type: file.type,
+                       data: toB64(new Uint8Array(await file.arrayBuffer())) };
+    }
+
+    const S = new CipherSuite({
+      kem:  new HybridkemX25519Kyber768(),
+      kdf:  new HkdfSha256(),
+      aead: new Aes128Gcm(),
+    });
+    const r = await fetch(`${API}/pub?appID=${encodeURIComponent(appID)}`);
+    if (!r.ok) throw `public key: HTTP ${r.status}`;
+    const { kid, pub, padding } = await r.json();
+    const sender = await S.createSenderContext({
+      recipientPublicKey: await S.kem.deserializePublicKey(toArr(pub)) });
+    const plain = pad(txt.encode(JSON.stringify(payload)), sender.enc.byteLength + 16, padding);
+    const ct   = new Uint8Array(await sender.seal(plain));
+    const blob = new Uint8Array(sender.enc.byteLength + ct.length);
+    blob.set(new Uint8Array(sender.enc), 0); blob.set(ct, sender.enc.byteLength);
+
+    const rsp = await fetch(`${API}/push/${encodeURIComponent(appID)}/${kid}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/octet-stream",
+        "Idempotency-Key": crypto.randomUUID(),
+        "X-NB-Upload-Token": token,
+      },
+      body: blob,
+    });
+    if (rsp.status === 403) throw "this link has expired or was already used";
+    if (rsp.status === 413) throw "too large for this server";
+    if (!rsp.ok) throw `HTTP ${rsp.status}`;
+    form.hidden = true;
+    out.textContent = "Sent ✓ — you can close this page.";
+  } catch (e) {
+    out.textContent = `Not sent: ${e}`;
+  }
+});
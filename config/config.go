@@ -1,8 +1,224 @@
+// Package config assembles noisybufferd's Config from defaults, an optional
+// YAML or TOML file, environment variables and command-line flags, in that
+// order of precedence. A Live holder carries the running config so that the
+// reloadable subset can change on SIGHUP.
 package config
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Config struct {
-	MaxBlobBytes    int64 // e.g. 64*1024
-	AllowedKEMs     []string
-	RateLimitBurst  int
-	RateLimitMinute int
+	Port        string `yaml:"port" toml:"port"`
+	AdminAddr   string `yaml:"admin_addr" toml:"admin_addr"`     // metrics and probes
+	UnixSocket  string `yaml:"unix_socket" toml:"unix_socket"`   // set: public API on this socket instead of Port
+	SocketMode  string `yaml:"socket_mode" toml:"socket_mode"`   // octal file mode of UnixSocket
+	DatabaseURL string `yaml:"database_url" toml:"database_url"` // secret: may carry a password
+	WebDir      string `yaml:"web_dir" toml:"web_dir"`
+
+	MaxBlobBytes   int64         `yaml:"max_blob_bytes" toml:"max_blob_bytes"`   // e.g. 64*1024
+	MaxBatchBytes  int64         `yaml:"max_batch_bytes" toml:"max_batch_bytes"` // whole push:batch body, independent of MaxBlobBytes
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl"`
+	MaxReplyBytes  int64         `yaml:"max_reply_bytes" toml:"max_reply_bytes"` // largest sealed owner reply
+	ReplyTTL       time.Duration `yaml:"reply_ttl" toml:"reply_ttl"`             // replies are deleted this long after posting
+	MailboxReplies int           `yaml:"mailbox_replies" toml:"mailbox_replies"` // unexpired replies one mailbox may hold
+	DrainDelay     time.Duration `yaml:"drain_delay" toml:"drain_delay"`
+	AuditLog       string        `yaml:"audit_log" toml:"audit_log"`               // file for the audit trail; "-": stderr
+	ReceiptKeyFile string        `yaml:"receipt_key_file" toml:"receipt_key_file"` // Ed25519 PEM key signing erasure receipts
+
+	WebhookAllowPrivate bool          `yaml:"webhook_allow_private" toml:"webhook_allow_private"` // deliver to loopback/private addresses too
+	WebhookParkedTTL    time.Duration `yaml:"webhook_parked_ttl" toml:"webhook_parked_ttl"`       // failed deliveries are kept this long for inspection
+
+	// Reloadable on SIGHUP.
+	AllowedKEMs     []string `yaml:"allowed_kems" toml:"allowed_kems"`           // empty: any key accepted
+	RateLimitBurst  int      `yaml:"rate_limit_burst" toml:"rate_limit_burst"`   // per client IP
+	RateLimitMinute int      `yaml:"rate_limit_minute" toml:"rate_limit_minute"` // 0 disables rate limiting; never applied on a Unix socket
+	FrameAncestors  []string `yaml:"frame_ancestors" toml:"frame_ancestors"`     // CSP sources allowed to frame the UI
+
+	TLS     TLSConfig     `yaml:"tls" toml:"tls"`
+	Log     LogConfig     `yaml:"log" toml:"log"`
+	Metrics MetricsConfig `yaml:"metrics" toml:"metrics"`
+	Tracing TracingConfig `yaml:"tracing" toml:"tracing"`
+}
+
+// TLSConfig turns on HTTPS when both files are set. The files themselves
+// are re-read when they change; their paths need a restart.
+type TLSConfig struct {
+	CertFile      string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile       string `yaml:"key_file" toml:"key_file"`
+	AdminClientCA string `yaml:"admin_client_ca" toml:"admin_client_ca"` // set: admin listener requires client certs
+}
+
+type LogConfig struct {
+	Level     string `yaml:"level" toml:"level"` // reloadable
+	Format    string `yaml:"format" toml:"format"`
+	ClientIPs bool   `yaml:"client_ips" toml:"client_ips"`
+	AppIDs    bool   `yaml:"app_ids" toml:"app_ids"`
+}
+
+type MetricsConfig struct {
+	PerApp bool `yaml:"per_app" toml:"per_app"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`
+	File        string  `yaml:"file" toml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// KEMs maps the KEM names accepted in AllowedKEMs to the size of their
+// serialized public keys, which is how a registered key is recognised.
+var KEMs = map[string]int{
+	"X25519Kyber768": 32 + 1184, // hybrid used by the bundled web client
+	"ML-KEM-768":     1184,
+	"DHKEM-X25519":   32,
+	"DHKEM-P256":     65,
+}
+
+// Default returns the built-in settings. DatabaseURL has no default.
+func Default() Config {
+	return Config{
+		Port:             "1234",
+		AdminAddr:        "127.0.0.1:9090",
+		SocketMode:       "0660",
+		MaxBlobBytes:     64 * 1024,
+		MaxBatchBytes:    8 << 20,
+		IdempotencyTTL:   24 * time.Hour,
+		MaxReplyBytes:    16 * 1024,
+		ReplyTTL:         30 * 24 * time.Hour,
+		WebhookParkedTTL: 7 * 24 * time.Hour,
+		MailboxReplies:   20,
+		DrainDelay:       5 * time.Second,
+		AuditLog:         "-",
+		RateLimitBurst:   20,
+		RateLimitMinute:  0,
+		FrameAncestors:   []string{"'none'"},
+		Log:              LogConfig{Level: "info", Format: "text"},
+		Tracing:          TracingConfig{Exporter: "none", SampleRatio: 1},
+	}
+}
+
+// Validate reports every problem at once.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		bad("port: %q is not a TCP port", c.Port)
+	}
+	if _, err := c.SocketFileMode(); err != nil {
+		bad("socket_mode: %q is not an octal file mode", c.SocketMode)
+	}
+	if c.DatabaseURL == "" {
+		bad("database_url: required")
+	}
+	if c.MaxBlobBytes <= 0 {
+		bad("max_blob_bytes: must be positive")
+	}
+	if c.MaxBatchBytes <= 0 {
+		bad("max_batch_bytes: must be positive")
+	}
+	if c.IdempotencyTTL <= 0 {
+		bad("idempotency_ttl: must be positive")
+	}
+	if c.MaxReplyBytes <= 0 {
+		bad("max_reply_bytes: must be positive")
+	}
+	if c.ReplyTTL <= 0 {
+		bad("reply_ttl: must be positive")
+	}
+	if c.MailboxReplies <= 0 {
+		bad("mailbox_replies: must be positive")
+	}
+	if c.WebhookParkedTTL <= 0 {
+		bad("webhook_parked_ttl: must be positive")
+	}
+	if c.AuditLog == "" {
+		bad(`audit_log: required ("-" for stderr)`)
+	}
+	if c.DrainDelay < 0 {
+		bad("drain_delay: must not be negative")
+	}
+	for _, k := range c.AllowedKEMs {
+		if _, ok := KEMs[k]; !ok {
+			bad("allowed_kems: unknown KEM %q", k)
+		}
+	}
+	if c.RateLimitMinute < 0 || c.RateLimitBurst < 0 {
+		bad("rate limits: must not be negative")
+	}
+	if c.RateLimitMinute > 0 && c.RateLimitBurst < 1 {
+		bad("rate_limit_burst: must be at least 1 when rate limiting is on")
+	}
+	for _, src := range c.FrameAncestors {
+		if src == "" || strings.ContainsAny(src, " \t\r\n;,") {
+			bad("frame_ancestors: %q is not a CSP source", src)
+		}
+		if src == "'none'" && len(c.FrameAncestors) > 1 {
+			bad("frame_ancestors: 'none' cannot be combined with other sources")
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		bad("tls: cert_file and key_file must be set together")
+	}
+	if c.TLS.AdminClientCA != "" && c.TLS.CertFile == "" {
+		bad("tls.admin_client_ca: needs cert_file and key_file")
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		bad("log.level: %q (want debug, info, warn or error)", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		bad("log.format: %q (want text or json)", c.Log.Format)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		bad("tracing.sample_ratio: must be within 0..1")
+	}
+	return errors.Join(errs...)
+}
+
+// SocketFileMode parses SocketMode.
+func (c *Config) SocketFileMode() (fs.FileMode, error) {
+	m, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("bad file mode %q", c.SocketMode)
+	}
+	return fs.FileMode(m), nil
+}
+
+// Redacted returns a copy that is safe to print or log.
+func (c Config) Redacted() Config {
+	if c.DatabaseURL != "" {
+		if u, err := url.Parse(c.DatabaseURL); err == nil && u.Scheme != "" {
+			c.DatabaseURL = u.Redacted()
+		} else {
+			c.DatabaseURL = "REDACTED"
+		}
+	}
+	c.AllowedKEMs = slices.Clone(c.AllowedKEMs)
+	c.FrameAncestors = slices.Clone(c.FrameAncestors)
+	return c
+}
+
+// Print writes the redacted config as YAML, in the file format Load reads.
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
 }
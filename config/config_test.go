@@ -0,0 +1,129 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/config"
+)
+
+func env(m map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) { v, ok := m[k]; return v, ok }
+}
+
+func writeFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, "nb.yaml", `
+port: "8000"
+database_url: postgres://file
+max_blob_bytes: 1000
+idempotency_ttl: 1h
+allowed_kems: [ML-KEM-768]
+log:
+  level: debug
+`)
+	c, err := config.Load(
+		[]string{"-config", file, "-max-blob", "3000"},
+		env(map[string]string{"PORT": "9000", "MAX_BLOB": "2000"}),
+	)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Port != "9000" || c.MaxBlobBytes != 3000 || c.DatabaseURL != "postgres://file" {
+		t.Errorf("precedence wrong: port=%s max=%d db=%s", c.Port, c.MaxBlobBytes, c.DatabaseURL)
+	}
+	if c.IdempotencyTTL != time.Hour || c.Log.Level != "debug" || !slices.Equal(c.AllowedKEMs, []string{"ML-KEM-768"}) {
+		t.Errorf("file values lost: %+v", c)
+	}
+	if c.AdminAddr != config.Default().AdminAddr {
+		t.Errorf("default lost: admin=%s", c.AdminAddr)
+	}
+}
+
+func TestLoad_TOMLViaEnv(t *testing.T) {
+	file := writeFile(t, "nb.toml", `
+database_url = "postgres://toml"
+drain_delay = "2s"
+rate_limit_minute = 60
+
+[tracing]
+exporter = "stdout"
+`)
+	c, err := config.Load(nil, env(map[string]string{config.EnvFile: file}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.DatabaseURL != "postgres://toml" || c.DrainDelay != 2*time.Second ||
+		c.RateLimitMinute != 60 || c.Tracing.Exporter != "stdout" {
+		t.Errorf("toml not applied: %+v", c)
+	}
+}
+
+func TestLoad_Rejects(t *testing.T) {
+	typo := writeFile(t, "nb.yaml", "database_url: x\nmax_blob: 5\n")
+	if _, err := config.Load([]string{"-config", typo}, env(nil)); err == nil {
+		t.Error("unknown file key should be rejected")
+	}
+
+	_, err := config.Load(nil, env(map[string]string{
+		"PORT": "99999", "LOG_LEVEL": "loud", "ALLOWED_KEMS": "RSA",
+		"FRAME_ANCESTORS": "'self'; script-src *",
+	}))
+	for _, want := range []string{"port", "database_url", "log.level", "allowed_kems", "frame_ancestors"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("validation should report %s, got %v", want, err)
+		}
+	}
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	c := config.Default()
+	c.DatabaseURL = "postgres://noisy:buffer@db:5432/nb"
+	var buf bytes.Buffer
+	if err := c.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "buffer@") || !strings.Contains(out, "noisy:xxxxx@db") {
+		t.Errorf("password not redacted:\n%s", out)
+	}
+	if !strings.Contains(out, "idempotency_ttl: 24h0m0s") {
+		t.Errorf("durations should print readably:\n%s", out)
+	}
+}
+
+func TestLive_Reload(t *testing.T) {
+	c := config.Default()
+	c.DatabaseURL = "postgres://a"
+	live := config.NewLive(c)
+
+	next := c
+	next.RateLimitMinute = 30
+	next.Log.Level = "warn"
+	next.Port = "4321"
+	ignored := live.Reload(next)
+
+	got := live.Load()
+	if got.RateLimitMinute != 30 || got.Log.Level != "warn" {
+		t.Errorf("reloadable settings not applied: %+v", got)
+	}
+	if got.Port != c.Port {
+		t.Errorf("port must not change without a restart")
+	}
+	if !slices.Equal(ignored, []string{"port"}) {
+		t.Errorf("ignored = %v, want [port]", ignored)
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// Live holds the running Config. Readers call Load on every use, so a
+// Reload takes effect for the next request without restarting.
+type Live struct {
+	p atomic.Pointer[Config]
+}
+
+func NewLive(c Config) *Live {
+	l := &Live{}
+	l.p.Store(&c)
+	return l
+}
+
+// Load returns the current config. Callers must not modify it.
+func (l *Live) Load() *Config { return l.p.Load() }
+
+// Reload adopts the reloadable settings of next: allowed KEMs, rate limits,
+// frame ancestors and the log level. It returns the names of other settings that differ
+// from the running config; those need a restart and are left unchanged.
+func (l *Live) Reload(next Config) (ignored []string) {
+	cur := *l.Load()
+	upd := cur
+	upd.AllowedKEMs = slices.Clone(next.AllowedKEMs)
+	upd.RateLimitBurst = next.RateLimitBurst
+	upd.RateLimitMinute = next.RateLimitMinute
+	upd.FrameAncestors = slices.Clone(next.FrameAncestors)
+	upd.Log.Level = next.Log.Level
+	l.p.Store(&upd)
+
+	// Compare the rest by making the reloadable parts equal first.
+	next.AllowedKEMs = upd.AllowedKEMs
+	next.RateLimitBurst, next.RateLimitMinute = upd.RateLimitBurst, upd.RateLimitMinute
+	next.FrameAncestors = upd.FrameAncestors
+	next.Log.Level = upd.Log.Level
+	return diff(upd, next)
+}
+
+func diff(a, b Config) []string {
+	var out []string
+	add := func(name string, differs bool) {
+		if differs {
+			out = append(out, name)
+		}
+	}
+	add("port", a.Port != b.Port)
+	add("admin_addr", a.AdminAddr != b.AdminAddr)
+	add("unix_socket", a.UnixSocket != b.UnixSocket)
+	add("socket_mode", a.SocketMode != b.SocketMode)
+	add("database_url", a.DatabaseURL != b.DatabaseURL)
+	add("web_dir", a.WebDir != b.WebDir)
+	add("max_blob_bytes", a.MaxBlobBytes != b.MaxBlobBytes)
+	add("max_batch_bytes", a.MaxBatchBytes != b.MaxBatchBytes)
+	add("idempotency_ttl", a.IdempotencyTTL != b.IdempotencyTTL)
+	add("max_reply_bytes", a.MaxReplyBytes != b.MaxReplyBytes)
+	add("reply_ttl", a.ReplyTTL != b.ReplyTTL)
+	add("mailbox_replies", a.MailboxReplies != b.MailboxReplies)
+	add("drain_delay", a.DrainDelay != b.DrainDelay)
+	add("audit_log", a.AuditLog != b.AuditLog)
+	add("receipt_key_file", a.ReceiptKeyFile != b.ReceiptKeyFile)
+	add("webhook_allow_private", a.WebhookAllowPrivate != b.WebhookAllowPrivate)
+	add("webhook_parked_ttl", a.WebhookParkedTTL != b.WebhookParkedTTL)
+	add("tls", a.TLS != b.TLS)
+	add("log", a.Log != b.Log)
+	add("metrics", a.Metrics != b.Metrics)
+	add("tracing", a.Tracing != b.Tracing)
+	return out
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvFile names the environment variable holding the config file path; the
+// -config flag wins over it.
+const EnvFile = "NB_CONFIG"
+
+// setting binds one Config field to its environment variable and flag.
+type setting struct {
+	env, flag, usage string
+	set              func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"PORT", "port", "public HTTP port", str(func(c *Config) *string { return &c.Port })},
+	{"ADMIN_ADDR", "admin-addr", "admin listener address (metrics, probes)", str(func(c *Config) *string { return &c.AdminAddr })},
+	{"UNIX_SOCKET", "unix-socket", "serve the public API on this Unix socket instead of the port", str(func(c *Config) *string { return &c.UnixSocket })},
+	{"SOCKET_MODE", "socket-mode", "octal permissions of the Unix socket", str(func(c *Config) *string { return &c.SocketMode })},
+	{"DATABASE_URL", "database-url", "Postgres connection URL", str(func(c *Config) *string { return &c.DatabaseURL })},
+	{"WEB_DIR", "web-dir", "serve web assets from this directory", str(func(c *Config) *string { return &c.WebDir })},
+	{"MAX_BLOB", "max-blob", "largest accepted ciphertext in bytes", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		c.MaxBlobBytes = n
+		return err
+	}},
+	{"MAX_BATCH_BYTES", "max-batch-bytes", "largest accepted push:batch body in bytes", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		c.MaxBatchBytes = n
+		return err
+	}},
+	{"IDEMPOTENCY_TTL", "idempotency-ttl", "replay window of idempotency keys", dur(func(c *Config) *time.Duration { return &c.IdempotencyTTL })},
+	{"MAX_REPLY", "max-reply", "largest accepted owner reply in bytes", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		c.MaxReplyBytes = n
+		return err
+	}},
+	{"REPLY_TTL", "reply-ttl", "how long replies wait in their mailbox", dur(func(c *Config) *time.Duration { return &c.ReplyTTL })},
+	{"MAILBOX_REPLIES", "mailbox-replies", "unexpired replies a mailbox may hold", integer(func(c *Config) *int { return &c.MailboxReplies })},
+	{"AUDIT_LOG", "audit-log", `append the audit trail to this file ("-": stderr)`, str(func(c *Config) *string { return &c.AuditLog })},
+	{"RECEIPT_KEY_FILE", "receipt-key-file", "PEM Ed25519 key that signs erasure receipts (unset: a throwaway key)", str(func(c *Config) *string { return &c.ReceiptKeyFile })},
+	{"WEBHOOK_ALLOW_PRIVATE", "webhook-allow-private", "let webhooks target loopback, private and link-local addresses", boolean(func(c *Config) *bool { return &c.WebhookAllowPrivate })},
+	{"WEBHOOK_PARKED_TTL", "webhook-parked-ttl", "how long webhook deliveries that ran out of retries are kept", dur(func(c *Config) *time.Duration { return &c.WebhookParkedTTL })},
+	{"DRAIN_DELAY", "drain-delay", "wait between failing readiness and draining", dur(func(c *Config) *time.Duration { return &c.DrainDelay })},
+	{"ALLOWED_KEMS", "allowed-kems", "comma-separated KEMs accepted at key registration", list(func(c *Config) *[]string { return &c.AllowedKEMs })},
+	{"RATE_LIMIT_BURST", "rate-limit-burst", "requests a client may burst", integer(func(c *Config) *int { return &c.RateLimitBurst })},
+	{"RATE_LIMIT_PER_MINUTE", "rate-limit-per-minute", "sustained requests per client IP and minute (0: off; not applied on a Unix socket)", integer(func(c *Config) *int { return &c.RateLimitMinute })},
+	{"FRAME_ANCESTORS", "frame-ancestors", "comma-separated CSP sources that may frame the web UI", list(func(c *Config) *[]string { return &c.FrameAncestors })},
+	{"TLS_CERT_FILE", "tls-cert-file", "PEM certificate chain; enables HTTPS", str(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"TLS_KEY_FILE", "tls-key-file", "PEM private key for tls-cert-file", str(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"TLS_ADMIN_CLIENT_CA", "tls-admin-client-ca", "PEM CAs for admin client certificates; enables mTLS there", str(func(c *Config) *string { return &c.TLS.AdminClientCA })},
+	{"LOG_LEVEL", "log-level", "debug, info, warn or error", str(func(c *Config) *string { return &c.Log.Level })},
+	{"LOG_FORMAT", "log-format", "text or json", str(func(c *Config) *string { return &c.Log.Format })},
+	{"LOG_CLIENT_IP", "log-client-ip", "include client IPs in logs", boolean(func(c *Config) *bool { return &c.Log.ClientIPs })},
+	{"LOG_APP_IDS", "log-app-ids", "include app IDs in logs", boolean(func(c *Config) *bool { return &c.Log.AppIDs })},
+	{"METRICS_PER_APP", "metrics-per-app", "label submission metrics with app IDs", boolean(func(c *Config) *bool { return &c.Metrics.PerApp })},
+	{"TRACES_EXPORTER", "traces-exporter", "none, stdout, file or otlp", str(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"TRACES_FILE", "traces-file", "span file for the file exporter", str(func(c *Config) *string { return &c.Tracing.File })},
+	{"TRACES_SAMPLE_RATIO", "traces-sample-ratio", "share of new traces recorded", func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		c.Tracing.SampleRatio = f
+		return err
+	}},
+}
+
+// Load builds and validates a Config. args are the command-line arguments
+// without the program name; lookupEnv is normally os.LookupEnv.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	fs := flag.NewFlagSet("noisybufferd", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	file := fs.String("config", "", "YAML or TOML config file (env "+EnvFile+")")
+	for _, s := range settings {
+		fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if *file == "" {
+		*file, _ = lookupEnv(EnvFile)
+	}
+
+	c := Default()
+	if *file != "" {
+		if err := c.loadFile(*file); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok && v != "" {
+			if err := s.set(&c, v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := s.set(&c, f.Value.String()); err != nil {
+					flagErr = errors.Join(flagErr, fmt.Errorf("-%s: %w", s.flag, err))
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+	return c, c.Validate()
+}
+
+// Usage prints the accepted flags and their environment variables.
+func Usage(w io.Writer) {
+	fmt.Fprintf(w, "  -config path\n\tYAML or TOML config file (env %s)\n", EnvFile)
+	for _, s := range settings {
+		fmt.Fprintf(w, "  -%s value\n\t%s (env %s)\n", s.flag, s.usage, s.env)
+	}
+}
+
+// loadFile overlays the file onto c. The format follows the extension;
+// unknown keys are errors so typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config file %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("config file %s: want .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+func str(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error { *field(c) = v; return nil }
+}
+
+func list(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field(c) = append(*field(c), item)
+			}
+		}
+		return nil
+	}
+}
+
+func integer(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		*field(c) = n
+		return err
+	}
+}
+
+func boolean(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		*field(c) = b
+		return err
+	}
+}
+
+func dur(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		*field(c) = d
+		return err
+	}
+}
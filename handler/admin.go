@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/collapsinghierarchy/noisybuffer/service"
+)
+
+// Admin serves operator endpoints. It belongs on the admin listener only:
+// the routes carry no authentication of their own beyond that listener's
+// address and optional client certificates.
+type Admin struct {
+	svc *service.Service
+	log *slog.Logger
+}
+
+func NewAdmin(svc *service.Service, log *slog.Logger) *Admin {
+	return &Admin{svc: svc, log: log}
+}
+
+type legalHoldReq struct {
+	Hold bool `json:"hold"`
+}
+
+// Register adds the admin routes to mux.
+func (a *Admin) Register(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /admin/v1/apps/{appID}/legal-hold", a.SetLegalHold)
+	mux.HandleFunc("POST /admin/v1/apps/{appID}/owner-token", a.ResetOwnerToken)
+}
+
+// SetLegalHold places or lifts a legal hold, which suspends retention
+// deletions for the app.
+func (a *Admin) SetLegalHold(w http.ResponseWriter, r *http.Request) {
+	appID, err := uuid.Parse(r.PathValue("appID"))
+	if err != nil {
+		http.Error(w, "invalid app id", http.StatusBadRequest)
+		return
+	}
+	var req legalHoldReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, jsonEnvelope)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = a.svc.SetLegalHold(r.Context(), appID, req.Hold)
+	if errors.Is(err, service.ErrAppNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		a.log.ErrorContext(r.Context(), "legal hold", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.log.InfoContext(r.Context(), "legal hold changed", "hold", req.Hold)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetOwnerToken answers {"ownerToken": …} with a fresh token for the
+// app; the previous one stops working.
+func (a *Admin) ResetOwnerToken(w http.ResponseWriter, r *http.Request) {
+	appID, err := uuid.Parse(r.PathValue("appID"))
+	if err != nil {
+		http.Error(w, "invalid app id", http.StatusBadRequest)
+		return
+	}
+	token, err := a.svc.ResetOwnerToken(r.Context(), appID)
+	if errors.Is(err, service.ErrAppNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		a.log.ErrorContext(r.Context(), "owner token reset", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.log.InfoContext(r.Context(), "owner token reset")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(registerKeyResp{Message: "owner token reset", OwnerToken: token})
+}
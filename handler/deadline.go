@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+)
+
+// Per-route deadlines. noisybufferd runs its http.Server without
+// Read/WriteTimeout, so every route picks its own through
+// http.ResponseController.
+const (
+	ShortDeadline  = 15 * time.Second // key, pub, push, owner endpoints
+	BatchDeadline  = 60 * time.Second // push:batch bodies can be large
+	StreamDeadline = 30 * time.Second // pull/stream: idle window, extended on every write
+)
+
+// Deadline bounds reading the request and writing the response to d.
+func Deadline(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			deadline := time.Now().Add(d)
+			_ = rc.SetReadDeadline(deadline) // ErrNotSupported on some writers
+			_ = rc.SetWriteDeadline(deadline)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Streaming gives long responses a sliding write deadline: every write
+// pushes it window into the future, so a response may run for hours but
+// is cut once the client stops reading for window. Handlers that may sit
+// idle must write heartbeats more often than window.
+func Streaming(window time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(time.Now().Add(ShortDeadline))
+			_ = rc.SetWriteDeadline(time.Now().Add(window))
+			next.ServeHTTP(&slidingWriter{ResponseWriter: w, rc: rc, window: window}, r)
+		})
+	}
+}
+
+type slidingWriter struct {
+	http.ResponseWriter
+	rc     *http.ResponseController
+	window time.Duration
+}
+
+func (s *slidingWriter) Write(p []byte) (int, error) {
+	_ = s.rc.SetWriteDeadline(time.Now().Add(s.window))
+	return s.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach Flush and friends.
+func (s *slidingWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
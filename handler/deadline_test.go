@@ -0,0 +1,52 @@
+package handler_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/handler"
+)
+
+func TestDeadline_CutsSlowResponse(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = io.WriteString(w, "too late")
+	})
+	srv := httptest.NewServer(handler.Deadline(50 * time.Millisecond)(slow))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err == nil {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) == "too late" {
+			t.Fatal("response should have been cut by the write deadline")
+		}
+	}
+}
+
+func TestStreaming_ExtendsOnWrite(t *testing.T) {
+	chatty := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		for i := 0; i < 10; i++ {
+			_, _ = io.WriteString(w, ".")
+			_ = rc.Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	})
+	srv := httptest.NewServer(handler.Streaming(100 * time.Millisecond)(chatty))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != ".........." {
+		t.Fatalf("stream cut short: %q, %v", body, err)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/service"
+	"github.com/google/uuid"
+)
+
+// FormPath is where hosted forms live: FormPath + appID.
+const FormPath = "/f/"
+
+//go:embed templates/form.html
+var templates embed.FS
+
+var formTemplate = template.Must(template.ParseFS(templates, "templates/form.html"))
+
+// FormPage renders an app's hosted form: a plain HTML page built from the
+// stored definition that loads nb.js (by its fingerprinted URL, pinned
+// with SRI) to seal and push what is entered. Serve it on the web UI's
+// mux, under CSPWeb, as GET FormPath+"{appID}".
+type FormPage struct {
+	svc     *service.Service
+	script  SRIEntry
+	apiBase string
+}
+
+// NewFormPage takes nb.js from assets; apiBase is the API prefix the page
+// pushes to, as seen by the browser (e.g. "/api/nb/v1").
+func NewFormPage(svc *service.Service, assets *Static, apiBase string) (*FormPage, error) {
+	script, ok := assets.Manifest()["nb.js"]
+	if !ok {
+		return nil, errors.New("form page: nb.js is not fingerprinted")
+	}
+	return &FormPage{svc: svc, script: script, apiBase: apiBase}, nil
+}
+
+type formPageData struct {
+	AppID   uuid.UUID
+	Version int
+	Form    model.Form
+	Script  SRIEntry
+	APIBase string
+}
+
+// ServeHTTP renders the newest version, or ?version=N. The version is
+// sealed into each submission as "_nb_form_version".
+func (p *FormPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	appID, err := uuid.Parse(r.PathValue("appID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "version must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	d, err := p.svc.Form(r.Context(), appID, version)
+	if errors.Is(err, service.ErrFormNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = formTemplate.Execute(&buf, formPageData{
+		AppID:   appID,
+		Version: d.Version,
+		Form:    d.Form,
+		Script:  p.script,
+		APIBase: p.apiBase,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache") // owners may publish a new version any time
+	_, _ = buf.WriteTo(w)
+}
@@ -3,22 +3,62 @@ package handler
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/justinas/alice"
+	"go.opentelemetry.io/otel"
 
+	"github.com/collapsinghierarchy/noisybuffer/config"
+	"github.com/collapsinghierarchy/noisybuffer/logging"
+	"github.com/collapsinghierarchy/noisybuffer/metrics"
 	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/receipt"
 	"github.com/collapsinghierarchy/noisybuffer/service"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
 )
 
+var tracer = otel.Tracer("github.com/collapsinghierarchy/noisybuffer/handler")
+
 // Server bundles dependencies for HTTP handlers.
 type Server struct {
-	svc *service.Service
+	svc     *service.Service
+	limiter *rateLimiter
+	log     *slog.Logger
+	metrics *metrics.Metrics // nil: not instrumented
+}
+
+// Option tweaks a Server at construction time.
+type Option func(*Server)
+
+// WithLogger sets the logger for access and error logs. Build it with
+// logging.New so the privacy policy applies.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) { s.log = l }
+}
+
+// WithMetrics records request counts and latencies per route in m.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *Server) { s.metrics = m }
 }
 
 // New constructs a ready-to-use Server instance.
-func New(svc *service.Service) *Server { return &Server{svc: svc} }
+func New(svc *service.Service, cfg *config.Live, opts ...Option) *Server {
+	s := &Server{svc: svc, limiter: newRateLimiter(cfg), log: slog.Default()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
 
 // ------------------------------------------------------------
 // Request & Response Structs
@@ -31,7 +71,8 @@ type registerKeyReq struct {
 }
 
 type registerKeyResp struct {
-	Message string `json:"message"`
+	Message    string `json:"message"`
+	OwnerToken string `json:"ownerToken"` // bearer token for owner endpoints, shown once
 }
 
 type publicKeyReq struct {
@@ -39,20 +80,247 @@ type publicKeyReq struct {
 }
 
 type publicKeyResp struct {
-	Kid uint8  `json:"kid"`
-	Pub string `json:"pub"` // base64
+	Kid     uint8         `json:"kid"`
+	Pub     string        `json:"pub"` // base64
+	Padding model.Padding `json:"padding"`
 }
 
 type pushRequest struct {
-	AppID string `json:"appID"` // UUID (base‑36)
-	Kid   uint8  `json:"kid"`   // Key‑ID used for envelope encryption
-	Blob  string `json:"blob"`  // base64(ciphertext)
+	AppID          string `json:"appID"`                    // UUID (base‑36)
+	Kid            uint8  `json:"kid"`                      // Key‑ID used for envelope encryption
+	Blob           string `json:"blob"`                     // base64(ciphertext)
+	IdempotencyKey string `json:"idempotencyKey,omitempty"` // alternative to the header
+
+	BurnAfterReading bool   `json:"burnAfterReading,omitempty"` // alternative to the header
+	DeletionToken    bool   `json:"deletionToken,omitempty"`    // alternative to the header
+	UploadToken      string `json:"uploadToken,omitempty"`      // alternative to the header
+	FormID           string `json:"formID,omitempty"`           // alternative to the header
 }
 
+// Headers carrying the routing info for binary (application/octet-stream)
+// pushes, where the body is the raw ciphertext.
+const (
+	HeaderAppID = "X-NB-App-ID"
+	HeaderKid   = "X-NB-Kid"
+)
+
+// Idempotency headers: the client names its push with HeaderIdempotencyKey
+// and a replayed response is flagged with HeaderIdempotentReplay.
+const (
+	HeaderIdempotencyKey   = "Idempotency-Key"
+	HeaderIdempotentReplay = "Idempotent-Replayed"
+)
+
+// Boolean push headers ("true"/"1"). HeaderBurnAfterReading asks for the
+// submission to be deleted as soon as the owner acknowledges it;
+// HeaderDeletionToken asks for a deletion token in the response, with
+// which the submitter can withdraw the submission later.
+const (
+	HeaderBurnAfterReading = "X-NB-Burn-After-Reading"
+	HeaderDeletionToken    = "X-NB-Deletion-Token"
+)
+
+// HeaderUploadToken carries an owner-minted upload token on pushes; apps
+// in dead-drop mode require one.
+const HeaderUploadToken = "X-NB-Upload-Token"
+
+// HeaderFormID names the registered form a push comes from.
+const HeaderFormID = "X-NB-Form-ID"
+
+// UploadPage is the hosted upload page for upload links. The app ID and
+// token go in the URL fragment, which browsers never send to the server.
+const UploadPage = "/upload.html"
+
+// jsonEnvelope is the slack allowed on top of the base64 blob for the rest
+// of the JSON push body.
+const jsonEnvelope = 1 << 10
+
 type pushResp struct {
-	Message string `json:"message"`
+	Message string    `json:"message"`
+	ID      string    `json:"id"` // submission ID, stable across replays
+	TS      time.Time `json:"ts"`
+
+	// DeletionToken authorizes DELETE /nb/v1/submissions/{id}. Only on
+	// request, and only in the first response: replays cannot repeat it.
+	DeletionToken string `json:"deletionToken,omitempty"`
+}
+
+type batchItemReq struct {
+	AppID    string `json:"appID"`
+	Kid      uint8  `json:"kid"`
+	Blob     string `json:"blob"`               // base64(ciphertext)
+	ClientID string `json:"clientID,omitempty"` // opaque, echoed back
+
+	BurnAfterReading bool   `json:"burnAfterReading,omitempty"`
+	FormID           string `json:"formID,omitempty"`
+}
+
+type batchItemResp struct {
+	ClientID string `json:"clientID,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+type batchResp struct {
+	Results []batchItemResp `json:"results"`
+}
+
+type webhookReq struct {
+	URL         string `json:"url"`
+	IncludeBlob bool   `json:"includeBlob"`
+	FormID      string `json:"formID,omitempty"` // only this form's submissions
+}
+
+type webhookResp struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	IncludeBlob bool      `json:"includeBlob"`
+	FormID      string    `json:"formID,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Secret      string    `json:"secret,omitempty"` // base64, only on create
+}
+
+// retentionBody is an app's retention policy; zero fields are off.
+type retentionBody struct {
+	MaxAgeSeconds int64 `json:"maxAgeSeconds"`
+	MaxCount      int   `json:"maxCount"`
+	DeleteAcked   bool  `json:"deleteAcked"`
+	LegalHold     bool  `json:"legalHold"` // read-only: set by the operator
+
+	BurnAfterReading bool `json:"burnAfterReading"` // delete on ack
+}
+
+type ackReq struct {
+	IDs []string `json:"ids"` // submission IDs the owner decrypted
+}
+
+type ackResp struct {
+	Acked  int64 `json:"acked"`  // newly acknowledged and kept
+	Burned int64 `json:"burned"` // deleted, being burn-after-reading
+}
+
+type uploadTokenReq struct {
+	Label            string `json:"label"`
+	MaxUses          int    `json:"maxUses"`          // 0: one use
+	ExpiresInSeconds int64  `json:"expiresInSeconds"` // 0: a week
+}
+
+type uploadTokenResp struct {
+	ID        string     `json:"id"`
+	Label     string     `json:"label,omitempty"`
+	MaxUses   int        `json:"maxUses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+
+	// Only on create: the token and the upload page path carrying it.
+	Token string `json:"token,omitempty"`
+	Link  string `json:"link,omitempty"`
+}
+
+type deadDropBody struct {
+	Enabled bool `json:"enabled"`
+}
+
+// formResp is one version of a hosted form definition.
+type formResp struct {
+	AppID     string    `json:"appID"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Link      string    `json:"link"` // page rendering this version
+	model.Form
+}
+
+// appFormBody registers a form ID; zero limits are off.
+type appFormBody struct {
+	ID              string    `json:"id"` // from the path; ignored in requests
+	Label           string    `json:"label,omitempty"`
+	MaxAgeSeconds   int64     `json:"maxAgeSeconds"`
+	MaxCount        int       `json:"maxCount"`
+	RateLimitMinute int       `json:"rateLimitMinute"`
+	RateLimitBurst  int       `json:"rateLimitBurst"`
+	CreatedAt       time.Time `json:"createdAt"` // responses only
+}
+
+// maxFormBody bounds a form definition.
+const maxFormBody = 64 << 10
+
+// maxAckBody bounds an ack request: MaxAckIDs quoted UUIDs and commas.
+const maxAckBody = service.MaxAckIDs*40 + jsonEnvelope
+
+// erasureResp answers an owner deletion. Receipt is the compact JWS to
+// file; Erasure is its payload, for convenience.
+type erasureResp struct {
+	Erasure *receipt.Erasure `json:"erasure"`
+	Receipt string           `json:"receipt"`
+}
+
+// jwkSet lists the keys that verify erasure receipts.
+type jwkSet struct {
+	Keys []receipt.JWK `json:"keys"`
+}
+
+// replyReq is an owner's sealed answer for a submitter's mailbox.
+type replyReq struct {
+	AppID   string `json:"appID"`
+	Mailbox string `json:"mailbox"`
+	Blob    string `json:"blob"` // base64(HPKE ciphertext to the submitter's reply key)
+}
+
+type replyResp struct {
+	ID        string    `json:"id"`
+	TS        time.Time `json:"ts"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// mailboxResp lists a mailbox's replies, oldest first.
+type mailboxResp struct {
+	Replies []replyLine `json:"replies"`
+}
+
+type replyLine struct {
+	ID        string    `json:"id"`
+	TS        time.Time `json:"ts"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Blob      string    `json:"blob"` // base64(ciphertext)
 }
 
+// pullLine is one line of /nb/v1/pull?format=ndjson.
+type pullLine struct {
+	ID   string    `json:"id"`
+	Kid  uint8     `json:"kid"`
+	TS   time.Time `json:"ts"`
+	Blob string    `json:"blob"`           // base64(ciphertext)
+	Burn bool      `json:"burn,omitempty"` // deleted once acknowledged
+
+	UploadToken string `json:"uploadToken,omitempty"` // ID of the upload token used
+	FormID      string `json:"formID,omitempty"`
+}
+
+// streamEvent is the data of one "submission" event on /nb/v1/stream.
+type streamEvent struct {
+	ID    string    `json:"id"`
+	AppID string    `json:"appID"`
+	Kid   uint8     `json:"kid"`
+	TS    time.Time `json:"ts"`
+	Size  int       `json:"size"`
+	Blob  string    `json:"blob"`           // base64(ciphertext)
+	Burn  bool      `json:"burn,omitempty"` // deleted once acknowledged
+
+	UploadToken string `json:"uploadToken,omitempty"` // ID of the upload token used
+	FormID      string `json:"formID,omitempty"`
+}
+
+// streamHeartbeat keeps idle SSE connections (and proxies) alive; it must
+// stay below StreamDeadline, which each write extends.
+const streamHeartbeat = StreamDeadline / 2
+
+// TrailerStreamError is set as an HTTP trailer when Pull fails after the
+// body has started, in place of a status code that can no longer be sent.
+const TrailerStreamError = "X-NB-Stream-Error"
+
 type pullRequest struct {
 	AppID string `json:"appID"`
 }
@@ -61,25 +329,52 @@ type pullRequest struct {
 // Router
 // ------------------------------------------------------------
 
-func SetupNBRoutes(svc *service.Service) http.Handler {
-	srv := New(svc)
+func SetupNBRoutes(svc *service.Service, cfg *config.Live, opts ...Option) http.Handler {
+	srv := New(svc, cfg, opts...)
+
+	short := Deadline(ShortDeadline)
+	stream := Streaming(StreamDeadline)
+	limited := srv.limiter.limit // anonymous writes, per client IP
 
 	mux := http.NewServeMux()
-	mux.Handle("POST /nb/v1/key", http.HandlerFunc(srv.RegisterKey))
-	mux.Handle("GET /nb/v1/pub", http.HandlerFunc(srv.PublicKey))
-	mux.Handle("POST /nb/v1/push", http.HandlerFunc(srv.Push))
-	mux.Handle("GET /nb/v1/pull", http.HandlerFunc(srv.Pull))
+	mux.Handle("POST /nb/v1/key", short(limited(http.HandlerFunc(srv.RegisterKey))))
+	mux.Handle("GET /nb/v1/pub", short(http.HandlerFunc(srv.PublicKey)))
+	mux.Handle("POST /nb/v1/push", short(limited(http.HandlerFunc(srv.Push))))
+	mux.Handle("POST /nb/v1/push/{appID}/{kid}", short(limited(http.HandlerFunc(srv.PushRaw))))
+	mux.Handle("POST /nb/v1/push:batch", Deadline(BatchDeadline)(limited(http.HandlerFunc(srv.PushBatch))))
+	mux.Handle("GET /nb/v1/pull", stream(http.HandlerFunc(srv.Pull)))
+	mux.Handle("GET /nb/v1/stream", stream(http.HandlerFunc(srv.Stream)))
+	mux.Handle("DELETE /nb/v1/submissions/{id}", short(limited(http.HandlerFunc(srv.WithdrawSubmission))))
+	mux.Handle("POST /nb/v1/reply", short(http.HandlerFunc(srv.Reply)))
+	mux.Handle("GET /nb/v1/reply/{mailbox}", short(limited(http.HandlerFunc(srv.Mailbox))))
+	mux.Handle("DELETE /nb/v1/reply/{mailbox}", short(limited(http.HandlerFunc(srv.EmptyMailbox))))
+	mux.Handle("GET /nb/v1/form/{appID}", short(http.HandlerFunc(srv.GetForm)))
 
-	chain := alice.New(logRequest)
-	return chain.Then(mux)
-}
+	// owner endpoints: Authorization: Bearer <ownerToken>
+	mux.Handle("POST /nb/v1/apps/{appID}/webhooks", short(srv.ownerOnly(srv.CreateWebhook)))
+	mux.Handle("GET /nb/v1/apps/{appID}/webhooks", short(srv.ownerOnly(srv.ListWebhooks)))
+	mux.Handle("DELETE /nb/v1/apps/{appID}/webhooks/{id}", short(srv.ownerOnly(srv.DeleteWebhook)))
+	mux.Handle("GET /nb/v1/apps/{appID}/retention", short(srv.ownerOnly(srv.GetRetention)))
+	mux.Handle("PUT /nb/v1/apps/{appID}/retention", short(srv.ownerOnly(srv.SetRetention)))
+	mux.Handle("POST /nb/v1/apps/{appID}/ack", short(srv.ownerOnly(srv.Ack)))
+	mux.Handle("DELETE /nb/v1/apps/{appID}/submissions/{id}", short(srv.ownerOnly(srv.DeleteSubmission)))
+	mux.Handle("DELETE /nb/v1/apps/{appID}/submissions", short(srv.ownerOnly(srv.DeleteRange)))
+	mux.Handle("DELETE /nb/v1/apps/{appID}", short(srv.ownerOnly(srv.EraseApp)))
+	mux.Handle("POST /nb/v1/apps/{appID}/upload-tokens", short(srv.ownerOnly(srv.CreateUploadToken)))
+	mux.Handle("GET /nb/v1/apps/{appID}/upload-tokens", short(srv.ownerOnly(srv.ListUploadTokens)))
+	mux.Handle("DELETE /nb/v1/apps/{appID}/upload-tokens/{id}", short(srv.ownerOnly(srv.RevokeUploadToken)))
+	mux.Handle("GET /nb/v1/apps/{appID}/dead-drop", short(srv.ownerOnly(srv.GetDeadDrop)))
+	mux.Handle("PUT /nb/v1/apps/{appID}/dead-drop", short(srv.ownerOnly(srv.SetDeadDrop)))
+	mux.Handle("GET /nb/v1/apps/{appID}/padding", short(srv.ownerOnly(srv.GetPadding)))
+	mux.Handle("PUT /nb/v1/apps/{appID}/padding", short(srv.ownerOnly(srv.SetPadding)))
+	mux.Handle("PUT /nb/v1/apps/{appID}/form", short(srv.ownerOnly(srv.SetForm)))
+	mux.Handle("GET /nb/v1/apps/{appID}/forms", short(srv.ownerOnly(srv.ListAppForms)))
+	mux.Handle("PUT /nb/v1/apps/{appID}/forms/{formID}", short(srv.ownerOnly(srv.PutAppForm)))
+	mux.Handle("DELETE /nb/v1/apps/{appID}/forms/{formID}", short(srv.ownerOnly(srv.DeleteAppForm)))
+	mux.Handle("GET /nb/v1/receipt-keys", short(http.HandlerFunc(srv.ReceiptKeys)))
 
-// logRequest is a tiny middleware printing request method & path.
-func logRequest(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		println(r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-	})
+	chain := alice.New(tracing.Middleware, requestID, srv.accessLog, SecurityHeaders(cfg, CSPAPI))
+	return chain.Then(mux)
 }
 
 // ------------------------------------------------------------
@@ -91,48 +386,43 @@ func (s *Server) RegisterKey(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	println("RegisterKey: received request")
+	ctx := r.Context()
 	var req registerKeyReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		println("RegisterKey: failed to decode JSON:", err.Error())
+		s.log.DebugContext(ctx, "register key: bad json", "err", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	println("RegisterKey: decoded JSON, AppID =", req.AppID, "Kid =", req.Kid)
 	appID, err := uuid.Parse(req.AppID)
 	if err != nil {
-		println("RegisterKey: invalid app id:", req.AppID)
 		http.Error(w, "invalid app id", http.StatusBadRequest)
 		return
 	}
-	// Efficiently check if AppID already exists
-	exists, err := s.svc.Store.AppExists(r.Context(), appID)
+	pub, err := base64.StdEncoding.DecodeString(req.Pub)
 	if err != nil {
-		println("RegisterKey: error checking app existence:", err.Error())
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		http.Error(w, "invalid pub", http.StatusBadRequest)
 		return
 	}
-	if exists {
-		println("RegisterKey: appID already exists:", req.AppID)
-		http.Error(w, "appID already registered", http.StatusConflict) // 409 Conflict
+	// The insert decides, so of two racing registrations only one wins.
+	token, err := s.svc.RegisterKey(ctx, appID, req.Kid, pub)
+	if errors.Is(err, service.ErrKEMNotAllowed) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	pub, err := base64.StdEncoding.DecodeString(req.Pub)
-	if err != nil {
-		println("RegisterKey: invalid pub base64:", req.Pub)
-		http.Error(w, "invalid pub", http.StatusBadRequest)
+	if errors.Is(err, service.ErrKeyExists) {
+		http.Error(w, "appID already registered", http.StatusConflict)
 		return
 	}
-	println("RegisterKey: calling service.RegisterKey")
-	if err := s.svc.RegisterKey(r.Context(), appID, req.Kid, pub); err != nil {
-		println("RegisterKey: service.RegisterKey failed:", err.Error())
+	if err != nil {
+		s.log.ErrorContext(ctx, "register key: store", "err", err, logging.KeyAppID, appID)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	println("RegisterKey: key registered successfully")
+	s.log.InfoContext(ctx, "key registered", logging.KeyAppID, appID, "kid", req.Kid)
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(registerKeyResp{Message: "key registered successfully"}); err != nil {
-		println("RegisterKey: failed to encode JSON response:", err.Error())
+	resp := registerKeyResp{Message: "key registered successfully", OwnerToken: token}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.log.DebugContext(ctx, "register key: write response", "err", err)
 	}
 }
 
@@ -160,23 +450,47 @@ func (s *Server) PublicKey(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	pad, err := s.svc.Padding(r.Context(), appID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	resp := publicKeyResp{
-		Kid: kid,
-		Pub: base64.StdEncoding.EncodeToString(pub),
+		Kid:     kid,
+		Pub:     base64.StdEncoding.EncodeToString(pub),
+		Padding: pad,
 	}
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
 // Push ingests one encrypted blob: {appID, kid, blob (base64)}.
+// With Content-Type: application/octet-stream the body is the raw
+// ciphertext instead and appID/kid travel in the X-NB-App-ID / X-NB-Kid
+// headers.
 func (s *Server) Push(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/octet-stream" {
+		s.pushBinary(w, r, r.Header.Get(HeaderAppID), r.Header.Get(HeaderKid))
+		return
+	}
 
 	// ----- decode JSON ------------------------------------------------
+	limit := base64.StdEncoding.EncodedLen(int(s.svc.MaxBlob())) + jsonEnvelope
+	r.Body = http.MaxBytesReader(w, r.Body, int64(limit))
+
+	_, decodeSpan := tracer.Start(r.Context(), "handler.decodePush")
 	var req pushRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	err := json.NewDecoder(r.Body).Decode(&req)
+	decodeSpan.End()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, service.ErrBlobTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -187,37 +501,263 @@ func (s *Server) Push(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ----- pre-flight: does this App exist? ---------------------------
-	exists, err := s.svc.Store.AppExists(r.Context(), appID)
+	// ----- decode blob ------------------------------------------------
+	// (the app itself is checked once, by service.Push)
+	blobBytes, err := base64.StdEncoding.DecodeString(req.Blob)
+	if err != nil {
+		http.Error(w, "invalid blob", http.StatusBadRequest)
+		return
+	}
+
+	// ----- persist ----------------------------------------------------
+	key := r.Header.Get(HeaderIdempotencyKey)
+	if key == "" {
+		key = req.IdempotencyKey
+	}
+	opts, err := pushOptions(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if !exists {
-		http.Error(w, "app not found", http.StatusNotFound)
+	if req.BurnAfterReading {
+		opts = append(opts, service.WithBurnAfterReading(true))
+	}
+	if req.DeletionToken {
+		opts = append(opts, service.WithDeletionToken(true))
+	}
+	if req.UploadToken != "" {
+		opts = append(opts, service.WithUploadToken(req.UploadToken))
+	}
+	if req.FormID != "" {
+		opts = append(opts, service.WithFormID(req.FormID))
+	}
+	rcpt, err := s.svc.Push(r.Context(), appID, req.Kid, blobBytes,
+		append(opts, service.WithIdempotencyKey(key))...)
+	if err != nil {
+		writePushError(w, err)
 		return
 	}
 
-	// ----- decode blob ------------------------------------------------
-	blobBytes, err := base64.StdEncoding.DecodeString(req.Blob)
+	// ----- done -------------------------------------------------------
+	writeReceipt(w, rcpt)
+}
+
+// PushRaw ingests one raw ciphertext body at /nb/v1/push/{appID}/{kid}.
+func (s *Server) PushRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.pushBinary(w, r, r.PathValue("appID"), r.PathValue("kid"))
+}
+
+// pushBinary streams an un-encoded ciphertext body into the service. The
+// body is capped by MaxBytesReader, so MAX_BLOB is enforced while reading.
+func (s *Server) pushBinary(w http.ResponseWriter, r *http.Request, appIDStr, kidStr string) {
+	appID, err := uuid.Parse(appIDStr)
 	if err != nil {
-		http.Error(w, "invalid blob", http.StatusBadRequest)
+		http.Error(w, "invalid app id", http.StatusBadRequest)
+		return
+	}
+	kid, err := strconv.ParseUint(kidStr, 10, 8)
+	if err != nil {
+		http.Error(w, "invalid kid", http.StatusBadRequest)
 		return
 	}
 
-	// ----- persist ----------------------------------------------------
-	if err := s.svc.Push(r.Context(), appID, req.Kid, blobBytes); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	opts, err := pushOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// ----- done -------------------------------------------------------
+	body := http.MaxBytesReader(w, r.Body, s.svc.MaxBlob())
+	opts = append(opts, service.WithIdempotencyKey(r.Header.Get(HeaderIdempotencyKey)))
+	rcpt, err := s.svc.PushReader(r.Context(), appID, uint8(kid), body, opts...)
+	if err != nil {
+		writePushError(w, err)
+		return
+	}
+	writeReceipt(w, rcpt)
+}
+
+// pushOptions turns the push headers into service options.
+func pushOptions(r *http.Request) ([]service.PushOption, error) {
+	var opts []service.PushOption
+	if token := r.Header.Get(HeaderUploadToken); token != "" {
+		opts = append(opts, service.WithUploadToken(token))
+	}
+	if form := r.Header.Get(HeaderFormID); form != "" {
+		opts = append(opts, service.WithFormID(form))
+	}
+	for _, h := range []struct {
+		name string
+		opt  func(bool) service.PushOption
+	}{
+		{HeaderBurnAfterReading, service.WithBurnAfterReading},
+		{HeaderDeletionToken, service.WithDeletionToken},
+	} {
+		name, opt := h.name, h.opt
+		v := r.Header.Get(name)
+		if v == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("invalid " + name + " header")
+		}
+		opts = append(opts, opt(on))
+	}
+	return opts, nil
+}
+
+// writeReceipt answers a successful push. Replays get the same body as the
+// original push plus the Idempotent-Replayed header.
+func writeReceipt(w http.ResponseWriter, rcpt *service.Receipt) {
+	if rcpt.Replayed {
+		w.Header().Set(HeaderIdempotentReplay, "true")
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(pushResp{Message: "push successful"})
+	_ = json.NewEncoder(w).Encode(pushResp{
+		Message:       "push successful",
+		ID:            rcpt.ID.String(),
+		TS:            rcpt.TS,
+		DeletionToken: rcpt.DeletionToken,
+	})
 }
 
-// Pull streams every pending submission for the given app.
+// PushBatch ingests up to service.MaxBatch sealed submissions in one
+// request of at most MaxBatchBytes, sent either as a JSON array or as
+// NDJSON (Content-Type: application/x-ndjson). Every item gets its own
+// result, in request order.
+func (s *Server) PushBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// ----- decode items -----------------------------------------------
+	r.Body = http.MaxBytesReader(w, r.Body, s.svc.MaxBatchBytes())
+
+	reqs, err := decodeBatch(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, service.ErrBatchTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(reqs) > service.MaxBatch {
+		http.Error(w, service.ErrBatchTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// ----- validate envelope, collect the rest for the service --------
+	results := make([]batchItemResp, len(reqs))
+	items := make([]service.BatchItem, 0, len(reqs))
+	origin := make([]int, 0, len(reqs)) // items[j] came from reqs[origin[j]]
+	for i, req := range reqs {
+		results[i].ClientID = req.ClientID
+		appID, err := uuid.Parse(req.AppID)
+		if err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, "invalid app id"
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(req.Blob)
+		if err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, "invalid blob"
+			continue
+		}
+		items = append(items, service.BatchItem{
+			AppID: appID, Kid: req.Kid, Blob: blob, Burn: req.BurnAfterReading, FormID: req.FormID,
+		})
+		origin = append(origin, i)
+	}
+
+	// ----- persist ----------------------------------------------------
+	out, err := s.svc.PushBatch(r.Context(), items)
+	if err != nil {
+		writePushError(w, err)
+		return
+	}
+	for j, res := range out {
+		i := origin[j]
+		if res.Err != nil {
+			results[i].Status, results[i].Error = pushStatus(res.Err), res.Err.Error()
+			continue
+		}
+		results[i].Status, results[i].ID = http.StatusCreated, res.ID.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(batchResp{Results: results})
+}
+
+// decodeBatch reads a JSON array, or one JSON object per line for NDJSON.
+func decodeBatch(r *http.Request) ([]batchItemReq, error) {
+	var reqs []batchItemReq
+	dec := json.NewDecoder(r.Body)
+
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "application/x-ndjson" && mt != "application/ndjson" {
+		err := dec.Decode(&reqs)
+		return reqs, err
+	}
+	for {
+		var req batchItemReq
+		err := dec.Decode(&req)
+		if err == io.EOF {
+			return reqs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+}
+
+// writePushError maps service and body-limit errors onto status codes.
+func writePushError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = service.ErrBlobTooLarge
+	}
+	http.Error(w, err.Error(), pushStatus(err))
+}
+
+func pushStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge),
+		errors.Is(err, service.ErrBlobTooLarge),
+		errors.Is(err, service.ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrAppNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidIdempotencyKey),
+		errors.Is(err, service.ErrUnknownForm),
+		errors.Is(err, service.ErrPaddingMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrFormRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrReplayGone):
+		return http.StatusGone
+	case errors.Is(err, service.ErrUploadTokenRequired),
+		errors.Is(err, service.ErrUploadTokenInvalid):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Pull streams every pending submission for the given app, or with
+// ?formID= only those of one form.
 // Response: text/plain; each line = base64(blob)\n
+// A failure after the first line is reported in the X-NB-Stream-Error
+// trailer, so clients must check it before trusting the body is complete.
 func (s *Server) Pull(w http.ResponseWriter, r *http.Request) {
 	// 1. method guard --------------------------------------------------
 	if r.Method != http.MethodGet {
@@ -236,16 +776,792 @@ func (s *Server) Pull(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid app id", http.StatusBadRequest)
 		return
 	}
+	formID := r.URL.Query().Get("formID")
+	if formID != "" && !service.ValidFormID(formID) {
+		http.Error(w, "invalid form id", http.StatusBadRequest)
+		return
+	}
 
-	// 3. stream blobs --------------------------------------------------
-	w.Header().Set("Content-Type", "text/plain")
+	// 3. stream blobs: one base64 line each, or with ?format=ndjson one
+	//    JSON object with the ID to acknowledge --------------------------
+	ndjson := r.URL.Query().Get("format") == "ndjson"
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	w.Header().Set("Trailer", TrailerStreamError)
 
-	err = s.svc.Pull(r.Context(), appID, func(sub *model.Submission) error {
-		line := base64.StdEncoding.EncodeToString(sub.Blob)
-		_, err := w.Write(append([]byte(line), '\n'))
+	started := false
+	enc := json.NewEncoder(w)
+	err = s.svc.Pull(r.Context(), appID, formID, func(sub *model.Submission) error {
+		started = true
+		blob := base64.StdEncoding.EncodeToString(sub.Blob)
+		if ndjson {
+			return enc.Encode(pullLine{
+				ID: sub.ID.String(), Kid: sub.Kid, TS: sub.TS, Blob: blob, Burn: sub.Burn,
+				UploadToken: uploadTokenID(sub), FormID: sub.FormID,
+			})
+		}
+		_, err := w.Write(append([]byte(blob), '\n'))
 		return err
 	})
+	if err == nil {
+		return
+	}
+	if !started {
+		w.Header().Del("Trailer")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// status is already 200: report the truncation in the trailer
+	w.Header().Set(TrailerStreamError, err.Error())
+}
+
+// Stream is a Server-Sent Events feed of new submissions for ?appID=.
+// Each event carries the submission's metadata and blob; its event ID is a
+// cursor, so a reconnect with Last-Event-ID replays what was missed. Without
+// Last-Event-ID only submissions arriving after the connect are sent.
+func (s *Server) Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	appID, err := uuid.Parse(r.URL.Query().Get("appID"))
+	if err != nil {
+		http.Error(w, "invalid app id", http.StatusBadRequest)
+		return
+	}
+	var after service.Cursor
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID != "" {
+		if after, err = service.ParseCursor(lastID); err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	} else if after, err = s.svc.FeedHead(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// subscribe before the replay so nothing slips in between
+	ticks, cancel := s.svc.Subscribe(appID)
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return // streaming unsupported by this ResponseWriter
+	}
+
+	send := func() error {
+		err := s.svc.PullAfter(r.Context(), appID, after, func(sub *model.Submission) error {
+			data, err := json.Marshal(streamEvent{
+				ID:    sub.ID.String(),
+				AppID: sub.AppID.String(),
+				Kid:   sub.Kid,
+				TS:    sub.TS,
+				Size:  len(sub.Blob),
+				Blob:  base64.StdEncoding.EncodeToString(sub.Blob),
+				Burn:  sub.Burn,
+
+				UploadToken: uploadTokenID(sub),
+				FormID:      sub.FormID,
+			})
+			if err != nil {
+				return err
+			}
+			next := service.CursorOf(sub)
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: submission\ndata: %s\n\n", next, data); err != nil {
+				return err
+			}
+			after = next
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if lastID != "" {
+		if err := send(); err != nil {
+			streamFail(w, rc, err)
+			return
+		}
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticks:
+			if err := send(); err != nil {
+				streamFail(w, rc, err)
+				return
+			}
+		case <-heartbeat.C:
+			// rows held back behind a then-running transaction come
+			// due without a tick of their own
+			if err := send(); err != nil {
+				streamFail(w, rc, err)
+				return
+			}
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamFail ends an SSE stream with a terminal "error" event; headers are
+// long gone, so http.Error is no option.
+func streamFail(w http.ResponseWriter, rc *http.ResponseController, err error) {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	_ = rc.Flush()
+}
+
+// ------------------------------------------------------------
+// Owner endpoints
+// ------------------------------------------------------------
+
+// ownerHandler is an endpoint that runs after ownerOnly authenticated the
+// caller for appID.
+type ownerHandler func(w http.ResponseWriter, r *http.Request, appID uuid.UUID)
+
+// ownerOnly guards routes carrying {appID} in the path: the request must
+// present the app's owner token as "Authorization: Bearer <token>".
+func (s *Server) ownerOnly(next ownerHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appID, err := uuid.Parse(r.PathValue("appID"))
+		if err != nil {
+			http.Error(w, "invalid app id", http.StatusBadRequest)
+			return
+		}
+		if s.authorizeOwner(w, r, appID) {
+			next(w, r, appID)
+		}
+	})
+}
+
+// authorizeOwner checks the request's bearer token against appID's owner
+// token. On failure it writes the response and returns false.
+func (s *Server) authorizeOwner(w http.ResponseWriter, r *http.Request, appID uuid.UUID) bool {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	err := s.svc.AuthorizeOwner(r.Context(), appID, token)
+	switch {
+	case errors.Is(err, service.ErrUnauthorized):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="noisybuffer"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// CreateWebhook subscribes a URL to the app's new-submission events.
+// The signing secret is only returned here.
+func (s *Server) CreateWebhook(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req webhookReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, jsonEnvelope)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook, err := s.svc.CreateWebhook(r.Context(), appID, req.URL, req.IncludeBlob, req.FormID)
+	switch {
+	case errors.Is(err, service.ErrInvalidWebhookURL), errors.Is(err, service.ErrPrivateWebhookURL),
+		errors.Is(err, service.ErrUnknownForm):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrTooManyWebhooks):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := toWebhookResp(hook)
+	resp.Secret = base64.StdEncoding.EncodeToString(hook.Secret)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) ListWebhooks(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	hooks, err := s.svc.ListWebhooks(r.Context(), appID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := make([]webhookResp, 0, len(hooks))
+	for _, h := range hooks {
+		resp = append(resp, toWebhookResp(h))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) DeleteWebhook(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	err = s.svc.DeleteWebhook(r.Context(), appID, id)
+	if errors.Is(err, service.ErrWebhookNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toWebhookResp(h *model.Webhook) webhookResp {
+	return webhookResp{
+		ID:          h.ID.String(),
+		URL:         h.URL,
+		IncludeBlob: h.IncludeBlob,
+		FormID:      h.FormID,
+		CreatedAt:   h.CreatedAt,
+	}
+}
+
+func (s *Server) GetRetention(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	p, err := s.svc.GetRetention(r.Context(), appID)
+	if errors.Is(err, service.ErrAppNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(retentionBody{
+		MaxAgeSeconds: int64(p.MaxAge / time.Second),
+		MaxCount:      p.MaxCount,
+		DeleteAcked:   p.DeleteAcked,
+		LegalHold:     p.LegalHold,
+
+		BurnAfterReading: p.BurnAfterReading,
+	})
+}
+
+func (s *Server) SetRetention(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req retentionBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, jsonEnvelope)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := s.svc.SetRetention(r.Context(), &model.Retention{
+		AppID:       appID,
+		MaxAge:      time.Duration(req.MaxAgeSeconds) * time.Second,
+		MaxCount:    req.MaxCount,
+		DeleteAcked: req.DeleteAcked,
+
+		BurnAfterReading: req.BurnAfterReading,
+	})
+	if errors.Is(err, service.ErrInvalidRetention) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.GetRetention(w, r, appID)
+}
+
+// CreateUploadToken mints an upload link. The token is only returned here,
+// in the token field and in the link's fragment.
+func (s *Server) CreateUploadToken(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req uploadTokenReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, jsonEnvelope)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, token, err := s.svc.CreateUploadToken(r.Context(), appID, req.Label, req.MaxUses,
+		time.Duration(req.ExpiresInSeconds)*time.Second)
+	if errors.Is(err, service.ErrInvalidUploadToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := toUploadTokenResp(t)
+	resp.Token = token
+	resp.Link = UploadPage + "#" + url.Values{"app": {appID.String()}, "token": {token}}.Encode()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) ListUploadTokens(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	tokens, err := s.svc.ListUploadTokens(r.Context(), appID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := make([]uploadTokenResp, 0, len(tokens))
+	for _, t := range tokens {
+		resp = append(resp, toUploadTokenResp(t))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) RevokeUploadToken(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid upload token id", http.StatusBadRequest)
+		return
+	}
+	err = s.svc.RevokeUploadToken(r.Context(), appID, id)
+	if errors.Is(err, service.ErrUploadTokenNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toUploadTokenResp(t *model.UploadToken) uploadTokenResp {
+	return uploadTokenResp{
+		ID: t.ID.String(), Label: t.Label, MaxUses: t.MaxUses, Uses: t.Uses,
+		ExpiresAt: t.ExpiresAt, CreatedAt: t.CreatedAt, RevokedAt: t.RevokedAt,
+	}
+}
+
+func uploadTokenID(sub *model.Submission) string {
+	if sub.UploadTokenID == nil {
+		return ""
+	}
+	return sub.UploadTokenID.String()
+}
+
+func (s *Server) GetDeadDrop(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	on, err := s.svc.DeadDrop(r.Context(), appID)
+	if errors.Is(err, service.ErrAppNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(deadDropBody{Enabled: on})
+}
+
+// SetDeadDrop switches dead-drop mode: while on, pushes without a valid
+// upload token are refused.
+func (s *Server) SetDeadDrop(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req deadDropBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, jsonEnvelope)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := s.svc.SetDeadDrop(r.Context(), appID, req.Enabled)
+	if errors.Is(err, service.ErrAppNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.GetDeadDrop(w, r, appID)
+}
+
+func (s *Server) GetPadding(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	pad, err := s.svc.Padding(r.Context(), appID)
+	if errors.Is(err, service.ErrAppNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(pad)
+}
+
+// SetPadding replaces the padding policy advertised by /pub: {"mode":
+// "pow2", "size": 4096} or {"mode": "fixed", "size": 65536}; pushes whose
+// blob length misses every allowed size are refused.
+func (s *Server) SetPadding(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req model.Padding
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, jsonEnvelope)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pad, err := s.svc.SetPadding(r.Context(), appID, req)
+	switch {
+	case errors.Is(err, service.ErrInvalidPadding):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrAppNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(pad)
+}
+
+// SetForm publishes a new version of the app's hosted form. Earlier
+// versions stay readable through GetForm's ?version.
+func (s *Server) SetForm(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req model.Form
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFormBody)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, err := s.svc.SetForm(r.Context(), appID, req)
+	switch {
+	case errors.Is(err, service.ErrInvalidForm), errors.Is(err, service.ErrUnknownForm):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrAppNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(toFormResp(d))
+}
+
+// GetForm returns the app's form definition: the newest, or ?version=N.
+// It is public metadata, like the public key; it never holds answers.
+func (s *Server) GetForm(w http.ResponseWriter, r *http.Request) {
+	appID, err := uuid.Parse(r.PathValue("appID"))
+	if err != nil {
+		http.Error(w, "invalid appID", http.StatusBadRequest)
+		return
+	}
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "version must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	d, err := s.svc.Form(r.Context(), appID, version)
+	if errors.Is(err, service.ErrFormNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if version != 0 {
+		w.Header().Set("Cache-Control", "public, max-age=86400, immutable") // versions never change
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	_ = json.NewEncoder(w).Encode(toFormResp(d))
+}
+
+// PutAppForm registers the form ID in the path, or replaces its label and
+// limits. Pushes may then name it.
+func (s *Server) PutAppForm(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req appFormBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, jsonEnvelope)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := &model.AppForm{
+		AppID:     appID,
+		ID:        r.PathValue("formID"),
+		Label:     req.Label,
+		MaxAge:    time.Duration(req.MaxAgeSeconds) * time.Second,
+		MaxCount:  req.MaxCount,
+		RateMin:   req.RateLimitMinute,
+		RateBurst: req.RateLimitBurst,
+	}
+	err := s.svc.PutAppForm(r.Context(), f)
+	switch {
+	case errors.Is(err, service.ErrInvalidAppForm):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrTooManyForms):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrAppNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(toAppFormBody(f))
+}
+
+func (s *Server) ListAppForms(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	forms, err := s.svc.ListAppForms(r.Context(), appID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := make([]appFormBody, 0, len(forms))
+	for _, f := range forms {
+		resp = append(resp, toAppFormBody(f))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteAppForm unregisters a form together with its webhooks. Its
+// submissions are kept.
+func (s *Server) DeleteAppForm(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	err := s.svc.DeleteAppForm(r.Context(), appID, r.PathValue("formID"))
+	if errors.Is(err, service.ErrFormNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toAppFormBody(f *model.AppForm) appFormBody {
+	return appFormBody{
+		ID:              f.ID,
+		Label:           f.Label,
+		MaxAgeSeconds:   int64(f.MaxAge.Seconds()),
+		MaxCount:        f.MaxCount,
+		RateLimitMinute: f.RateMin,
+		RateLimitBurst:  f.RateBurst,
+		CreatedAt:       f.CreatedAt,
+	}
+}
+
+func toFormResp(d *model.FormDef) formResp {
+	return formResp{
+		AppID:     d.AppID.String(),
+		Version:   d.Version,
+		CreatedAt: d.CreatedAt,
+		Link:      FormPath + d.AppID.String() + "?version=" + strconv.Itoa(d.Version),
+		Form:      d.Form,
+	}
+}
+
+// Ack acknowledges submissions the owner has pulled (with their IDs, via
+// ?format=ndjson) and decrypted. Burn-after-reading submissions are deleted
+// right away; retention may delete the others afterwards.
+func (s *Server) Ack(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var req ackReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAckBody)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(req.IDs))
+	for _, raw := range req.IDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			http.Error(w, "invalid submission id", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+	acked, burned, err := s.svc.Ack(r.Context(), appID, ids)
+	if errors.Is(err, service.ErrTooManyIDs) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ackResp{Acked: acked, Burned: burned})
+}
+
+// DeleteSubmission erases one submission and returns a signed receipt.
+func (s *Server) DeleteSubmission(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid submission id", http.StatusBadRequest)
+		return
+	}
+	e, signed, err := s.svc.DeleteSubmission(r.Context(), appID, id)
+	writeErasure(w, e, signed, err)
+}
+
+// DeleteRange erases the submissions received in [from, to), both RFC 3339
+// query parameters; either may be left out, not both.
+func (s *Server) DeleteRange(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "invalid "+name+": want RFC 3339", http.StatusBadRequest)
+			return
+		}
+		bounds[i] = t.UTC()
+	}
+	e, signed, err := s.svc.DeleteRange(r.Context(), appID, bounds[0], bounds[1])
+	writeErasure(w, e, signed, err)
+}
+
+// WithdrawSubmission lets a submitter delete their own submission with the
+// deletion token from the push response, sent as a bearer token. The
+// answer carries a signed receipt like owner deletions.
+func (s *Server) WithdrawSubmission(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid submission id", http.StatusBadRequest)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	e, signed, err := s.svc.WithdrawSubmission(r.Context(), id, token)
+	writeErasure(w, e, signed, err)
+}
+
+// EraseApp deletes the app with everything stored for it. The owner token
+// is gone afterwards; the receipt is the last thing it buys.
+func (s *Server) EraseApp(w http.ResponseWriter, r *http.Request, appID uuid.UUID) {
+	e, signed, err := s.svc.EraseApp(r.Context(), appID)
+	writeErasure(w, e, signed, err)
+}
+
+func writeErasure(w http.ResponseWriter, e *receipt.Erasure, signed string, err error) {
+	switch {
+	case errors.Is(err, service.ErrSubmissionNotFound), errors.Is(err, service.ErrAppNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrInvalidRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrLegalHold):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(erasureResp{Erasure: e, Receipt: signed})
+}
+
+// ReceiptKeys publishes the public key of erasure receipts as a JWK set.
+func (s *Server) ReceiptKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	_ = json.NewEncoder(w).Encode(jwkSet{Keys: []receipt.JWK{s.svc.Receipts().JWK()}})
+}
+
+// Reply leaves an owner's sealed answer in a submitter's mailbox. The
+// mailbox ID and reply key travel inside the submission's ciphertext, so
+// only the owner can know them; the owner token in the Authorization header
+// must match appID.
+func (s *Server) Reply(w http.ResponseWriter, r *http.Request) {
+	limit := base64.StdEncoding.EncodedLen(int(s.svc.MaxReply())) + jsonEnvelope
+	var req replyReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(limit))).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, service.ErrReplyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	appID, err := uuid.Parse(req.AppID)
+	if err != nil {
+		http.Error(w, "invalid app id", http.StatusBadRequest)
+		return
+	}
+	mailbox, err := uuid.Parse(req.Mailbox)
+	if err != nil {
+		http.Error(w, "invalid mailbox", http.StatusBadRequest)
+		return
+	}
+	blob, err := base64.StdEncoding.DecodeString(req.Blob)
+	if err != nil {
+		http.Error(w, "invalid base64 blob", http.StatusBadRequest)
+		return
+	}
+	if !s.authorizeOwner(w, r, appID) {
+		return
+	}
+	reply, err := s.svc.Reply(r.Context(), appID, mailbox, blob)
+	switch {
+	case errors.Is(err, service.ErrReplyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, service.ErrEmptyReply):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrMailboxTaken), errors.Is(err, service.ErrMailboxFull):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(replyResp{ID: reply.ID.String(), TS: reply.TS, ExpiresAt: reply.ExpiresAt})
+}
+
+// Mailbox returns the replies waiting in a mailbox. An unknown mailbox is
+// just an empty one, so probing reveals nothing.
+func (s *Server) Mailbox(w http.ResponseWriter, r *http.Request) {
+	mailbox, err := uuid.Parse(r.PathValue("mailbox"))
+	if err != nil {
+		http.Error(w, "invalid mailbox", http.StatusBadRequest)
+		return
+	}
+	replies, err := s.svc.Replies(r.Context(), mailbox)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := mailboxResp{Replies: make([]replyLine, 0, len(replies))}
+	for _, rp := range replies {
+		resp.Replies = append(resp.Replies, replyLine{
+			ID: rp.ID.String(), TS: rp.TS, ExpiresAt: rp.ExpiresAt,
+			Blob: base64.StdEncoding.EncodeToString(rp.Blob),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// EmptyMailbox deletes a mailbox's replies once the submitter has read them.
+func (s *Server) EmptyMailbox(w http.ResponseWriter, r *http.Request) {
+	mailbox, err := uuid.Parse(r.PathValue("mailbox"))
+	if err != nil {
+		http.Error(w, "invalid mailbox", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.svc.DeleteReplies(r.Context(), mailbox); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
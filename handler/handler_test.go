@@ -1,59 +1,419 @@
 package handler_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/collapsinghierarchy/noisybuffer/config"
 	"github.com/collapsinghierarchy/noisybuffer/handler"
+	"github.com/collapsinghierarchy/noisybuffer/logging"
 	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/receipt"
 	"github.com/collapsinghierarchy/noisybuffer/service"
+	"github.com/collapsinghierarchy/noisybuffer/store"
 )
 
+// fakeStore embeds store.Store so methods a test does not exercise panic
+// instead of having to be stubbed out.
 type fakeStore struct {
+	store.Store
+	mu          sync.Mutex // guards submissions for the streaming tests
 	exists      bool
+	appLookups  int // AppExists and Padding calls
 	inserted    *model.Submission
+	batch       []*model.Submission
 	submissions []*model.Submission
+	claims      map[string]*model.Submission
+	ownerHash   []byte
+	webhooks    []*model.Webhook
+	acked       []uuid.UUID
+	hold        bool // legal hold: owner deletions fail
+	streamErr   error
+	replies     []*model.Reply
+	tokens      []*model.UploadToken
+	deadDrop    bool
+	padding     model.Padding
+	forms       []*model.FormDef
+	appForms    []*model.AppForm
+}
+
+func (f *fakeStore) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub, ownerHash []byte) error {
+	if f.ownerHash != nil {
+		return store.ErrAppExists
+	}
+	f.ownerHash = ownerHash
+	return nil
+}
+func (f *fakeStore) OwnerTokenHash(ctx context.Context, appID uuid.UUID) ([]byte, error) {
+	return f.ownerHash, nil
+}
+func (f *fakeStore) CreateWebhook(ctx context.Context, w *model.Webhook, max int) error {
+	if len(f.webhooks) >= max {
+		return store.ErrTooManyWebhooks
+	}
+	f.webhooks = append(f.webhooks, w)
+	return nil
+}
+func (f *fakeStore) ListWebhooks(ctx context.Context, appID uuid.UUID) ([]*model.Webhook, error) {
+	return f.webhooks, nil
+}
+
+// AckSubmissions burns submissions flagged Burn and records the rest.
+func (f *fakeStore) AckSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (acked, burned int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		i := slices.IndexFunc(f.submissions, func(s *model.Submission) bool { return s.ID == id })
+		if i >= 0 && f.submissions[i].Burn {
+			f.submissions = slices.Delete(f.submissions, i, i+1)
+			burned++
+			continue
+		}
+		f.acked = append(f.acked, id)
+		acked++
+	}
+	return acked, burned, nil
+}
+
+func (f *fakeStore) DeleteSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hold {
+		return nil, store.ErrLegalHold
+	}
+	var deleted []uuid.UUID
+	f.submissions = slices.DeleteFunc(f.submissions, func(s *model.Submission) bool {
+		if slices.Contains(ids, s.ID) {
+			deleted = append(deleted, s.ID)
+			return true
+		}
+		return false
+	})
+	return deleted, nil
+}
+
+func (f *fakeStore) DeleteSubmissionByToken(ctx context.Context, id uuid.UUID, tokenHash []byte) (uuid.UUID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := slices.IndexFunc(f.submissions, func(s *model.Submission) bool {
+		return s.ID == id && s.DeleteTokenHash != nil && bytes.Equal(s.DeleteTokenHash, tokenHash)
+	})
+	if i < 0 {
+		return uuid.Nil, sql.ErrNoRows
+	}
+	appID := f.submissions[i].AppID
+	f.submissions = slices.Delete(f.submissions, i, i+1)
+	return appID, nil
+}
+
+func (f *fakeStore) DeleteApp(ctx context.Context, appID uuid.UUID) (*model.AppErasure, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hold {
+		return nil, store.ErrLegalHold
+	}
+	e := &model.AppErasure{Submissions: int64(len(f.submissions)), Webhooks: int64(len(f.webhooks))}
+	f.submissions, f.webhooks, f.ownerHash, f.exists = nil, nil, nil, false
+	return e, nil
+}
+
+func (f *fakeStore) InsertReply(ctx context.Context, r *model.Reply, max int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, old := range f.replies {
+		if old.Mailbox != r.Mailbox {
+			continue
+		}
+		if old.AppID != r.AppID {
+			return store.ErrMailboxTaken
+		}
+		n++
+	}
+	if n >= max {
+		return store.ErrMailboxFull
+	}
+	f.replies = append(f.replies, r)
+	return nil
+}
+
+func (f *fakeStore) ListReplies(ctx context.Context, mailbox uuid.UUID, now time.Time) ([]*model.Reply, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []*model.Reply
+	for _, r := range f.replies {
+		if r.Mailbox == mailbox && r.ExpiresAt.After(now) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeStore) DeleteReplies(ctx context.Context, mailbox uuid.UUID) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.replies)
+	f.replies = slices.DeleteFunc(f.replies, func(r *model.Reply) bool { return r.Mailbox == mailbox })
+	return int64(n - len(f.replies)), nil
 }
 
 func (f *fakeStore) AppExists(ctx context.Context, id uuid.UUID) (bool, error) {
+	f.appLookups++
 	return f.exists, nil
 }
+func (f *fakeStore) CreateUploadToken(ctx context.Context, t *model.UploadToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, t)
+	return nil
+}
+
+func (f *fakeStore) UploadTokenByHash(ctx context.Context, appID uuid.UUID, hash []byte) (*model.UploadToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tokens {
+		if t.AppID == appID && bytes.Equal(t.TokenHash, hash) {
+			return t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeStore) ListUploadTokens(ctx context.Context, appID uuid.UUID) ([]*model.UploadToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.tokens), nil
+}
+
+func (f *fakeStore) RevokeUploadToken(ctx context.Context, appID, id uuid.UUID, at time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tokens {
+		if t.ID == id {
+			t.RevokedAt = &at
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (f *fakeStore) DeadDrop(ctx context.Context, appID uuid.UUID) (bool, error) {
+	return f.deadDrop, nil
+}
+
+func (f *fakeStore) SetDeadDrop(ctx context.Context, appID uuid.UUID, on bool) error {
+	f.deadDrop = on
+	return nil
+}
+
+func (f *fakeStore) GetKey(ctx context.Context, appID uuid.UUID) (uint8, []byte, error) {
+	return 1, []byte("pub"), nil
+}
+
+func (f *fakeStore) Padding(ctx context.Context, appID uuid.UUID) (model.Padding, error) {
+	f.appLookups++
+	if !f.exists {
+		return model.Padding{}, sql.ErrNoRows
+	}
+	return f.padding, nil
+}
+
+func (f *fakeStore) SetPadding(ctx context.Context, appID uuid.UUID, p model.Padding) error {
+	f.padding = p
+	return nil
+}
+
+func (f *fakeStore) SaveFormDef(ctx context.Context, d *model.FormDef) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.exists {
+		return sql.ErrNoRows
+	}
+	d.Version = len(f.forms) + 1
+	f.forms = append(f.forms, d)
+	return nil
+}
+
+func (f *fakeStore) FormDef(ctx context.Context, appID uuid.UUID, version int) (*model.FormDef, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if version == 0 {
+		version = len(f.forms)
+	}
+	if version < 1 || version > len(f.forms) {
+		return nil, sql.ErrNoRows
+	}
+	return f.forms[version-1], nil
+}
+
+func (f *fakeStore) PutAppForm(ctx context.Context, fm *model.AppForm) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.appForms = slices.DeleteFunc(f.appForms, func(o *model.AppForm) bool { return o.ID == fm.ID })
+	f.appForms = append(f.appForms, fm)
+	return nil
+}
+
+func (f *fakeStore) AppForm(ctx context.Context, appID uuid.UUID, id string) (*model.AppForm, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i := slices.IndexFunc(f.appForms, func(o *model.AppForm) bool { return o.ID == id }); i >= 0 {
+		return f.appForms[i], nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeStore) ListAppForms(ctx context.Context, appID uuid.UUID) ([]*model.AppForm, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.appForms), nil
+}
+
+func (f *fakeStore) DeleteAppForm(ctx context.Context, appID uuid.UUID, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.appForms)
+	f.appForms = slices.DeleteFunc(f.appForms, func(o *model.AppForm) bool { return o.ID == id })
+	if len(f.appForms) == n {
+		return sql.ErrNoRows
+	}
+	f.webhooks = slices.DeleteFunc(f.webhooks, func(w *model.Webhook) bool { return w.FormID == id })
+	return nil
+}
+
+// redeem does what the submissions_upload_token trigger does in Postgres.
+func (f *fakeStore) redeem(s *model.Submission) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s.UploadTokenID == nil {
+		if f.deadDrop {
+			return store.ErrUploadTokenRequired
+		}
+		return nil
+	}
+	for _, t := range f.tokens {
+		if t.ID == *s.UploadTokenID && t.Usable(time.Now()) {
+			t.Uses++
+			return nil
+		}
+	}
+	return store.ErrUploadTokenSpent
+}
+
 func (f *fakeStore) InsertSubmission(ctx context.Context, s *model.Submission) error {
+	if err := f.redeem(s); err != nil {
+		return err
+	}
 	copy := *s
 	f.inserted = &copy
+	f.mu.Lock()
+	copy.Pos = model.FeedPos{Seq: int64(len(f.submissions) + 1)}
+	f.submissions = append(f.submissions, &copy)
+	f.mu.Unlock()
 	return nil
 }
-func (f *fakeStore) StreamSubmissions(ctx context.Context, id uuid.UUID, fn func(*model.Submission) error) error {
-	for _, s := range f.submissions {
+func (f *fakeStore) StreamSubmissionsAfter(ctx context.Context, id uuid.UUID, after model.FeedPos, fn func(*model.Submission) error) error {
+	f.mu.Lock()
+	subs := append([]*model.Submission(nil), f.submissions...)
+	f.mu.Unlock()
+	for _, s := range subs {
+		if !after.Less(s.Pos) {
+			continue
+		}
 		if err := fn(s); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+func (f *fakeStore) FeedHead(ctx context.Context) (model.FeedPos, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n := len(f.submissions); n > 0 {
+		return f.submissions[n-1].Pos, nil
+	}
+	return model.FeedPos{}, nil
+}
+func (f *fakeStore) InsertSubmissions(ctx context.Context, subs []*model.Submission) error {
+	f.batch = append(f.batch, subs...)
+	return nil
+}
+func (f *fakeStore) InsertSubmissionOnce(ctx context.Context, s *model.Submission, key string, notBefore time.Time) (bool, error) {
+	if orig, ok := f.claims[key]; ok {
+		f.mu.Lock()
+		gone := !slices.ContainsFunc(f.submissions, func(s *model.Submission) bool { return s.ID == orig.ID })
+		f.mu.Unlock()
+		if gone {
+			return false, store.ErrReplayGone
+		}
+		s.ID, s.TS = orig.ID, orig.TS
+		return true, nil
+	}
+	if err := f.InsertSubmission(ctx, s); err != nil {
+		return false, err
+	}
+	if f.claims == nil {
+		f.claims = make(map[string]*model.Submission)
+	}
+	f.claims[key] = f.inserted
+	return false, nil
+}
+func (f *fakeStore) StreamSubmissions(ctx context.Context, id uuid.UUID, formID string, fn func(*model.Submission) error) error {
+	for _, s := range f.submissions {
+		if formID != "" && s.FormID != formID {
+			continue
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return f.streamErr
+}
+
+func testConfig(maxBlob int64) *config.Live {
+	return config.NewLive(config.Config{MaxBlobBytes: maxBlob})
+}
+
+// newAPI wires st through a service into the routes, sharing one config.
+func newAPI(st store.Store, maxBlob int64, opts ...handler.Option) http.Handler {
+	cfg := testConfig(maxBlob)
+	return handler.SetupNBRoutes(service.New(st, cfg), cfg, opts...)
+}
 
 // -------------------------------------------------------------------------
 func TestPushHandler_Success(t *testing.T) {
 	fs := &fakeStore{exists: true}
-	svc := service.New(fs, 1024)
-	mux := handler.SetupNBRoutes(svc)
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	mux := handler.SetupNBRoutes(svc, cfg)
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
 	appID := uuid.New()
 	rawBlob := []byte("abc123")
 	reqBody, _ := json.Marshal(map[string]interface{}{
-		"app":  appID.String(), // <-- fix here
-		"kid":  1,
-		"blob": base64.StdEncoding.EncodeToString(rawBlob),
+		"appID": appID.String(),
+		"kid":   1,
+		"blob":  base64.StdEncoding.EncodeToString(rawBlob),
 	})
 
 	resp, err := http.Post(srv.URL+"/nb/v1/push", "application/json", bytes.NewReader(reqBody))
@@ -69,12 +429,16 @@ func TestPushHandler_Success(t *testing.T) {
 	if !bytes.Equal(fs.inserted.Blob, rawBlob) {
 		t.Errorf("stored blob mismatch: %q vs %q", fs.inserted.Blob, rawBlob)
 	}
+	if fs.appLookups != 1 {
+		t.Errorf("app looked up %d times, want once", fs.appLookups)
+	}
 }
 
 func TestPushHandler_InvalidJSON(t *testing.T) {
 	fs := &fakeStore{exists: true}
-	svc := service.New(fs, 1024)
-	h := handler.New(svc)
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	h := handler.New(svc, cfg)
 	mux := http.NewServeMux()
 	mux.Handle("/nb/v1/push", http.HandlerFunc(h.Push))
 	mux.Handle("/nb/v1/pull", http.HandlerFunc(h.Pull))
@@ -96,22 +460,1287 @@ func TestPullHandler_Success(t *testing.T) {
 			{ID: uuid.New(), AppID: appID, Blob: []byte("b")},
 		},
 	}
-	svc := service.New(fs, 1024)
-	h := handler.New(svc)
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	h := handler.New(svc, cfg)
 	mux := http.NewServeMux()
 	mux.Handle("/nb/v1/push", http.HandlerFunc(h.Push))
 	mux.Handle("/nb/v1/pull", http.HandlerFunc(h.Pull))
 	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
-	resp, err := http.Get(srv.URL + "/nb/v1/pull?app=" + appID.String()) // <-- fix here
+	resp, err := http.Get(srv.URL + "/nb/v1/pull?appID=" + appID.String())
 	if err != nil {
+		t.Fatalf("GET pull error: %v", err)
 	}
 	if resp.StatusCode != http.StatusOK {
 		t.Fatalf("unexpected status: %d", resp.StatusCode)
 	}
 	body, _ := ioutil.ReadAll(resp.Body)
-	if !bytes.Equal(body, []byte("a\nb\n")) {
+	if !bytes.Equal(body, []byte("YQ==\nYg==\n")) {
 		t.Errorf("unexpected body: %q", body)
 	}
 }
+
+func TestPushHandler_BinaryHeaders(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	rawBlob := []byte{0x00, 0xff, 0x10, 0x20}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/push", bytes.NewReader(rawBlob))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(handler.HeaderAppID, uuid.NewString())
+	req.Header.Set(handler.HeaderKid, "3")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST push error: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: got %d", resp.StatusCode)
+	}
+	if fs.inserted == nil {
+		t.Fatal("InsertSubmission was not called")
+	}
+	if fs.inserted.Kid != 3 || !bytes.Equal(fs.inserted.Blob, rawBlob) {
+		t.Errorf("stored submission mismatch: kid=%d blob=%x", fs.inserted.Kid, fs.inserted.Blob)
+	}
+}
+
+func TestPushHandler_BinaryPath(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	url := srv.URL + "/nb/v1/push/" + uuid.NewString() + "/7"
+	resp, err := http.Post(url, "application/octet-stream", strings.NewReader("sealed"))
+	if err != nil {
+		t.Fatalf("POST push error: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: got %d", resp.StatusCode)
+	}
+	if fs.inserted == nil || fs.inserted.Kid != 7 || string(fs.inserted.Blob) != "sealed" {
+		t.Fatalf("unexpected stored submission: %+v", fs.inserted)
+	}
+}
+
+func TestPushHandler_BinaryTooLarge(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(4)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	url := srv.URL + "/nb/v1/push/" + uuid.NewString() + "/1"
+	resp, err := http.Post(url, "application/octet-stream", strings.NewReader("too large"))
+	if err != nil {
+		t.Fatalf("POST push error: %v", err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("want 413, got %d", resp.StatusCode)
+	}
+	if fs.inserted != nil {
+		t.Error("InsertSubmission should not be called on too-large blob")
+	}
+}
+
+func TestPushHandler_JSONTooLarge(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(4)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"appID": uuid.NewString(),
+		"kid":   1,
+		"blob":  strings.Repeat("A", 4096),
+	})
+	resp, err := http.Post(srv.URL+"/nb/v1/push", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("POST push error: %v", err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("want 413, got %d", resp.StatusCode)
+	}
+}
+
+type batchResult struct {
+	ClientID string `json:"clientID"`
+	ID       string `json:"id"`
+	Status   int    `json:"status"`
+	Error    string `json:"error"`
+}
+
+func postBatch(t *testing.T, url, contentType string, body []byte) []batchResult {
+	t.Helper()
+	resp, err := http.Post(url+"/nb/v1/push:batch", contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST push:batch error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d", resp.StatusCode)
+	}
+	var out struct {
+		Results []batchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return out.Results
+}
+
+func TestPushBatchHandler_JSONArray(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	appID := uuid.NewString()
+	reqBody, _ := json.Marshal([]map[string]interface{}{
+		{"appID": appID, "kid": 1, "blob": base64.StdEncoding.EncodeToString([]byte("one")), "clientID": "a"},
+		{"appID": "nope", "kid": 1, "blob": "", "clientID": "b"},
+		{"appID": appID, "kid": 1, "blob": "%%%", "clientID": "c"},
+		{"appID": appID, "kid": 2, "blob": base64.StdEncoding.EncodeToString([]byte("two")), "clientID": "d"},
+	})
+	results := postBatch(t, srv.URL, "application/json", reqBody)
+
+	want := []struct {
+		clientID string
+		status   int
+	}{{"a", 201}, {"b", 400}, {"c", 400}, {"d", 201}}
+	if len(results) != len(want) {
+		t.Fatalf("want %d results, got %d", len(want), len(results))
+	}
+	for i, w := range want {
+		if results[i].ClientID != w.clientID || results[i].Status != w.status {
+			t.Errorf("result[%d] = %+v, want clientID=%s status=%d", i, results[i], w.clientID, w.status)
+		}
+	}
+	if len(fs.batch) != 2 {
+		t.Fatalf("want 2 stored submissions, got %d", len(fs.batch))
+	}
+	if fs.batch[0].ID.String() != results[0].ID || fs.batch[1].ID.String() != results[3].ID {
+		t.Error("result IDs do not match stored submissions")
+	}
+}
+
+func TestPushBatchHandler_NDJSON(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	var body bytes.Buffer
+	for _, msg := range []string{"x", "y", "z"} {
+		line, _ := json.Marshal(map[string]interface{}{
+			"appID": uuid.NewString(),
+			"kid":   1,
+			"blob":  base64.StdEncoding.EncodeToString([]byte(msg)),
+		})
+		body.Write(append(line, '\n'))
+	}
+	results := postBatch(t, srv.URL, "application/x-ndjson", body.Bytes())
+	if len(results) != 3 {
+		t.Fatalf("want 3 results, got %d", len(results))
+	}
+	for i, r := range results {
+		if r.Status != http.StatusCreated {
+			t.Errorf("result[%d]: unexpected status %d (%s)", i, r.Status, r.Error)
+		}
+	}
+	if len(fs.batch) != 3 || string(fs.batch[2].Blob) != "z" {
+		t.Fatalf("unexpected stored batch: %+v", fs.batch)
+	}
+}
+
+func TestPushBatchHandler_BodyLimit(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	// a huge blob limit must not raise the batch limit with it
+	cfg := config.NewLive(config.Config{MaxBlobBytes: 1 << 30, MaxBatchBytes: 4 << 10})
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+
+	batch := func(blobSize int) []byte {
+		body, _ := json.Marshal([]map[string]interface{}{
+			{"appID": uuid.NewString(), "kid": 1, "blob": base64.StdEncoding.EncodeToString(make([]byte, blobSize))},
+		})
+		return body
+	}
+	if results := postBatch(t, srv.URL, "application/json", batch(1<<10)); len(results) != 1 || results[0].Status != http.StatusCreated {
+		t.Fatalf("small batch: %+v", results)
+	}
+	resp, err := http.Post(srv.URL+"/nb/v1/push:batch", "application/json", bytes.NewReader(batch(8<<10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized batch: status %d, want 413", resp.StatusCode)
+	}
+	if len(fs.batch) != 1 {
+		t.Errorf("stored %d submissions, want 1", len(fs.batch))
+	}
+}
+
+func TestPushHandler_IdempotencyKey(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	url := srv.URL + "/nb/v1/push/" + uuid.NewString() + "/1"
+	push := func() (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader("sealed"))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(handler.HeaderIdempotencyKey, "form-42")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST push error: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	first, firstBody := push()
+	second, secondBody := push()
+	if first.StatusCode != http.StatusCreated || second.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: %d, %d", first.StatusCode, second.StatusCode)
+	}
+	if first.Header.Get(handler.HeaderIdempotentReplay) != "" {
+		t.Error("first push must not be flagged as replay")
+	}
+	if second.Header.Get(handler.HeaderIdempotentReplay) != "true" {
+		t.Error("second push should be flagged as replay")
+	}
+	if firstBody["id"] == "" || firstBody["id"] != secondBody["id"] {
+		t.Errorf("replay id mismatch: %v vs %v", firstBody["id"], secondBody["id"])
+	}
+	if len(fs.claims) != 1 {
+		t.Errorf("want 1 claimed key, got %d", len(fs.claims))
+	}
+}
+
+// registerOwner registers a key for appID and returns the owner token.
+func registerOwner(t *testing.T, url string, appID uuid.UUID) string {
+	t.Helper()
+	reqBody, _ := json.Marshal(map[string]interface{}{
+		"appID": appID.String(),
+		"kid":   0,
+		"pub":   base64.StdEncoding.EncodeToString([]byte("pub")),
+	})
+	resp, err := http.Post(url+"/nb/v1/key", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatalf("POST key error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: got %d", resp.StatusCode)
+	}
+	var out struct {
+		OwnerToken string `json:"ownerToken"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if out.OwnerToken == "" {
+		t.Fatal("registration did not return an owner token")
+	}
+	return out.OwnerToken
+}
+
+func TestRegisterKey_Conflict(t *testing.T) {
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	appID := uuid.New()
+	registerOwner(t, srv.URL, appID)
+	first := fs.ownerHash
+
+	body := `{"appID": "` + appID.String() + `", "kid": 1, "pub": "b3RoZXI="}`
+	resp, err := http.Post(srv.URL+"/nb/v1/key", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("second registration: %d, want 409", resp.StatusCode)
+	}
+	if !bytes.Equal(fs.ownerHash, first) {
+		t.Error("second registration replaced the owner token")
+	}
+}
+
+func TestWebhookHandler_OwnerOnly(t *testing.T) {
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	appID := uuid.New()
+	token := registerOwner(t, srv.URL, appID)
+	create := func(bearer string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/apps/"+appID.String()+"/webhooks",
+			strings.NewReader(`{"url":"https://example.com/hook"}`))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST webhooks error: %v", err)
+		}
+		return resp
+	}
+
+	if resp := create("wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("want 401 for wrong token, got %d", resp.StatusCode)
+	}
+	resp := create(token)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("want 201, got %d", resp.StatusCode)
+	}
+	var hook struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&hook)
+	if hook.Secret == "" || len(fs.webhooks) != 1 || fs.webhooks[0].ID.String() != hook.ID {
+		t.Errorf("unexpected webhook response %+v / stored %v", hook, fs.webhooks)
+	}
+}
+
+func TestPullNDJSONThenAck(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{submissions: []*model.Submission{
+		{ID: uuid.New(), AppID: appID, Kid: 2, Blob: []byte("a")},
+		{ID: uuid.New(), AppID: appID, Kid: 2, Blob: []byte("b")},
+	}}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	token := registerOwner(t, srv.URL, appID)
+
+	resp, err := http.Get(srv.URL + "/nb/v1/pull?format=ndjson&appID=" + appID.String())
+	if err != nil {
+		t.Fatalf("GET pull: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("content type %q", ct)
+	}
+	var ids []string
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var line struct {
+			ID   string `json:"id"`
+			Kid  uint8  `json:"kid"`
+			Blob string `json:"blob"`
+		}
+		if err := dec.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("decode line: %v", err)
+		}
+		if line.Kid != 2 || line.Blob == "" {
+			t.Errorf("incomplete line %+v", line)
+		}
+		ids = append(ids, line.ID)
+	}
+	if len(ids) != 2 || ids[0] != fs.submissions[0].ID.String() {
+		t.Fatalf("pulled ids %v", ids)
+	}
+
+	ack := func(bearer, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/apps/"+appID.String()+"/ack",
+			strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST ack: %v", err)
+		}
+		return resp
+	}
+	body, _ := json.Marshal(map[string][]string{"ids": ids})
+	if resp := ack("wrong", string(body)); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("ack without owner token: %d", resp.StatusCode)
+	}
+	if resp := ack(token, `{"ids":["nope"]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("ack with a bad id: %d", resp.StatusCode)
+	}
+	resp = ack(token, string(body))
+	defer resp.Body.Close()
+	var got struct {
+		Acked int64 `json:"acked"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	if resp.StatusCode != http.StatusOK || got.Acked != 2 || len(fs.acked) != 2 {
+		t.Fatalf("ack: %d %+v, store saw %v", resp.StatusCode, got, fs.acked)
+	}
+}
+
+func TestPushBurnAfterReading(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	token := registerOwner(t, srv.URL, appID)
+	fs.exists = true
+
+	push := func(burn string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/push/"+appID.String()+"/1", strings.NewReader("sealed"))
+		req.Header.Set(handler.HeaderBurnAfterReading, burn)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST push: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := push("maybe"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid burn header: %d", resp.StatusCode)
+	}
+	if resp := push("true"); resp.StatusCode != http.StatusCreated || !fs.inserted.Burn {
+		t.Fatalf("burn push: %d, stored %+v", resp.StatusCode, fs.inserted)
+	}
+	burnt := fs.inserted.ID
+	if resp := push(""); resp.StatusCode != http.StatusCreated || fs.inserted.Burn {
+		t.Fatalf("plain push: %d, stored %+v", resp.StatusCode, fs.inserted)
+	}
+
+	resp, err := http.Get(srv.URL + "/nb/v1/pull?format=ndjson&appID=" + appID.String())
+	if err != nil {
+		t.Fatalf("GET pull: %v", err)
+	}
+	var line struct {
+		ID   string `json:"id"`
+		Burn bool   `json:"burn"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&line)
+	resp.Body.Close()
+	if err != nil || line.ID != burnt.String() || !line.Burn {
+		t.Fatalf("first pulled line %+v: %v", line, err)
+	}
+
+	body, _ := json.Marshal(map[string][]string{"ids": {burnt.String(), fs.inserted.ID.String()}})
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/apps/"+appID.String()+"/ack", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST ack: %v", err)
+	}
+	defer resp.Body.Close()
+	var got struct {
+		Acked  int64 `json:"acked"`
+		Burned int64 `json:"burned"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&got)
+	if got.Acked != 1 || got.Burned != 1 || len(fs.submissions) != 1 || fs.submissions[0].ID == burnt {
+		t.Fatalf("ack %+v, left %v", got, fs.submissions)
+	}
+}
+
+func TestPushBurnAfterReading_ReplayAfterBurn(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	token := registerOwner(t, srv.URL, appID)
+	fs.exists = true
+
+	push := func() int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/push/"+appID.String()+"/1", strings.NewReader("sealed"))
+		req.Header.Set(handler.HeaderBurnAfterReading, "true")
+		req.Header.Set(handler.HeaderIdempotencyKey, "once")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST push: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := push(); code != http.StatusCreated {
+		t.Fatalf("push: %d", code)
+	}
+	body, _ := json.Marshal(map[string][]string{"ids": {fs.inserted.ID.String()}})
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/apps/"+appID.String()+"/ack", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST ack: %v", err)
+	}
+	resp.Body.Close()
+	if len(fs.submissions) != 0 {
+		t.Fatalf("burn left %v", fs.submissions)
+	}
+
+	if code := push(); code != http.StatusGone {
+		t.Errorf("replay after burn: %d, want 410", code)
+	}
+	if len(fs.submissions) != 0 {
+		t.Errorf("replay stored the burnt submission again: %v", fs.submissions)
+	}
+}
+
+func TestEraseWithReceipts(t *testing.T) {
+	appID := uuid.New()
+	first, second := uuid.New(), uuid.New()
+	fs := &fakeStore{submissions: []*model.Submission{
+		{ID: first, AppID: appID, Blob: []byte("a")},
+		{ID: second, AppID: appID, Blob: []byte("b")},
+	}}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	token := registerOwner(t, srv.URL, appID)
+	fs.webhooks = []*model.Webhook{{ID: uuid.New(), AppID: appID}}
+
+	// the published key verifies every receipt
+	resp, err := http.Get(srv.URL + "/nb/v1/receipt-keys")
+	if err != nil {
+		t.Fatalf("GET receipt-keys: %v", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			X   string `json:"x"`
+		} `json:"keys"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&set)
+	resp.Body.Close()
+	if len(set.Keys) != 1 {
+		t.Fatalf("key set %+v", set)
+	}
+	pub, _ := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+
+	erase := func(path string) (*http.Response, *receipt.Erasure) {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/nb/v1/apps/"+appID.String()+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("DELETE %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		var out struct {
+			Receipt string `json:"receipt"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		e, err := receipt.Verify(pub, out.Receipt)
+		if err != nil {
+			t.Fatalf("DELETE %s: receipt does not verify: %v", path, err)
+		}
+		return resp, e
+	}
+
+	if resp, _ := erase("/submissions/" + uuid.NewString()); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown submission: %d", resp.StatusCode)
+	}
+	if resp, _ := erase("/submissions"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("range without bounds: %d", resp.StatusCode)
+	}
+	fs.hold = true
+	if resp, _ := erase("/submissions/" + first.String()); resp.StatusCode != http.StatusConflict {
+		t.Errorf("delete under legal hold: %d", resp.StatusCode)
+	}
+	fs.hold = false
+
+	_, e := erase("/submissions/" + first.String())
+	if e == nil || e.Scope != receipt.ScopeSubmissions || e.AppID != appID ||
+		!slices.Equal(e.SubmissionIDs, []uuid.UUID{first}) || len(fs.submissions) != 1 {
+		t.Fatalf("submission receipt %+v, left %v", e, fs.submissions)
+	}
+	_, e = erase("")
+	if e == nil || e.Scope != receipt.ScopeApp || e.Submissions != 1 || e.Webhooks != 1 {
+		t.Fatalf("app receipt %+v", e)
+	}
+	if resp, _ := erase(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("owner token still works after erasure: %d", resp.StatusCode)
+	}
+}
+
+func TestWithdrawWithDeletionToken(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{exists: true}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/push/"+appID.String()+"/1", strings.NewReader("sealed"))
+	req.Header.Set(handler.HeaderDeletionToken, "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST push: %v", err)
+	}
+	var pushed struct {
+		ID            string `json:"id"`
+		DeletionToken string `json:"deletionToken"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&pushed)
+	resp.Body.Close()
+	if pushed.DeletionToken == "" {
+		t.Fatal("push response has no deletion token")
+	}
+
+	withdraw := func(token string) int {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/nb/v1/submissions/"+pushed.ID, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("DELETE submission: %v", err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Erasure struct {
+				AppID string `json:"appID"`
+			} `json:"erasure"`
+			Receipt string `json:"receipt"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		if resp.StatusCode == http.StatusOK && (out.Receipt == "" || out.Erasure.AppID != appID.String()) {
+			t.Errorf("withdrawal answer %+v", out)
+		}
+		return resp.StatusCode
+	}
+	if code := withdraw("guess"); code != http.StatusNotFound {
+		t.Errorf("wrong token: %d", code)
+	}
+	if code := withdraw(pushed.DeletionToken); code != http.StatusOK || len(fs.submissions) != 0 {
+		t.Errorf("withdraw: %d, left %v", code, fs.submissions)
+	}
+	if code := withdraw(pushed.DeletionToken); code != http.StatusNotFound {
+		t.Errorf("second withdrawal: %d", code)
+	}
+}
+
+func TestReplyMailbox(t *testing.T) {
+	appID, mailbox := uuid.New(), uuid.New()
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	token := registerOwner(t, srv.URL, appID)
+
+	reply := func(app uuid.UUID, token string, blob []byte) int {
+		body, _ := json.Marshal(map[string]string{
+			"appID": app.String(), "mailbox": mailbox.String(),
+			"blob": base64.StdEncoding.EncodeToString(blob),
+		})
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/reply", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST reply: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := reply(appID, "guess", []byte("sealed")); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", code)
+	}
+	if code := reply(appID, token, bytes.Repeat([]byte{1}, service.DefaultMaxReply+1)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized reply: %d", code)
+	}
+	if code := reply(appID, token, []byte("sealed")); code != http.StatusCreated {
+		t.Fatalf("reply: %d", code)
+	}
+	// the fake accepts the same token for any app
+	if code := reply(uuid.New(), token, []byte("hijack")); code != http.StatusConflict {
+		t.Errorf("reply from another app: %d", code)
+	}
+
+	fetch := func() []string {
+		resp, err := http.Get(srv.URL + "/nb/v1/reply/" + mailbox.String())
+		if err != nil {
+			t.Fatalf("GET mailbox: %v", err)
+		}
+		defer resp.Body.Close()
+		if cc := resp.Header.Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("Cache-Control %q", cc)
+		}
+		var out struct {
+			Replies []struct {
+				Blob string `json:"blob"`
+			} `json:"replies"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode mailbox: %v", err)
+		}
+		var blobs []string
+		for _, r := range out.Replies {
+			b, _ := base64.StdEncoding.DecodeString(r.Blob)
+			blobs = append(blobs, string(b))
+		}
+		return blobs
+	}
+	if got := fetch(); !slices.Equal(got, []string{"sealed"}) {
+		t.Errorf("mailbox holds %q", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/nb/v1/reply/"+mailbox.String(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE mailbox: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE mailbox: %d", resp.StatusCode)
+	}
+	if got := fetch(); len(got) != 0 {
+		t.Errorf("emptied mailbox holds %q", got)
+	}
+}
+
+func TestDeadDropUploadTokens(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	owner := registerOwner(t, srv.URL, appID)
+	fs.exists = true
+	base := srv.URL + "/nb/v1/apps/" + appID.String()
+
+	do := func(method, url, body string, header map[string]string) *http.Response {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+		return resp
+	}
+	auth := map[string]string{"Authorization": "Bearer " + owner}
+	push := func(token, key string) int {
+		resp := do(http.MethodPost, srv.URL+"/nb/v1/push/"+appID.String()+"/1", "sealed",
+			map[string]string{handler.HeaderUploadToken: token, handler.HeaderIdempotencyKey: key})
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	resp := do(http.MethodPut, base+"/dead-drop", `{"enabled": true}`, auth)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !fs.deadDrop {
+		t.Fatalf("enable dead drop: %d", resp.StatusCode)
+	}
+	if code := push("", ""); code != http.StatusForbidden {
+		t.Errorf("push without token: %d", code)
+	}
+
+	resp = do(http.MethodPost, base+"/upload-tokens", `{"label": "source A", "maxUses": 1}`, auth)
+	var minted struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+		Link  string `json:"link"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&minted)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || minted.Token == "" {
+		t.Fatalf("mint: %d %+v", resp.StatusCode, minted)
+	}
+	if !strings.HasPrefix(minted.Link, handler.UploadPage+"#") || !strings.Contains(minted.Link, "token="+minted.Token) {
+		t.Errorf("link %q does not carry the token in its fragment", minted.Link)
+	}
+
+	if code := push("guess", ""); code != http.StatusForbidden {
+		t.Errorf("push with unknown token: %d", code)
+	}
+	if code := push(minted.Token, "k1"); code != http.StatusCreated {
+		t.Fatalf("push with token: %d", code)
+	}
+	if fs.inserted.UploadTokenID == nil || fs.inserted.UploadTokenID.String() != minted.ID {
+		t.Errorf("submission records token %v, want %s", fs.inserted.UploadTokenID, minted.ID)
+	}
+	if code := push(minted.Token, "k1"); code != http.StatusCreated {
+		t.Errorf("idempotent retry with the used-up token: %d", code)
+	}
+	if code := push(minted.Token, "k2"); code != http.StatusForbidden {
+		t.Errorf("second use of a one-time token: %d", code)
+	}
+
+	resp = do(http.MethodDelete, base+"/upload-tokens/"+minted.ID, "", auth)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("revoke: %d", resp.StatusCode)
+	}
+	resp = do(http.MethodGet, base+"/upload-tokens", "", auth)
+	var list []struct {
+		Uses      int        `json:"uses"`
+		Token     string     `json:"token"`
+		RevokedAt *time.Time `json:"revokedAt"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 1 || list[0].Uses != 1 || list[0].RevokedAt == nil || list[0].Token != "" {
+		t.Errorf("token list %+v", list)
+	}
+}
+
+func TestHostedForm(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	assets, err := handler.NewStatic(vendored(fstest.MapFS{"nb.js": {Data: []byte("/* widget */")}}))
+	if err != nil {
+		t.Fatalf("NewStatic: %v", err)
+	}
+	page, err := handler.NewFormPage(svc, assets, "/api/nb/v1")
+	if err != nil {
+		t.Fatalf("NewFormPage: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/nb/", handler.SetupNBRoutes(svc, cfg))
+	mux.Handle("GET "+handler.FormPath+"{appID}", page)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	owner := registerOwner(t, srv.URL, appID)
+	fs.exists = true
+
+	put := func(body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/nb/v1/apps/"+appID.String()+"/form", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+owner)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT form: %v", err)
+		}
+		return resp
+	}
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get(handler.FormPath + appID.String()); code != http.StatusNotFound {
+		t.Errorf("page before any form: %d", code)
+	}
+	for _, bad := range []string{
+		`{"title": "x", "fields": []}`,
+		`{"title": "x", "fields": [{"name": "_nb_reply", "label": "x", "type": "text"}]}`,
+		`{"title": "x", "fields": [{"name": "a", "label": "x", "type": "file"}]}`,
+		`{"title": "x", "fields": [{"name": "a", "label": "x", "type": "select"}]}`,
+		`{"title": "x", "formID": "unregistered", "fields": [{"name": "a", "label": "x", "type": "text"}]}`,
+	} {
+		resp := put(bad)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("PUT %s: %d, want 400", bad, resp.StatusCode)
+		}
+	}
+
+	resp := put(`{"title": "Report <b>abuse</b>", "success": "Thanks!",
+		"fields": [{"name": "email", "label": "Email", "type": "email"},
+		           {"name": "what", "label": "What happened", "type": "textarea", "required": true}]}`)
+	resp.Body.Close()
+	resp = put(`{"title": "Report abuse", "fields": [{"name": "kind", "label": "Kind", "type": "select",
+		"options": ["spam", "harassment"], "required": true}]}`)
+	var v2 struct {
+		Version int    `json:"version"`
+		Link    string `json:"link"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&v2)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || v2.Version != 2 {
+		t.Fatalf("second PUT: %d %+v", resp.StatusCode, v2)
+	}
+
+	code, body := get("/nb/v1/form/" + appID.String() + "?version=1")
+	if code != http.StatusOK || !strings.Contains(body, `"version":1`) || !strings.Contains(body, `"what"`) {
+		t.Errorf("definition v1: %d %s", code, body)
+	}
+
+	code, page1 := get(handler.FormPath + appID.String() + "?version=1")
+	if code != http.StatusOK {
+		t.Fatalf("page v1: %d", code)
+	}
+	sri := assets.Manifest()["nb.js"]
+	for _, want := range []string{
+		`<input type="hidden" name="_nb_form_version" value="1">`,
+		`<textarea id="nb-what" name="what" rows="6" required>`,
+		`data-nb-success="Thanks!"`,
+		`src="` + sri.Path + `" integrity="` + sri.Integrity + `"`,
+		`data-nb-app="` + appID.String() + `" data-nb-api="/api/nb/v1"`,
+		`Report &lt;b&gt;abuse&lt;/b&gt;`,
+	} {
+		if !strings.Contains(page1, want) {
+			t.Errorf("page v1 lacks %s", want)
+		}
+	}
+	if _, latest := get(v2.Link[:strings.Index(v2.Link, "?")]); !strings.Contains(latest, `<option>harassment</option>`) ||
+		!strings.Contains(latest, `value="2"`) {
+		t.Errorf("latest page is not version 2:\n%s", latest)
+	}
+}
+
+func TestFormIDs(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{}
+	srv := httptest.NewServer(newAPI(fs, 1024))
+	defer srv.Close()
+	owner := registerOwner(t, srv.URL, appID)
+	fs.exists = true
+	base := srv.URL + "/nb/v1/apps/" + appID.String()
+
+	do := func(method, url, body string, header map[string]string) *http.Response {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+		return resp
+	}
+	auth := map[string]string{"Authorization": "Bearer " + owner}
+	status := func(resp *http.Response) int {
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	push := func(form string) int {
+		return status(do(http.MethodPost, srv.URL+"/nb/v1/push/"+appID.String()+"/1", "sealed",
+			map[string]string{handler.HeaderFormID: form}))
+	}
+
+	if code := status(do(http.MethodPut, base+"/forms/contact", `{"label": "Contact", "maxAgeSeconds": 86400}`, auth)); code != http.StatusOK {
+		t.Fatalf("register contact: %d", code)
+	}
+	if code := status(do(http.MethodPut, base+"/forms/jobs", `{"rateLimitMinute": 1}`, auth)); code != http.StatusOK {
+		t.Fatalf("register jobs: %d", code)
+	}
+	if code := status(do(http.MethodPut, base+"/forms/no%20spaces", `{}`, auth)); code != http.StatusBadRequest {
+		t.Errorf("invalid form id: %d", code)
+	}
+	resp := do(http.MethodGet, base+"/forms", "", auth)
+	var forms []struct {
+		ID            string `json:"id"`
+		MaxAgeSeconds int64  `json:"maxAgeSeconds"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&forms)
+	resp.Body.Close()
+	if len(forms) != 2 || forms[0].ID != "contact" || forms[0].MaxAgeSeconds != 86400 {
+		t.Errorf("forms %+v", forms)
+	}
+
+	if code := push("contact"); code != http.StatusCreated {
+		t.Fatalf("push to contact: %d", code)
+	}
+	if code := push(""); code != http.StatusCreated {
+		t.Fatalf("push without form: %d", code)
+	}
+	if code := push("bugs"); code != http.StatusBadRequest {
+		t.Errorf("push to unregistered form: %d", code)
+	}
+	if code := push("jobs"); code != http.StatusCreated {
+		t.Fatalf("push to jobs: %d", code)
+	}
+	if code := push("jobs"); code != http.StatusTooManyRequests {
+		t.Errorf("push over the jobs limit: %d", code)
+	}
+
+	resp = do(http.MethodGet, srv.URL+"/nb/v1/pull?format=ndjson&formID=contact&appID="+appID.String(), "", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"formID":"contact"`) {
+		t.Errorf("pull filtered by form:\n%s", body)
+	}
+
+	if code := status(do(http.MethodPost, base+"/webhooks", `{"url": "https://example.com/h", "formID": "bugs"}`, auth)); code != http.StatusBadRequest {
+		t.Errorf("webhook for unregistered form: %d", code)
+	}
+	if code := status(do(http.MethodPost, base+"/webhooks", `{"url": "https://example.com/h", "formID": "jobs"}`, auth)); code != http.StatusCreated {
+		t.Fatalf("webhook for jobs: %d", code)
+	}
+	if fs.webhooks[0].FormID != "jobs" {
+		t.Errorf("webhook form %q", fs.webhooks[0].FormID)
+	}
+	if code := status(do(http.MethodDelete, base+"/forms/jobs", "", auth)); code != http.StatusNoContent {
+		t.Errorf("delete jobs: %d", code)
+	}
+	if len(fs.webhooks) != 0 {
+		t.Errorf("webhooks of a deleted form remain: %+v", fs.webhooks)
+	}
+	if code := status(do(http.MethodDelete, base+"/forms/jobs", "", auth)); code != http.StatusNotFound {
+		t.Errorf("delete jobs again: %d", code)
+	}
+}
+
+func TestPadding(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{}
+	cfg := testConfig(1024)
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+	owner := registerOwner(t, srv.URL, appID)
+	fs.exists = true
+
+	put := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/nb/v1/apps/"+appID.String()+"/padding", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+owner)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := put(`{"mode": "pow2", "size": 100}`); code != http.StatusBadRequest {
+		t.Errorf("size that is no power of two: %d", code)
+	}
+	if code := put(`{"mode": "pow2", "size": 128}`); code != http.StatusOK {
+		t.Fatalf("set padding: %d", code)
+	}
+
+	resp, err := http.Get(srv.URL + "/nb/v1/pub?appID=" + appID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub struct {
+		Padding model.Padding `json:"padding"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&pub)
+	resp.Body.Close()
+	if pub.Padding != (model.Padding{Mode: model.PaddingPow2, Size: 128}) {
+		t.Errorf("/pub advertises %+v", pub.Padding)
+	}
+
+	push := func(n int) int {
+		resp, err := http.Post(srv.URL+"/nb/v1/push/"+appID.String()+"/1", "application/octet-stream", bytes.NewReader(make([]byte, n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := push(100); code != http.StatusBadRequest {
+		t.Errorf("unpadded push: %d", code)
+	}
+	if code := push(256); code != http.StatusCreated {
+		t.Errorf("padded push: %d", code)
+	}
+}
+
+func TestStreamHandler_ReplayAndLive(t *testing.T) {
+	appID := uuid.New()
+	start := time.Now().UTC().Add(-time.Minute).Truncate(time.Microsecond)
+	fs := &fakeStore{
+		exists: true,
+		submissions: []*model.Submission{
+			{ID: uuid.New(), AppID: appID, TS: start, Blob: []byte("old"), Pos: model.FeedPos{Seq: 1}},
+			// stamped earlier but committed later: must still be replayed
+			{ID: uuid.New(), AppID: appID, TS: start.Add(-time.Second), Blob: []byte("missed"), Pos: model.FeedPos{Seq: 2}},
+		},
+	}
+	cfg := testConfig(1024)
+	svc := service.New(fs, cfg)
+	srv := httptest.NewServer(handler.SetupNBRoutes(svc, cfg))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/nb/v1/stream?appID="+appID.String(), nil)
+	req.Header.Set("Last-Event-ID", service.CursorOf(fs.submissions[0]).String())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET stream error: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	events := make(chan map[string]interface{})
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				var ev map[string]interface{}
+				_ = json.Unmarshal([]byte(data), &ev)
+				events <- ev
+			}
+		}
+		close(events)
+	}()
+	next := func() map[string]interface{} {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return nil
+		}
+	}
+
+	if ev := next(); ev["blob"] != base64.StdEncoding.EncodeToString([]byte("missed")) {
+		t.Fatalf("replay: unexpected event %v", ev)
+	}
+	if _, err := svc.Push(context.Background(), appID, 1, []byte("live")); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if ev := next(); ev["blob"] != base64.StdEncoding.EncodeToString([]byte("live")) || ev["size"] != float64(4) {
+		t.Fatalf("live: unexpected event %v", ev)
+	}
+}
+
+func TestPullHandler_MidStreamErrorTrailer(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{
+		submissions: []*model.Submission{{ID: uuid.New(), AppID: appID, Blob: []byte("a")}},
+		streamErr:   errors.New("db went away"),
+	}
+	srv := httptest.NewServer(newAPI(fs, 1024))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/nb/v1/pull?appID=" + appID.String())
+	if err != nil {
+		t.Fatalf("GET pull error: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "YQ==\n" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Trailer.Get(handler.TrailerStreamError); got != "db went away" {
+		t.Errorf("want stream error trailer, got %q", got)
+	}
+}
+
+func TestPullHandler_ErrorBeforeBody(t *testing.T) {
+	fs := &fakeStore{streamErr: errors.New("db down")}
+	srv := httptest.NewServer(newAPI(fs, 1024))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/nb/v1/pull?appID=" + uuid.NewString())
+	if err != nil {
+		t.Fatalf("GET pull error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("want 500, got %d", resp.StatusCode)
+	}
+}
+
+func TestRequestID_EchoAndAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Options{Format: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &fakeStore{exists: true}
+	srv := httptest.NewServer(newAPI(fs, 1024, handler.WithLogger(logger)))
+	defer srv.Close()
+
+	appID := uuid.NewString()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/nb/v1/push/"+appID+"/1", strings.NewReader("sealed"))
+	req.Header.Set(handler.HeaderRequestID, "trace-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST push error: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(handler.HeaderRequestID); got != "trace-123" {
+		t.Errorf("request ID not echoed: %q", got)
+	}
+
+	line := buf.String()
+	if !strings.Contains(line, `"requestID":"trace-123"`) || !strings.Contains(line, `"route":"POST /nb/v1/push/{appID}/{kid}"`) {
+		t.Errorf("access log lacks request ID or route: %s", line)
+	}
+	if strings.Contains(line, appID) || strings.Contains(line, "127.0.0.1") {
+		t.Errorf("access log leaks app ID or client IP: %s", line)
+	}
+
+	// An unusable incoming ID is replaced by a fresh one.
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/nb/v1/pub", nil)
+	req.Header.Set(handler.HeaderRequestID, "bad id\twith spaces")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET pub error: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(handler.HeaderRequestID); len(got) != 32 {
+		t.Errorf("expected a generated request ID, got %q", got)
+	}
+}
+
+func TestRateLimit_PerClientAndReload(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	cfg := config.NewLive(config.Config{MaxBlobBytes: 1024, RateLimitMinute: 1, RateLimitBurst: 2})
+	srv := httptest.NewServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	defer srv.Close()
+
+	push := func() *http.Response {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/nb/v1/push/"+uuid.NewString()+"/1", "application/octet-stream", strings.NewReader("x"))
+		if err != nil {
+			t.Fatalf("POST push error: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	for i := 0; i < 2; i++ {
+		if resp := push(); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("push %d within burst: status %d", i, resp.StatusCode)
+		}
+	}
+	resp := push()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("push over the limit: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	next := *cfg.Load()
+	next.RateLimitMinute = 0
+	cfg.Reload(next)
+	if resp := push(); resp.StatusCode != http.StatusCreated {
+		t.Errorf("rate limiting disabled by reload, still got %d", resp.StatusCode)
+	}
+}
+
+func TestRateLimit_SkipsUnixSocketPeers(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "nb.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen %s: %v", sock, err)
+	}
+	fs := &fakeStore{exists: true}
+	cfg := config.NewLive(config.Config{MaxBlobBytes: 1024, RateLimitMinute: 1, RateLimitBurst: 1})
+	srv := httptest.NewUnstartedServer(handler.SetupNBRoutes(service.New(fs, cfg), cfg))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post("http://nb/nb/v1/push/"+uuid.NewString()+"/1", "application/octet-stream", strings.NewReader("x"))
+		if err != nil {
+			t.Fatalf("POST push error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("push %d over the socket: status %d, want %d", i, resp.StatusCode, http.StatusCreated)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/collapsinghierarchy/noisybuffer/config"
+)
+
+// Content-Security-Policy bases for SecurityHeaders; frame-ancestors is
+// appended from config. The web UI keeps private keys in localStorage, so
+// it may only run scripts and styles from its own origin: no inline code,
+// no CDNs (the HPKE modules are vendored).
+const (
+	CSPWeb = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self' data:; " +
+		"connect-src 'self'; form-action 'self'; base-uri 'none'; object-src 'none'"
+	CSPAPI = "default-src 'none'; base-uri 'none'"
+)
+
+// SecurityHeaders sets the CSP csp plus frame-ancestors from cfg, and
+// nosniff, no-referrer and cross-origin isolation (COOP/COEP) on every
+// response. Resources stay same-origin (CORP) unless the handler widens
+// it, as Static does for the widget files other sites embed.
+func SecurityHeaders(cfg *config.Live, csp string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ancestors := cfg.Load().FrameAncestors
+			h := w.Header()
+			h.Set("Content-Security-Policy", csp+"; frame-ancestors "+frameAncestors(ancestors))
+			if xfo := frameOptions(ancestors); xfo != "" {
+				h.Set("X-Frame-Options", xfo) // for browsers without CSP level 2
+			}
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("Referrer-Policy", "no-referrer")
+			h.Set("Cross-Origin-Opener-Policy", "same-origin")
+			h.Set("Cross-Origin-Embedder-Policy", "require-corp")
+			h.Set("Cross-Origin-Resource-Policy", "same-origin")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func frameAncestors(sources []string) string {
+	if len(sources) == 0 {
+		return "'none'"
+	}
+	return strings.Join(sources, " ")
+}
+
+// frameOptions approximates the allowed ancestors; X-Frame-Options cannot
+// name other origins, so those lists rely on CSP alone.
+func frameOptions(sources []string) string {
+	switch {
+	case len(sources) == 0 || slices.Equal(sources, []string{"'none'"}):
+		return "DENY"
+	case slices.Equal(sources, []string{"'self'"}):
+		return "SAMEORIGIN"
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/collapsinghierarchy/noisybuffer/handler"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	cfg := testConfig(1024)
+	st, err := handler.NewStatic(vendored(fstest.MapFS{
+		"nb.js":      {Data: []byte("/* widget */")},
+		"index.html": {Data: []byte("<h1>hi</h1>")},
+	}))
+	if err != nil {
+		t.Fatalf("NewStatic: %v", err)
+	}
+	h := handler.SecurityHeaders(cfg, handler.CSPWeb)(st)
+	get := func(path string) http.Header {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Header()
+	}
+
+	page := get("/index.html")
+	csp := page.Get("Content-Security-Policy")
+	for _, want := range []string{"script-src 'self'", "style-src 'self'", "frame-ancestors 'none'"} {
+		if !strings.Contains(csp, want) {
+			t.Errorf("CSP %q lacks %q", csp, want)
+		}
+	}
+	if strings.Contains(csp, "unsafe-inline") {
+		t.Errorf("CSP must not allow inline code: %q", csp)
+	}
+	for k, want := range map[string]string{
+		"X-Content-Type-Options":       "nosniff",
+		"Referrer-Policy":              "no-referrer",
+		"X-Frame-Options":              "DENY",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "require-corp",
+		"Cross-Origin-Resource-Policy": "same-origin",
+	} {
+		if got := page.Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+	if got := get("/nb.js").Get("Cross-Origin-Resource-Policy"); got != "cross-origin" {
+		t.Errorf("nb.js must be embeddable cross-origin, CORP = %q", got)
+	}
+
+	c := *cfg.Load()
+	c.FrameAncestors = []string{"'self'", "https://example.com"}
+	cfg.Reload(c)
+	page = get("/index.html")
+	if csp := page.Get("Content-Security-Policy"); !strings.HasSuffix(csp, "frame-ancestors 'self' https://example.com") {
+		t.Errorf("reloaded frame-ancestors not applied: %q", csp)
+	}
+	if xfo := page.Get("X-Frame-Options"); xfo != "" {
+		t.Errorf("X-Frame-Options cannot express other origins, got %q", xfo)
+	}
+}
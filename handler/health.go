@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+// pingTimeout bounds the store check behind /readyz.
+const pingTimeout = 2 * time.Second
+
+// Health serves the liveness (/healthz) and readiness (/readyz) probes.
+// Readiness fails while the store is unreachable or its schema outdated,
+// and for good once Drain was called.
+type Health struct {
+	store    store.Store
+	log      *slog.Logger
+	draining atomic.Bool
+}
+
+func NewHealth(st store.Store, log *slog.Logger) *Health {
+	return &Health{store: st, log: log}
+}
+
+// Drain makes /readyz fail so load balancers stop sending new requests
+// before the server starts shutting down.
+func (h *Health) Drain() { h.draining.Store(true) }
+
+type readyResp struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Live answers 200 as long as the process serves HTTP at all.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// Ready answers 200 when this instance should receive traffic and 503
+// otherwise. Failure details go to the log, not the response.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	resp := readyResp{Status: "ok", Checks: map[string]string{"store": "ok", "draining": "no"}}
+
+	if h.draining.Load() {
+		resp.Status, resp.Checks["draining"] = "unavailable", "yes"
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	defer cancel()
+	if err := h.store.Ping(ctx); err != nil {
+		h.log.WarnContext(r.Context(), "readiness: store check failed", "err", err)
+		resp.Status, resp.Checks["store"] = "unavailable", "failing"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// Register mounts the probes on mux.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", h.Live)
+	mux.HandleFunc("GET /readyz", h.Ready)
+}
@@ -0,0 +1,52 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/collapsinghierarchy/noisybuffer/handler"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+type pingStore struct {
+	store.Store
+	err error
+}
+
+func (p *pingStore) Ping(ctx context.Context) error { return p.err }
+
+func probe(t *testing.T, h *handler.Health, path string) int {
+	t.Helper()
+	mux := http.NewServeMux()
+	h.Register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestHealth_Readiness(t *testing.T) {
+	st := &pingStore{}
+	h := handler.NewHealth(st, slog.Default())
+
+	if code := probe(t, h, "/readyz"); code != http.StatusOK {
+		t.Fatalf("healthy store: /readyz = %d", code)
+	}
+
+	st.err = errors.New("connection refused")
+	if code := probe(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("failing store: /readyz = %d", code)
+	}
+	if code := probe(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("liveness must not depend on the store: /healthz = %d", code)
+	}
+
+	st.err = nil
+	h.Drain()
+	if code := probe(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("draining: /readyz = %d", code)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/collapsinghierarchy/noisybuffer/config"
+)
+
+// limiterIdle is how long a client's bucket survives without requests.
+const limiterIdle = 10 * time.Minute
+
+// rateLimiter keeps one token bucket per client IP. Limits are read from
+// the live config on every request; when they change, all buckets start
+// over with the new values.
+type rateLimiter struct {
+	cfg *config.Live
+
+	mu      sync.Mutex
+	perMin  int
+	burst   int
+	clients map[string]*client
+	swept   time.Time
+}
+
+type client struct {
+	lim  *rate.Limiter
+	seen time.Time
+}
+
+func newRateLimiter(cfg *config.Live) *rateLimiter {
+	return &rateLimiter{cfg: cfg, clients: make(map[string]*client)}
+}
+
+// allow reports whether ip may proceed and, if not, how long to wait.
+func (rl *rateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	c := rl.cfg.Load()
+	if c.RateLimitMinute <= 0 {
+		return true, 0
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if c.RateLimitMinute != rl.perMin || c.RateLimitBurst != rl.burst {
+		rl.perMin, rl.burst = c.RateLimitMinute, c.RateLimitBurst
+		clear(rl.clients)
+	}
+	if now.Sub(rl.swept) > limiterIdle {
+		for k, cl := range rl.clients {
+			if now.Sub(cl.seen) > limiterIdle {
+				delete(rl.clients, k)
+			}
+		}
+		rl.swept = now
+	}
+
+	cl, ok := rl.clients[ip]
+	if !ok {
+		cl = &client{lim: rate.NewLimiter(rate.Limit(float64(rl.perMin)/60), rl.burst)}
+		rl.clients[ip] = cl
+	}
+	cl.seen = now
+	r := cl.lim.ReserveN(now, 1)
+	if d := r.DelayFrom(now); d > 0 || !r.OK() {
+		r.CancelAt(now)
+		return false, d
+	}
+	return true, 0
+}
+
+// limit answers 429 with Retry-After once a client exceeds the configured
+// rate. Clients are told apart by their connection's IP address.
+// Connections without one (a Unix socket, where every peer looks the same)
+// are not limited: one bucket for all of them would let a single busy
+// client lock everyone out. The reverse proxy in front has to limit them.
+func (rl *rateLimiter) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := rl.allow(addr.Addr().String(), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(wait, time.Second).Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Fingerprinted lists the assets that get content-hashed URLs and SRI
+// entries: files third-party pages embed with <script src=…>.
+var Fingerprinted = []string{"nb.js"}
+
+// SRIPath serves the integrity manifest.
+const SRIPath = "/sri.json"
+
+// VendorManifest maps vendored third-party modules (relative to vendor/)
+// to their SRI hashes. scripts/vendor-hpke.sh writes both; the hashes are
+// checked whenever a Static is built, so a missing or modified module
+// fails startup.
+const (
+	VendorManifest = "vendor/integrity.json"
+	HPKEModule     = "vendor/hpke.js" // @hpke/core + hybridkem-x25519-kyber768
+)
+
+var (
+	// ErrVendorMismatch means a vendored module differs from its recorded hash.
+	ErrVendorMismatch = errors.New("vendored module does not match its integrity hash")
+	// ErrNotVendored means the HPKE module is missing, so nothing served
+	// could encrypt.
+	ErrNotVendored = errors.New("HPKE module not vendored; run scripts/vendor-hpke.sh")
+)
+
+// SRIEntry pins one fingerprinted asset: its immutable URL path and its
+// Subresource Integrity hash.
+type SRIEntry struct {
+	Path      string `json:"path"`
+	Integrity string `json:"integrity"`
+}
+
+// Static serves the web UI from fsys. Every Fingerprinted file is also
+// available as name.<hash>.ext with a one-year immutable cache, while the
+// plain name is revalidated on each use so new releases are picked up.
+type Static struct {
+	files    http.Handler
+	hashed   map[string]*asset   // URL path → content
+	plain    map[string]*asset   // "/nb.js" → same content
+	manifest map[string]SRIEntry // asset name → entry
+	started  time.Time
+}
+
+type asset struct {
+	name  string
+	body  []byte
+	etag  string
+	ctype string
+}
+
+// NewStatic hashes the Fingerprinted files once; serving from a directory
+// therefore needs a restart to pick up new hashed URLs.
+func NewStatic(fsys fs.FS) (*Static, error) {
+	s := &Static{
+		files:    http.FileServerFS(fsys),
+		hashed:   make(map[string]*asset),
+		plain:    make(map[string]*asset),
+		manifest: make(map[string]SRIEntry),
+		started:  time.Now(),
+	}
+	for _, name := range Fingerprinted {
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("static: %w", err)
+		}
+		sum := sha256.Sum256(body)
+		short := hex.EncodeToString(sum[:6])
+		a := &asset{
+			name:  name,
+			body:  body,
+			etag:  `"` + short + `"`,
+			ctype: mime.TypeByExtension(path.Ext(name)),
+		}
+		ext := path.Ext(name)
+		hashedPath := "/" + strings.TrimSuffix(name, ext) + "." + short + ext
+		s.hashed[hashedPath] = a
+		s.plain["/"+name] = a
+		s.manifest[name] = SRIEntry{Path: hashedPath, Integrity: Integrity(body)}
+	}
+	if err := s.verifyVendored(fsys); err != nil {
+		return nil, fmt.Errorf("static: %w", err)
+	}
+	return s, nil
+}
+
+// verifyVendored checks every module listed in VendorManifest and adds it
+// to the SRI manifest. HPKEModule must be among them.
+func (s *Static) verifyVendored(fsys fs.FS) error {
+	raw, err := fs.ReadFile(fsys, VendorManifest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s is missing", ErrNotVendored, VendorManifest)
+	}
+	if err != nil {
+		return err
+	}
+	var want map[string]string
+	if err := json.Unmarshal(raw, &want); err != nil {
+		return fmt.Errorf("%s: %w", VendorManifest, err)
+	}
+	for file, integrity := range want {
+		name := path.Join(path.Dir(VendorManifest), file)
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		if got := Integrity(body); got != integrity {
+			return fmt.Errorf("%w: %s is %s, want %s", ErrVendorMismatch, name, got, integrity)
+		}
+		s.manifest[name] = SRIEntry{Path: "/" + name, Integrity: integrity}
+	}
+	if _, ok := s.manifest[HPKEModule]; !ok {
+		return fmt.Errorf("%w: %s does not list it", ErrNotVendored, VendorManifest)
+	}
+	return nil
+}
+
+// Integrity returns the sha384 SRI value for body.
+func Integrity(body []byte) string {
+	sum := sha512.Sum384(body)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Manifest returns the SRI entries keyed by asset name.
+func (s *Static) Manifest() map[string]SRIEntry { return s.manifest }
+
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+	if s.embeddable(p) {
+		// Loaded by third-party pages: nb.js as a classic script, the
+		// vendored modules via import(), which is a CORS request.
+		w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	switch {
+	case p == SRIPath:
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.manifest)
+	case s.hashed[p] != nil:
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		s.serve(w, r, s.hashed[p])
+	case s.plain[p] != nil:
+		w.Header().Set("Cache-Control", "no-cache")
+		s.serve(w, r, s.plain[p])
+	default:
+		s.files.ServeHTTP(w, r)
+	}
+}
+
+// embeddable reports whether p is meant to be fetched from other origins.
+func (s *Static) embeddable(p string) bool {
+	if p == SRIPath || s.hashed[p] != nil || s.plain[p] != nil {
+		return true
+	}
+	_, ok := s.manifest[strings.TrimPrefix(p, "/")]
+	return ok
+}
+
+func (s *Static) serve(w http.ResponseWriter, r *http.Request, a *asset) {
+	w.Header().Set("ETag", a.etag)
+	if a.ctype != "" {
+		w.Header().Set("Content-Type", a.ctype)
+	}
+	http.ServeContent(w, r, a.name, s.started, bytes.NewReader(a.body))
+}
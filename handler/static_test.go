@@ -0,0 +1,98 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/collapsinghierarchy/noisybuffer/handler"
+)
+
+// vendored adds a stand-in HPKE module and its manifest to fsys.
+func vendored(fsys fstest.MapFS) fstest.MapFS {
+	lib := []byte("export const x = 1;")
+	fsys[handler.HPKEModule] = &fstest.MapFile{Data: lib}
+	fsys[handler.VendorManifest] = &fstest.MapFile{Data: []byte(`{"hpke.js": "` + handler.Integrity(lib) + `"}`)}
+	return fsys
+}
+
+func TestStatic_FingerprintAndSRI(t *testing.T) {
+	widget := []byte("/* widget */")
+	st, err := handler.NewStatic(vendored(fstest.MapFS{
+		"nb.js":      {Data: widget},
+		"index.html": {Data: []byte("<h1>hi</h1>")},
+	}))
+	if err != nil {
+		t.Fatalf("NewStatic: %v", err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		st.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	var manifest map[string]handler.SRIEntry
+	if err := json.NewDecoder(get(handler.SRIPath).Body).Decode(&manifest); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	entry := manifest["nb.js"]
+	if !strings.HasPrefix(entry.Path, "/nb.") || entry.Integrity != handler.Integrity(widget) {
+		t.Fatalf("unexpected manifest entry: %+v", entry)
+	}
+
+	hashed := get(entry.Path)
+	body, _ := io.ReadAll(hashed.Body)
+	if hashed.Code != http.StatusOK || string(body) != string(widget) {
+		t.Fatalf("hashed URL: %d %q", hashed.Code, body)
+	}
+	if cc := hashed.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("hashed URL should be immutable, got %q", cc)
+	}
+	if ct := hashed.Header().Get("Content-Type"); !strings.Contains(ct, "javascript") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if cc := get("/nb.js").Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("plain nb.js should revalidate, got %q", cc)
+	}
+	if rec := get("/index.html"); rec.Code != http.StatusOK && rec.Code != http.StatusMovedPermanently {
+		t.Errorf("other files not served: %d", rec.Code)
+	}
+	if rec := get("/nb.0000deadbeef.js"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown hash should 404, got %d", rec.Code)
+	}
+}
+
+func TestStatic_VendoredModules(t *testing.T) {
+	lib := []byte("export const x = 1;")
+	fsys := fstest.MapFS{
+		"nb.js":                {Data: []byte("/* widget */")},
+		handler.HPKEModule:     {Data: lib},
+		handler.VendorManifest: {Data: []byte(`{"hpke.js": "` + handler.Integrity(lib) + `"}`)},
+	}
+	st, err := handler.NewStatic(fsys)
+	if err != nil {
+		t.Fatalf("NewStatic: %v", err)
+	}
+	if e := st.Manifest()[handler.HPKEModule]; e.Path != "/vendor/hpke.js" || e.Integrity != handler.Integrity(lib) {
+		t.Fatalf("vendored module not in manifest: %+v", e)
+	}
+
+	fsys[handler.HPKEModule] = &fstest.MapFile{Data: []byte("export const x = 2;")}
+	if _, err := handler.NewStatic(fsys); !errors.Is(err, handler.ErrVendorMismatch) {
+		t.Fatalf("tampered module: got %v, want ErrVendorMismatch", err)
+	}
+
+	delete(fsys, handler.VendorManifest)
+	if _, err := handler.NewStatic(fsys); !errors.Is(err, handler.ErrNotVendored) {
+		t.Fatalf("missing manifest: got %v, want ErrNotVendored", err)
+	}
+	fsys[handler.VendorManifest] = &fstest.MapFile{Data: []byte(`{}`)}
+	if _, err := handler.NewStatic(fsys); !errors.Is(err, handler.ErrNotVendored) {
+		t.Fatalf("manifest without hpke.js: got %v, want ErrNotVendored", err)
+	}
+}
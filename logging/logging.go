@@ -0,0 +1,136 @@
+// Package logging builds the structured logger shared by noisybufferd's
+// packages and enforces its privacy policy: attributes that identify people
+// or tenants are dropped centrally, so call sites can log them freely.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Attribute keys with privacy implications. Records carrying them are
+// filtered by the handler returned from New according to Policy; KeyBlob
+// is never written.
+const (
+	KeyClientIP  = "clientIP"
+	KeyAppID     = "appID"
+	KeyBlob      = "blob"
+	KeyRequestID = "requestID"
+)
+
+// Policy opts in to logging personal or tenant data. The zero value logs
+// neither client IPs nor app IDs.
+type Policy struct {
+	ClientIPs bool
+	AppIDs    bool
+}
+
+// Options configures New.
+type Options struct {
+	Level  slog.Leveler // a *slog.LevelVar allows changing it at runtime
+	Format string       // "text" (default) or "json"
+	Policy Policy
+}
+
+// New returns a logger writing to w that tags records with the request ID
+// found in their context and strips attributes the policy does not allow.
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	ho := &slog.HandlerOptions{Level: opts.Level}
+	var h slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, ho)
+	case "json":
+		h = slog.NewJSONHandler(w, ho)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", opts.Format)
+	}
+	return slog.New(&handler{next: h, policy: opts.Policy}), nil
+}
+
+// ParseLevel accepts debug, info, warn and error (case-insensitive).
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
+
+// ---- request IDs -----------------------------------------------------------
+
+type ctxKey struct{}
+
+// WithRequestID stores id in ctx for log records and downstream calls.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RequestID returns the ID stored by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// ---- handler ---------------------------------------------------------------
+
+type handler struct {
+	next   slog.Handler
+	policy Policy
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	if id := RequestID(ctx); id != "" {
+		out.AddAttrs(slog.String(KeyRequestID, id))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if a, ok := h.filter(a); ok {
+			out.AddAttrs(a)
+		}
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	kept := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a, ok := h.filter(a); ok {
+			kept = append(kept, a)
+		}
+	}
+	return &handler{next: h.next.WithAttrs(kept), policy: h.policy}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name), policy: h.policy}
+}
+
+// filter drops disallowed attributes, descending into groups.
+func (h *handler) filter(a slog.Attr) (slog.Attr, bool) {
+	a.Value = a.Value.Resolve()
+	switch a.Key {
+	case KeyBlob:
+		return a, false
+	case KeyClientIP:
+		return a, h.policy.ClientIPs
+	case KeyAppID:
+		return a, h.policy.AppIDs
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		return a, true
+	}
+	var kept []any
+	for _, ga := range a.Value.Group() {
+		if ga, ok := h.filter(ga); ok {
+			kept = append(kept, ga)
+		}
+	}
+	return slog.Group(a.Key, kept...), true
+}
@@ -0,0 +1,72 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/collapsinghierarchy/noisybuffer/logging"
+)
+
+func TestPolicy_DropsSensitiveAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, logging.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.With(logging.KeyClientIP, "203.0.113.9").Info("hello",
+		logging.KeyAppID, "app-1",
+		logging.KeyBlob, "c2VjcmV0",
+		slog.Group("req", logging.KeyClientIP, "203.0.113.9", "kid", 3),
+		"status", 201,
+	)
+	out := buf.String()
+	for _, leak := range []string{"203.0.113.9", "app-1", "c2VjcmV0"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("default policy leaked %q: %s", leak, out)
+		}
+	}
+	if !strings.Contains(out, "status=201") || !strings.Contains(out, "req.kid=3") {
+		t.Errorf("regular attrs missing: %s", out)
+	}
+}
+
+func TestPolicy_OptIn(t *testing.T) {
+	var buf bytes.Buffer
+	l, _ := logging.New(&buf, logging.Options{Policy: logging.Policy{ClientIPs: true, AppIDs: true}})
+	l.Info("hello", logging.KeyClientIP, "203.0.113.9", logging.KeyAppID, "app-1", logging.KeyBlob, "c2VjcmV0")
+	out := buf.String()
+	if !strings.Contains(out, "203.0.113.9") || !strings.Contains(out, "app-1") {
+		t.Errorf("opted-in attrs missing: %s", out)
+	}
+	if strings.Contains(out, "c2VjcmV0") {
+		t.Errorf("blob must never be logged: %s", out)
+	}
+}
+
+func TestRequestIDAndFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, logging.Options{Format: "json", Level: slog.LevelWarn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := logging.WithRequestID(context.Background(), "abc")
+	l.InfoContext(ctx, "filtered by level")
+	l.WarnContext(ctx, "kept")
+	out := buf.String()
+	if strings.Contains(out, "filtered by level") {
+		t.Errorf("level not applied: %s", out)
+	}
+	if !strings.Contains(out, `"requestID":"abc"`) || !strings.Contains(out, `"msg":"kept"`) {
+		t.Errorf("unexpected json record: %s", out)
+	}
+
+	if _, err := logging.New(&buf, logging.Options{Format: "xml"}); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+	if l, err := logging.ParseLevel("DEBUG"); err != nil || l != slog.LevelDebug {
+		t.Errorf("ParseLevel(DEBUG) = %v, %v", l, err)
+	}
+}
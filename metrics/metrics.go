@@ -0,0 +1,114 @@
+// Package metrics exposes noisybufferd's Prometheus metrics: HTTP request
+// counters and latencies per route, submission counts and blob sizes,
+// store operation latencies (via a store.Store decorator) and pgxpool
+// statistics. Everything lives on a private registry served by Handler,
+// which belongs on the admin listener, not the public one.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "noisybuffer"
+
+// Options configures New.
+type Options struct {
+	// PerApp adds an app_id label to the submission counter. App IDs are
+	// unbounded, so only enable this for a modest number of apps.
+	PerApp bool
+}
+
+// Metrics owns the registry and the collectors updated by the HTTP layer
+// and the store decorator. A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests    *prometheus.CounterVec
+	latency     *prometheus.HistogramVec
+	submissions prometheus.Counter
+	perApp      *prometheus.CounterVec // nil unless Options.PerApp
+	blobBytes   prometheus.Histogram
+	deleted     *prometheus.CounterVec
+	storeOps    *prometheus.HistogramVec
+}
+
+func New(opts Options) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "http",
+			Name: "requests_total",
+			Help: "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "http",
+			Name:    "request_duration_seconds",
+			Help:    "HTTP request latency by route pattern and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "status"}),
+		submissions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "submissions_total",
+			Help:      "Submissions stored (idempotent replays excluded).",
+		}),
+		blobBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "submission_blob_bytes",
+			Help:      "Size of stored ciphertexts.",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8), // 256 B … 4 MiB
+		}),
+		deleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "submissions_deleted_total",
+			Help:      "Submissions deleted, by reason (retention, burn, owner, submitter).",
+		}, []string{"reason"}),
+		storeOps: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "store",
+			Name:    "operation_duration_seconds",
+			Help:    "Store operation latency by operation and result (ok, not_found, error).",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"op", "result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.latency, m.submissions, m.blobBytes, m.deleted, m.storeOps,
+	)
+	if opts.PerApp {
+		m.perApp = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "app_submissions_total",
+			Help:      "Submissions stored per app.",
+		}, []string{"app_id"})
+		m.registry.MustRegister(m.perApp)
+	}
+	return m
+}
+
+// Register adds further collectors, such as NewPoolCollector, to the
+// registry served by Handler.
+func (m *Metrics) Register(cs ...prometheus.Collector) {
+	m.registry.MustRegister(cs...)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records one finished HTTP request. route is the ServeMux
+// pattern, never the raw path, to keep label cardinality bounded.
+func (m *Metrics) ObserveRequest(route, method string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(route, method, code).Inc()
+	m.latency.WithLabelValues(route, code).Observe(d.Seconds())
+}
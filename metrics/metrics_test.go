@@ -0,0 +1,93 @@
+package metrics_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/collapsinghierarchy/noisybuffer/metrics"
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+type fakeStore struct {
+	store.Store
+	replay bool
+}
+
+func (f *fakeStore) InsertSubmission(ctx context.Context, s *model.Submission) error { return nil }
+func (f *fakeStore) InsertSubmissionOnce(ctx context.Context, s *model.Submission, key string, notBefore time.Time) (bool, error) {
+	return f.replay, nil
+}
+func (f *fakeStore) GetKey(ctx context.Context, appID uuid.UUID) (uint8, []byte, error) {
+	return 0, nil, sql.ErrNoRows
+}
+
+type fakeNotifier struct{ fakeStore }
+
+func (f *fakeNotifier) ListenSubmissions(ctx context.Context, fn func(uuid.UUID)) error { return nil }
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestWrapStore_RecordsSubmissionsAndOps(t *testing.T) {
+	m := metrics.New(metrics.Options{PerApp: true})
+	fs := &fakeStore{}
+	st := m.WrapStore(fs)
+	ctx := context.Background()
+	appID := uuid.New()
+
+	_ = st.InsertSubmission(ctx, &model.Submission{AppID: appID, Blob: make([]byte, 300)})
+	fs.replay = true
+	_, _ = st.InsertSubmissionOnce(ctx, &model.Submission{AppID: appID, Blob: make([]byte, 300)}, "k", time.Time{})
+	_, _, _ = st.GetKey(ctx, appID)
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		"noisybuffer_submissions_total 1",
+		`noisybuffer_app_submissions_total{app_id="` + appID.String() + `"} 1`,
+		"noisybuffer_submission_blob_bytes_count 1",
+		`noisybuffer_store_operation_duration_seconds_count{op="insert_submission",result="ok"} 1`,
+		`noisybuffer_store_operation_duration_seconds_count{op="get_key",result="not_found"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape lacks %q", want)
+		}
+	}
+}
+
+func TestWrapStore_KeepsNotifier(t *testing.T) {
+	m := metrics.New(metrics.Options{})
+	if _, ok := m.WrapStore(&fakeStore{}).(store.Notifier); ok {
+		t.Error("plain store must not become a Notifier")
+	}
+	if _, ok := m.WrapStore(&fakeNotifier{}).(store.Notifier); !ok {
+		t.Error("Notifier capability lost by the decorator")
+	}
+	if strings.Contains(scrape(t, m), "app_submissions_total") {
+		t.Error("per-app counter must be opt-in")
+	}
+}
+
+func TestObserveRequest(t *testing.T) {
+	var nilMetrics *metrics.Metrics
+	nilMetrics.ObserveRequest("GET /x", "GET", 200, time.Millisecond) // must not panic
+
+	m := metrics.New(metrics.Options{})
+	m.ObserveRequest("POST /nb/v1/push", "POST", 201, time.Millisecond)
+	want := `noisybuffer_http_requests_total{method="POST",route="POST /nb/v1/push",status="201"} 1`
+	if out := scrape(t, m); !strings.Contains(out, want) {
+		t.Errorf("scrape lacks %q", want)
+	}
+}
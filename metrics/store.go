@@ -0,0 +1,331 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+// WrapStore returns st instrumented with operation latencies, submission
+// counts and blob sizes. Optional capabilities of st (store.Notifier) are
+// preserved. A nil m returns st unchanged.
+func (m *Metrics) WrapStore(st store.Store) store.Store {
+	if m == nil {
+		return st
+	}
+	s := &instrumentedStore{next: st, m: m}
+	if n, ok := st.(store.Notifier); ok {
+		return &instrumentedNotifier{instrumentedStore: s, notifier: n}
+	}
+	return s
+}
+
+type instrumentedStore struct {
+	next store.Store
+	m    *Metrics
+}
+
+// observe records the latency of op since start, classified by err.
+func (s *instrumentedStore) observe(op string, start time.Time, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+	s.m.storeOps.WithLabelValues(op, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStore) stored(sub *model.Submission) {
+	s.m.submissions.Inc()
+	s.m.blobBytes.Observe(float64(len(sub.Blob)))
+	if s.m.perApp != nil {
+		s.m.perApp.WithLabelValues(sub.AppID.String()).Inc()
+	}
+}
+
+func (s *instrumentedStore) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { s.observe("ping", start, err) }(time.Now())
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedStore) InsertSubmission(ctx context.Context, sub *model.Submission) (err error) {
+	defer func(start time.Time) { s.observe("insert_submission", start, err) }(time.Now())
+	if err = s.next.InsertSubmission(ctx, sub); err == nil {
+		s.stored(sub)
+	}
+	return err
+}
+
+func (s *instrumentedStore) InsertSubmissions(ctx context.Context, subs []*model.Submission) (err error) {
+	defer func(start time.Time) { s.observe("insert_submissions", start, err) }(time.Now())
+	if err = s.next.InsertSubmissions(ctx, subs); err == nil {
+		for _, sub := range subs {
+			s.stored(sub)
+		}
+	}
+	return err
+}
+
+func (s *instrumentedStore) IdempotencyClaim(ctx context.Context, appID uuid.UUID, key string, notBefore time.Time) (id uuid.UUID, ts time.Time, err error) {
+	defer func(start time.Time) { s.observe("idempotency_claim", start, err) }(time.Now())
+	return s.next.IdempotencyClaim(ctx, appID, key, notBefore)
+}
+
+func (s *instrumentedStore) InsertSubmissionOnce(ctx context.Context, sub *model.Submission, key string, notBefore time.Time) (replayed bool, err error) {
+	defer func(start time.Time) { s.observe("insert_submission_once", start, err) }(time.Now())
+	replayed, err = s.next.InsertSubmissionOnce(ctx, sub, key, notBefore)
+	if err == nil && !replayed {
+		s.stored(sub)
+	}
+	return replayed, err
+}
+
+func (s *instrumentedStore) StreamSubmissions(ctx context.Context, appID uuid.UUID, formID string, fn func(*model.Submission) error) (err error) {
+	defer func(start time.Time) { s.observe("stream_submissions", start, err) }(time.Now())
+	return s.next.StreamSubmissions(ctx, appID, formID, fn)
+}
+
+func (s *instrumentedStore) StreamSubmissionsAfter(ctx context.Context, appID uuid.UUID, after model.FeedPos, fn func(*model.Submission) error) (err error) {
+	defer func(start time.Time) { s.observe("stream_submissions_after", start, err) }(time.Now())
+	return s.next.StreamSubmissionsAfter(ctx, appID, after, fn)
+}
+
+func (s *instrumentedStore) FeedHead(ctx context.Context) (pos model.FeedPos, err error) {
+	defer func(start time.Time) { s.observe("feed_head", start, err) }(time.Now())
+	return s.next.FeedHead(ctx)
+}
+
+func (s *instrumentedStore) AckSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (acked, burned int64, err error) {
+	defer func(start time.Time) { s.observe("ack_submissions", start, err) }(time.Now())
+	acked, burned, err = s.next.AckSubmissions(ctx, appID, ids)
+	s.m.deleted.WithLabelValues("burn").Add(float64(burned))
+	return acked, burned, err
+}
+
+func (s *instrumentedStore) GetRetention(ctx context.Context, appID uuid.UUID) (r *model.Retention, err error) {
+	defer func(start time.Time) { s.observe("get_retention", start, err) }(time.Now())
+	return s.next.GetRetention(ctx, appID)
+}
+
+func (s *instrumentedStore) SetRetention(ctx context.Context, r *model.Retention) (err error) {
+	defer func(start time.Time) { s.observe("set_retention", start, err) }(time.Now())
+	return s.next.SetRetention(ctx, r)
+}
+
+func (s *instrumentedStore) SetLegalHold(ctx context.Context, appID uuid.UUID, hold bool) (err error) {
+	defer func(start time.Time) { s.observe("set_legal_hold", start, err) }(time.Now())
+	return s.next.SetLegalHold(ctx, appID, hold)
+}
+
+func (s *instrumentedStore) ListRetention(ctx context.Context) (rs []*model.Retention, err error) {
+	defer func(start time.Time) { s.observe("list_retention", start, err) }(time.Now())
+	return s.next.ListRetention(ctx)
+}
+
+func (s *instrumentedStore) PurgeSubmissions(ctx context.Context, r *model.Retention, now time.Time, limit int) (n int64, err error) {
+	defer func(start time.Time) { s.observe("purge_submissions", start, err) }(time.Now())
+	n, err = s.next.PurgeSubmissions(ctx, r, now, limit)
+	s.m.deleted.WithLabelValues("retention").Add(float64(n))
+	return n, err
+}
+
+func (s *instrumentedStore) DeleteSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (deleted []uuid.UUID, err error) {
+	defer func(start time.Time) { s.observe("delete_submissions", start, err) }(time.Now())
+	deleted, err = s.next.DeleteSubmissions(ctx, appID, ids)
+	s.m.deleted.WithLabelValues("owner").Add(float64(len(deleted)))
+	return deleted, err
+}
+
+func (s *instrumentedStore) DeleteSubmissionsBetween(ctx context.Context, appID uuid.UUID, from, to time.Time) (n int64, err error) {
+	defer func(start time.Time) { s.observe("delete_submissions_between", start, err) }(time.Now())
+	n, err = s.next.DeleteSubmissionsBetween(ctx, appID, from, to)
+	s.m.deleted.WithLabelValues("owner").Add(float64(n))
+	return n, err
+}
+
+func (s *instrumentedStore) DeleteSubmissionByToken(ctx context.Context, id uuid.UUID, tokenHash []byte) (appID uuid.UUID, err error) {
+	defer func(start time.Time) { s.observe("delete_submission_by_token", start, err) }(time.Now())
+	appID, err = s.next.DeleteSubmissionByToken(ctx, id, tokenHash)
+	if err == nil {
+		s.m.deleted.WithLabelValues("submitter").Inc()
+	}
+	return appID, err
+}
+
+func (s *instrumentedStore) DeleteApp(ctx context.Context, appID uuid.UUID) (e *model.AppErasure, err error) {
+	defer func(start time.Time) { s.observe("delete_app", start, err) }(time.Now())
+	e, err = s.next.DeleteApp(ctx, appID)
+	if e != nil {
+		s.m.deleted.WithLabelValues("owner").Add(float64(e.Submissions))
+	}
+	return e, err
+}
+
+func (s *instrumentedStore) InsertReply(ctx context.Context, r *model.Reply, max int) (err error) {
+	defer func(start time.Time) { s.observe("insert_reply", start, err) }(time.Now())
+	return s.next.InsertReply(ctx, r, max)
+}
+
+func (s *instrumentedStore) ListReplies(ctx context.Context, mailbox uuid.UUID, now time.Time) (rs []*model.Reply, err error) {
+	defer func(start time.Time) { s.observe("list_replies", start, err) }(time.Now())
+	return s.next.ListReplies(ctx, mailbox, now)
+}
+
+func (s *instrumentedStore) DeleteReplies(ctx context.Context, mailbox uuid.UUID) (n int64, err error) {
+	defer func(start time.Time) { s.observe("delete_replies", start, err) }(time.Now())
+	return s.next.DeleteReplies(ctx, mailbox)
+}
+
+func (s *instrumentedStore) PurgeReplies(ctx context.Context, now time.Time, limit int) (n int64, err error) {
+	defer func(start time.Time) { s.observe("purge_replies", start, err) }(time.Now())
+	return s.next.PurgeReplies(ctx, now, limit)
+}
+
+func (s *instrumentedStore) PurgeWebhookDeliveries(ctx context.Context, before time.Time, limit int) (n int64, err error) {
+	defer func(start time.Time) { s.observe("purge_webhook_deliveries", start, err) }(time.Now())
+	return s.next.PurgeWebhookDeliveries(ctx, before, limit)
+}
+
+func (s *instrumentedStore) CreateUploadToken(ctx context.Context, t *model.UploadToken) (err error) {
+	defer func(start time.Time) { s.observe("create_upload_token", start, err) }(time.Now())
+	return s.next.CreateUploadToken(ctx, t)
+}
+
+func (s *instrumentedStore) UploadTokenByHash(ctx context.Context, appID uuid.UUID, hash []byte) (t *model.UploadToken, err error) {
+	defer func(start time.Time) { s.observe("upload_token_by_hash", start, err) }(time.Now())
+	return s.next.UploadTokenByHash(ctx, appID, hash)
+}
+
+func (s *instrumentedStore) ListUploadTokens(ctx context.Context, appID uuid.UUID) (ts []*model.UploadToken, err error) {
+	defer func(start time.Time) { s.observe("list_upload_tokens", start, err) }(time.Now())
+	return s.next.ListUploadTokens(ctx, appID)
+}
+
+func (s *instrumentedStore) RevokeUploadToken(ctx context.Context, appID, id uuid.UUID, at time.Time) (err error) {
+	defer func(start time.Time) { s.observe("revoke_upload_token", start, err) }(time.Now())
+	return s.next.RevokeUploadToken(ctx, appID, id, at)
+}
+
+func (s *instrumentedStore) DeadDrop(ctx context.Context, appID uuid.UUID) (on bool, err error) {
+	defer func(start time.Time) { s.observe("dead_drop", start, err) }(time.Now())
+	return s.next.DeadDrop(ctx, appID)
+}
+
+func (s *instrumentedStore) SetDeadDrop(ctx context.Context, appID uuid.UUID, on bool) (err error) {
+	defer func(start time.Time) { s.observe("set_dead_drop", start, err) }(time.Now())
+	return s.next.SetDeadDrop(ctx, appID, on)
+}
+
+func (s *instrumentedStore) Padding(ctx context.Context, appID uuid.UUID) (pad model.Padding, err error) {
+	defer func(start time.Time) { s.observe("padding", start, err) }(time.Now())
+	return s.next.Padding(ctx, appID)
+}
+
+func (s *instrumentedStore) SetPadding(ctx context.Context, appID uuid.UUID, pad model.Padding) (err error) {
+	defer func(start time.Time) { s.observe("set_padding", start, err) }(time.Now())
+	return s.next.SetPadding(ctx, appID, pad)
+}
+
+func (s *instrumentedStore) SaveFormDef(ctx context.Context, d *model.FormDef) (err error) {
+	defer func(start time.Time) { s.observe("save_form_def", start, err) }(time.Now())
+	return s.next.SaveFormDef(ctx, d)
+}
+
+func (s *instrumentedStore) FormDef(ctx context.Context, appID uuid.UUID, version int) (d *model.FormDef, err error) {
+	defer func(start time.Time) { s.observe("form_def", start, err) }(time.Now())
+	return s.next.FormDef(ctx, appID, version)
+}
+
+func (s *instrumentedStore) PutAppForm(ctx context.Context, f *model.AppForm) (err error) {
+	defer func(start time.Time) { s.observe("put_app_form", start, err) }(time.Now())
+	return s.next.PutAppForm(ctx, f)
+}
+
+func (s *instrumentedStore) AppForm(ctx context.Context, appID uuid.UUID, id string) (f *model.AppForm, err error) {
+	defer func(start time.Time) { s.observe("app_form", start, err) }(time.Now())
+	return s.next.AppForm(ctx, appID, id)
+}
+
+func (s *instrumentedStore) ListAppForms(ctx context.Context, appID uuid.UUID) (fs []*model.AppForm, err error) {
+	defer func(start time.Time) { s.observe("list_app_forms", start, err) }(time.Now())
+	return s.next.ListAppForms(ctx, appID)
+}
+
+func (s *instrumentedStore) DeleteAppForm(ctx context.Context, appID uuid.UUID, id string) (err error) {
+	defer func(start time.Time) { s.observe("delete_app_form", start, err) }(time.Now())
+	return s.next.DeleteAppForm(ctx, appID, id)
+}
+
+func (s *instrumentedStore) AppExists(ctx context.Context, id uuid.UUID) (ok bool, err error) {
+	defer func(start time.Time) { s.observe("app_exists", start, err) }(time.Now())
+	return s.next.AppExists(ctx, id)
+}
+
+func (s *instrumentedStore) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub, ownerHash []byte) (err error) {
+	defer func(start time.Time) { s.observe("register_key", start, err) }(time.Now())
+	return s.next.RegisterKey(ctx, appID, kid, pub, ownerHash)
+}
+
+func (s *instrumentedStore) GetKey(ctx context.Context, appID uuid.UUID) (kid uint8, pub []byte, err error) {
+	defer func(start time.Time) { s.observe("get_key", start, err) }(time.Now())
+	return s.next.GetKey(ctx, appID)
+}
+
+func (s *instrumentedStore) OwnerTokenHash(ctx context.Context, appID uuid.UUID) (h []byte, err error) {
+	defer func(start time.Time) { s.observe("owner_token_hash", start, err) }(time.Now())
+	return s.next.OwnerTokenHash(ctx, appID)
+}
+
+func (s *instrumentedStore) SetOwnerTokenHash(ctx context.Context, appID uuid.UUID, hash []byte) (err error) {
+	defer func(start time.Time) { s.observe("set_owner_token_hash", start, err) }(time.Now())
+	return s.next.SetOwnerTokenHash(ctx, appID, hash)
+}
+
+func (s *instrumentedStore) CreateWebhook(ctx context.Context, w *model.Webhook, max int) (err error) {
+	defer func(start time.Time) { s.observe("create_webhook", start, err) }(time.Now())
+	return s.next.CreateWebhook(ctx, w, max)
+}
+
+func (s *instrumentedStore) ListWebhooks(ctx context.Context, appID uuid.UUID) (ws []*model.Webhook, err error) {
+	defer func(start time.Time) { s.observe("list_webhooks", start, err) }(time.Now())
+	return s.next.ListWebhooks(ctx, appID)
+}
+
+func (s *instrumentedStore) DeleteWebhook(ctx context.Context, appID, id uuid.UUID) (err error) {
+	defer func(start time.Time) { s.observe("delete_webhook", start, err) }(time.Now())
+	return s.next.DeleteWebhook(ctx, appID, id)
+}
+
+func (s *instrumentedStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (ds []*model.WebhookDelivery, err error) {
+	defer func(start time.Time) { s.observe("claim_webhook_deliveries", start, err) }(time.Now())
+	return s.next.ClaimWebhookDeliveries(ctx, limit, lease)
+}
+
+func (s *instrumentedStore) CompleteWebhookDelivery(ctx context.Context, id int64) (err error) {
+	defer func(start time.Time) { s.observe("complete_webhook_delivery", start, err) }(time.Now())
+	return s.next.CompleteWebhookDelivery(ctx, id)
+}
+
+func (s *instrumentedStore) FailWebhookDelivery(ctx context.Context, id int64, next time.Time, reason string) (err error) {
+	defer func(start time.Time) { s.observe("fail_webhook_delivery", start, err) }(time.Now())
+	return s.next.FailWebhookDelivery(ctx, id, next, reason)
+}
+
+// instrumentedNotifier keeps store.Notifier visible through the decorator.
+// The listener is long-lived, so it is not timed.
+type instrumentedNotifier struct {
+	*instrumentedStore
+	notifier store.Notifier
+}
+
+func (s *instrumentedNotifier) ListenSubmissions(ctx context.Context, fn func(appID uuid.UUID)) error {
+	return s.notifier.ListenSubmissions(ctx, fn)
+}
@@ -12,11 +12,192 @@ type Submission struct {
 	Kid   uint8
 	TS    time.Time
 	Blob  []byte
+	Burn  bool // delete as soon as the owner acknowledges it
+
+	DeleteTokenHash []byte     // SHA-256 of the submitter's deletion token; nil: none issued
+	UploadTokenID   *uuid.UUID // upload token the submission was made with; nil: none
+	FormID          string     // registered form it was made with; "": none
+
+	Pos FeedPos // place in the app's live feed; set when streamed from it
+}
+
+// FeedPos orders an app's live feed. It is assigned by the database, so
+// a submission committed late still sorts after every position already
+// handed out: (Xact, Seq) is the inserting transaction's ID and a
+// per-row sequence number.
+type FeedPos struct {
+	Xact int64
+	Seq  int64
+}
+
+// Less reports whether p sorts before q.
+func (p FeedPos) Less(q FeedPos) bool {
+	return p.Xact < q.Xact || p.Xact == q.Xact && p.Seq < q.Seq
 }
 
 type App struct {
-	ID         uuid.UUID
-	Name       string
-	CurrentKid uint8
-	PubKey     []byte
+	ID             uuid.UUID
+	Name           string
+	CurrentKid     uint8
+	PubKey         []byte
+	OwnerTokenHash []byte // SHA-256 of the owner bearer token
+}
+
+// Webhook is an owner's subscription to new-submission events.
+type Webhook struct {
+	ID          uuid.UUID
+	AppID       uuid.UUID
+	URL         string
+	Secret      []byte // HMAC key for the delivery signature
+	IncludeBlob bool   // attach the ciphertext to each event
+	FormID      string // only submissions of this form; "": all
+	CreatedAt   time.Time
+}
+
+// WebhookDelivery is one pending event in the outbox, claimed for sending.
+type WebhookDelivery struct {
+	ID         int64
+	Webhook    Webhook
+	Submission Submission // Blob is only set when Webhook.IncludeBlob
+	Event      string     // webhook event type, e.g. "submission.created"
+	Size       int        // ciphertext length in bytes
+	Attempts   int        // including the current one
+}
+
+// Retention is an app's data-retention policy; zero fields are off. The
+// retention janitor deletes whatever a policy no longer allows, except for
+// apps under legal hold.
+type Retention struct {
+	AppID       uuid.UUID
+	FormID      string        // a form's own limits (see AppForm); "": the whole app
+	MaxAge      time.Duration // delete submissions older than this
+	MaxCount    int           // keep only the newest MaxCount submissions
+	DeleteAcked bool          // delete submissions once the owner acknowledged them
+	LegalHold   bool          // set by the operator; suspends all deletion
+
+	// BurnAfterReading deletes every submission when the owner acknowledges
+	// it, in the ack itself rather than on the janitor's next pass.
+	BurnAfterReading bool
+}
+
+// Enforced reports whether the policy asks for any deletion.
+func (r *Retention) Enforced() bool {
+	return r.MaxAge > 0 || r.MaxCount > 0 || r.DeleteAcked
+}
+
+// AppErasure counts what erasing an app removed besides its key.
+type AppErasure struct {
+	Submissions     int64
+	Webhooks        int64
+	IdempotencyKeys int64
+}
+
+// Reply is an owner's sealed answer to a submitter, left in the mailbox
+// the submitter named inside its (encrypted) submission. The server never
+// learns which submission a mailbox belongs to.
+type Reply struct {
+	ID        uuid.UUID
+	AppID     uuid.UUID // the app that owns the mailbox
+	Mailbox   uuid.UUID
+	TS        time.Time
+	Blob      []byte // HPKE ciphertext to the submitter's reply key
+	ExpiresAt time.Time
+}
+
+// UploadToken is a one-time (or few-time) upload link an owner hands to a
+// specific person. Apps in dead-drop mode accept submissions only with one.
+type UploadToken struct {
+	ID        uuid.UUID
+	AppID     uuid.UUID
+	TokenHash []byte // SHA-256 of the token; the token itself is never stored
+	Label     string // owner's note, e.g. who the link was sent to
+	MaxUses   int
+	Uses      int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// Usable reports whether the token can still be redeemed at now.
+func (t *UploadToken) Usable(now time.Time) bool {
+	return t.RevokedAt == nil && t.Uses < t.MaxUses && now.Before(t.ExpiresAt)
+}
+
+// Form is the public definition of a hosted form. It is stored and served
+// as JSON, never holds submitted values, and is rendered at /f/{appID}.
+type Form struct {
+	FormID  string      `json:"formID,omitempty"` // registered form pushes are tagged with
+	Title   string      `json:"title"`
+	Intro   string      `json:"intro,omitempty"`   // shown above the fields
+	Submit  string      `json:"submit,omitempty"`  // button label
+	Success string      `json:"success,omitempty"` // shown after sending
+	Fields  []FormField `json:"fields"`
+}
+
+// FormField is one input. Type is one of FormFieldTypes.
+type FormField struct {
+	Name        string   `json:"name"`
+	Label       string   `json:"label"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required,omitempty"`
+	Placeholder string   `json:"placeholder,omitempty"`
+	Help        string   `json:"help,omitempty"`
+	Options     []string `json:"options,omitempty"` // select only
+	MaxLength   int      `json:"maxLength,omitempty"`
+}
+
+// FormFieldTypes lists the input types a hosted form may use.
+var FormFieldTypes = []string{"text", "textarea", "email", "tel", "url", "number", "date", "select", "checkbox"}
+
+// AppForm is one of the forms an app collects submissions from. Pushes
+// may name it; its limits come on top of the app's.
+type AppForm struct {
+	AppID     uuid.UUID
+	ID        string // e.g. "contact"; chosen by the owner
+	Label     string
+	MaxAge    time.Duration // retention for this form's submissions; 0: app policy only
+	MaxCount  int
+	RateMin   int // accepted pushes per minute, across all clients; 0: unlimited
+	RateBurst int
+	CreatedAt time.Time
+}
+
+// Retention returns the form's own retention limits as a policy.
+func (f *AppForm) Retention() *Retention {
+	return &Retention{AppID: f.AppID, FormID: f.ID, MaxAge: f.MaxAge, MaxCount: f.MaxCount}
+}
+
+// FormDef is one stored version of an app's form.
+type FormDef struct {
+	AppID     uuid.UUID
+	Version   int // 1, 2, …; every change gets the next one
+	Form      Form
+	CreatedAt time.Time
+}
+
+// Padding modes.
+const (
+	PaddingOff   = "off"
+	PaddingPow2  = "pow2"  // blobs are a power of two, Size or larger
+	PaddingFixed = "fixed" // every blob is exactly Size
+)
+
+// Padding is an app's ciphertext padding policy. Sizes count the whole
+// blob (encapsulated key, ciphertext and tag), so they do not depend on
+// the KEM; clients pad the plaintext until the sealed blob fits.
+type Padding struct {
+	Mode string `json:"mode"`
+	Size int    `json:"size,omitempty"` // smallest bucket (pow2) or the only one (fixed)
+}
+
+// Allows reports whether a blob of n bytes fits the policy.
+func (p Padding) Allows(n int) bool {
+	switch p.Mode {
+	case PaddingPow2:
+		return n >= p.Size && n&(n-1) == 0
+	case PaddingFixed:
+		return n == p.Size
+	default:
+		return true
+	}
 }
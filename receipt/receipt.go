@@ -0,0 +1,166 @@
+// Package receipt signs erasure receipts. A receipt is a compact JWS
+// (RFC 7515) over a JSON Erasure, signed with Ed25519 ("EdDSA"), so owners
+// can file it with their compliance records and anyone holding the public
+// key, published as a JWK set, can check it later with any JOSE library.
+package receipt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes of an erasure.
+const (
+	ScopeSubmissions = "submissions" // listed submission IDs
+	ScopeRange       = "range"       // every submission in [From, To)
+	ScopeApp         = "app"         // the whole app: key, submissions, webhooks, idempotency records
+)
+
+// Type is the JWS "typ" header of a receipt.
+const Type = "nb-erasure+jws"
+
+// Erasure is the signed statement: what was erased, for which app, when.
+type Erasure struct {
+	ID            uuid.UUID   `json:"id"` // of the receipt itself
+	AppID         uuid.UUID   `json:"appID"`
+	Scope         string      `json:"scope"`
+	SubmissionIDs []uuid.UUID `json:"submissionIDs,omitempty"` // ScopeSubmissions: those actually deleted
+	From          *time.Time  `json:"from,omitempty"`          // ScopeRange; nil: unbounded
+	To            *time.Time  `json:"to,omitempty"`
+	Submissions   int64       `json:"submissions"` // deleted ciphertexts
+	Webhooks      int64       `json:"webhooks,omitempty"`
+	IdemKeys      int64       `json:"idempotencyKeys,omitempty"`
+	ErasedAt      time.Time   `json:"erasedAt"`
+}
+
+// Signer holds the Ed25519 key receipts are signed with.
+type Signer struct {
+	key ed25519.PrivateKey
+	kid string
+}
+
+// New wraps key. The key ID is derived from the public key, so the same
+// key always has the same ID.
+func New(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, kid: KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// Generate returns a Signer with a fresh key. Receipts it signs cannot be
+// verified once the process is gone, so it only suits tests and demos.
+func Generate() *Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic("receipt: " + err.Error()) // crypto/rand does not fail
+	}
+	return New(key)
+}
+
+// LoadFile reads a PEM "PRIVATE KEY" (PKCS #8) Ed25519 key, as written by
+// `openssl genpkey -algorithm ed25519`.
+func LoadFile(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("receipt: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("receipt: no PEM private key in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("receipt: %w", err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("receipt: %s is not an Ed25519 key", path)
+	}
+	return New(ed), nil
+}
+
+// KeyID is the "kid" of pub: base64url of the first 12 bytes of its
+// SHA-256.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return b64.EncodeToString(sum[:12])
+}
+
+func (s *Signer) KeyID() string                { return s.kid }
+func (s *Signer) PublicKey() ed25519.PublicKey { return s.key.Public().(ed25519.PublicKey) }
+
+var b64 = base64.RawURLEncoding
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// Sign returns e as a compact JWS.
+func (s *Signer) Sign(e *Erasure) (string, error) {
+	h, err := json.Marshal(header{Alg: "EdDSA", Typ: Type, Kid: s.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(payload)
+	return input + "." + b64.EncodeToString(ed25519.Sign(s.key, []byte(input))), nil
+}
+
+var ErrBadReceipt = errors.New("receipt: malformed or not signed by this key")
+
+// Verify checks a compact JWS against pub and returns the erasure it
+// states.
+func Verify(pub ed25519.PublicKey, token string) (*Erasure, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrBadReceipt
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrBadReceipt
+	}
+	var h header
+	if raw, err := b64.DecodeString(parts[0]); err != nil || json.Unmarshal(raw, &h) != nil ||
+		h.Alg != "EdDSA" || h.Typ != Type {
+		return nil, ErrBadReceipt
+	}
+	raw, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrBadReceipt
+	}
+	var e Erasure
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, ErrBadReceipt
+	}
+	return &e, nil
+}
+
+// JWK is the public key in JSON Web Key form (RFC 8037).
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// JWK returns the signer's public key for publication.
+func (s *Signer) JWK() JWK {
+	return JWK{Kty: "OKP", Crv: "Ed25519", X: b64.EncodeToString(s.PublicKey()), Kid: s.kid, Alg: "EdDSA", Use: "sig"}
+}
@@ -0,0 +1,74 @@
+package receipt_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/collapsinghierarchy/noisybuffer/receipt"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := receipt.Generate()
+	e := &receipt.Erasure{
+		ID: uuid.New(), AppID: uuid.New(), Scope: receipt.ScopeApp,
+		Submissions: 3, Webhooks: 1, ErasedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	token, err := s.Sign(e)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	got, err := receipt.Verify(s.PublicKey(), token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.ID != e.ID || got.AppID != e.AppID || got.Submissions != 3 || !got.ErasedAt.Equal(e.ErasedAt) {
+		t.Errorf("round trip: %+v, want %+v", got, e)
+	}
+
+	// a different key, or any change to the token, must fail
+	if _, err := receipt.Verify(receipt.Generate().PublicKey(), token); !errors.Is(err, receipt.ErrBadReceipt) {
+		t.Errorf("foreign key: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	forged, _ := receipt.Generate().Sign(&receipt.Erasure{AppID: e.AppID, Submissions: 99})
+	tampered := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+	if _, err := receipt.Verify(s.PublicKey(), tampered); !errors.Is(err, receipt.ErrBadReceipt) {
+		t.Errorf("tampered payload: %v", err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "receipt.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := receipt.LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if !s.PublicKey().Equal(key.Public()) || s.KeyID() != receipt.KeyID(key.Public().(ed25519.PublicKey)) {
+		t.Error("loaded a different key")
+	}
+	if jwk := s.JWK(); jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Kid != s.KeyID() {
+		t.Errorf("JWK %+v", jwk)
+	}
+
+	if _, err := receipt.LoadFile(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
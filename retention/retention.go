@@ -0,0 +1,164 @@
+// Package retention enforces per-app retention policies. The Janitor
+// periodically walks every policy and deletes what it no longer allows, in
+// small batches so no statement holds row locks for long. Apps under legal
+// hold are skipped, and the store re-checks the hold on every batch. Each
+// pass also deletes owner replies whose time is up and webhook deliveries
+// parked for longer than ParkedTTL.
+package retention
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/audit"
+	"github.com/collapsinghierarchy/noisybuffer/logging"
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+// Janitor deletes expired submissions. The zero values of the exported
+// fields are replaced by defaults in NewJanitor.
+type Janitor struct {
+	Store    store.Store
+	Audit    *audit.Log
+	Interval time.Duration // between passes over all policies
+	Batch    int           // submissions deleted per statement
+	Pause    time.Duration // between batches, to let other writers in
+	// ParkedTTL is how long webhook deliveries that ran out of retries
+	// stay in the outbox.
+	ParkedTTL time.Duration
+	Logger    *slog.Logger
+}
+
+func NewJanitor(st store.Store) *Janitor {
+	return &Janitor{
+		Store:     st,
+		Interval:  time.Minute,
+		Batch:     500,
+		Pause:     50 * time.Millisecond,
+		ParkedTTL: 7 * 24 * time.Hour,
+		Logger:    slog.Default(),
+	}
+}
+
+// Run makes a pass every Interval until ctx is cancelled.
+func (j *Janitor) Run(ctx context.Context) {
+	t := time.NewTicker(j.Interval)
+	defer t.Stop()
+	for {
+		if _, err := j.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			j.Logger.ErrorContext(ctx, "retention: pass failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce applies every policy once and returns the number of deleted
+// submissions. A failing app is logged and does not stop the others.
+func (j *Janitor) RunOnce(ctx context.Context) (int64, error) {
+	if err := j.purgeReplies(ctx); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		j.Logger.ErrorContext(ctx, "retention: reply purge failed", "err", err)
+	}
+	if err := j.purgeParked(ctx); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		j.Logger.ErrorContext(ctx, "retention: webhook outbox purge failed", "err", err)
+	}
+	policies, err := j.Store.ListRetention(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, p := range policies {
+		if p.LegalHold {
+			continue
+		}
+		n, err := j.purge(ctx, p)
+		total += n
+		if n > 0 {
+			j.Logger.InfoContext(ctx, "retention: purged", "deleted", n, logging.KeyAppID, p.AppID, "formID", p.FormID)
+			j.record(ctx, p, n)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return total, ctx.Err()
+			}
+			j.Logger.ErrorContext(ctx, "retention: purge failed", "err", err)
+		}
+	}
+	return total, nil
+}
+
+// purge deletes batches for p until one comes back short.
+func (j *Janitor) purge(ctx context.Context, p *model.Retention) (int64, error) {
+	return j.batched(ctx, func() (int64, error) {
+		return j.Store.PurgeSubmissions(ctx, p, time.Now().UTC(), j.Batch)
+	})
+}
+
+// purgeReplies deletes expired owner replies. They are ciphertext nobody
+// but the submitter can read, so they are logged but not audited.
+func (j *Janitor) purgeReplies(ctx context.Context) error {
+	n, err := j.batched(ctx, func() (int64, error) {
+		return j.Store.PurgeReplies(ctx, time.Now().UTC(), j.Batch)
+	})
+	if n > 0 {
+		j.Logger.InfoContext(ctx, "retention: expired replies", "deleted", n)
+	}
+	return err
+}
+
+// purgeParked deletes webhook deliveries that gave up more than ParkedTTL
+// ago. They only hold metadata, so they are logged but not audited.
+func (j *Janitor) purgeParked(ctx context.Context) error {
+	n, err := j.batched(ctx, func() (int64, error) {
+		return j.Store.PurgeWebhookDeliveries(ctx, time.Now().UTC().Add(-j.ParkedTTL), j.Batch)
+	})
+	if n > 0 {
+		j.Logger.InfoContext(ctx, "retention: parked webhook deliveries", "deleted", n)
+	}
+	return err
+}
+
+// batched calls del until a batch comes back short, pausing in between.
+func (j *Janitor) batched(ctx context.Context, del func() (int64, error)) (int64, error) {
+	var total int64
+	for {
+		n, err := del()
+		total += n
+		if err != nil || n < int64(j.Batch) {
+			return total, err
+		}
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(j.Pause):
+		}
+	}
+}
+
+func (j *Janitor) record(ctx context.Context, p *model.Retention, n int64) {
+	e := audit.Event{
+		Action: audit.ActionPurge, Actor: audit.ActorJanitor, AppID: p.AppID, Count: n,
+		Detail: map[string]any{
+			"maxAgeSeconds": int64(p.MaxAge.Seconds()),
+			"maxCount":      p.MaxCount,
+			"deleteAcked":   p.DeleteAcked,
+		},
+	}
+	if p.FormID != "" {
+		e.Detail["formID"] = p.FormID
+	}
+	if err := j.Audit.Record(ctx, e); err != nil {
+		j.Logger.ErrorContext(ctx, "audit: write failed", "action", audit.ActionPurge, "err", err)
+	}
+}
@@ -0,0 +1,115 @@
+package retention_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/collapsinghierarchy/noisybuffer/audit"
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/retention"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+// fakeStore keeps a number of expired submissions per app and hands them
+// out in PurgeSubmissions batches.
+type fakeStore struct {
+	store.Store
+	mu       sync.Mutex
+	policies []*model.Retention
+	expired  map[uuid.UUID]int64
+	batches  []int64
+	replies  int64       // expired replies
+	parked   []time.Time // when webhook deliveries were parked
+}
+
+func (f *fakeStore) ListRetention(ctx context.Context) ([]*model.Retention, error) {
+	return f.policies, nil
+}
+
+func (f *fakeStore) PurgeSubmissions(ctx context.Context, r *model.Retention, now time.Time, limit int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := min(f.expired[r.AppID], int64(limit))
+	f.expired[r.AppID] -= n
+	f.batches = append(f.batches, n)
+	return n, nil
+}
+
+func (f *fakeStore) PurgeReplies(ctx context.Context, now time.Time, limit int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := min(f.replies, int64(limit))
+	f.replies -= n
+	return n, nil
+}
+
+func (f *fakeStore) PurgeWebhookDeliveries(ctx context.Context, before time.Time, limit int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	f.parked = slices.DeleteFunc(f.parked, func(at time.Time) bool {
+		if n < int64(limit) && at.Before(before) {
+			n++
+			return true
+		}
+		return false
+	})
+	return n, nil
+}
+
+func TestJanitor_BatchesAndLegalHold(t *testing.T) {
+	active, held := uuid.New(), uuid.New()
+	st := &fakeStore{
+		policies: []*model.Retention{
+			{AppID: active, MaxAge: time.Hour},
+			{AppID: held, MaxCount: 1, LegalHold: true},
+		},
+		expired: map[uuid.UUID]int64{active: 25, held: 40},
+		replies: 13,
+		parked:  []time.Time{time.Now().Add(-8 * 24 * time.Hour), time.Now().Add(-time.Hour)},
+	}
+	var trail bytes.Buffer
+	j := retention.NewJanitor(st)
+	j.Batch, j.Pause = 10, 0
+	j.Audit = audit.New(&trail)
+
+	n, err := j.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if n != 25 || st.expired[active] != 0 {
+		t.Fatalf("deleted %d, %d left", n, st.expired[active])
+	}
+	if want := []int64{10, 10, 5}; !slices.Equal(st.batches, want) {
+		t.Errorf("batches %v, want %v", st.batches, want)
+	}
+	if st.expired[held] != 40 {
+		t.Error("app under legal hold was purged")
+	}
+	if st.replies != 0 {
+		t.Errorf("%d expired replies left", st.replies)
+	}
+	if len(st.parked) != 1 {
+		t.Errorf("%d parked webhook deliveries left, want the one within ParkedTTL", len(st.parked))
+	}
+
+	var e audit.Event
+	if err := json.Unmarshal(trail.Bytes(), &e); err != nil {
+		t.Fatalf("audit trail %q: %v", trail.String(), err)
+	}
+	if e.Action != audit.ActionPurge || e.Actor != audit.ActorJanitor || e.AppID != active || e.Count != 25 {
+		t.Errorf("audit event %+v", e)
+	}
+
+	trail.Reset()
+	if n, _ := j.RunOnce(context.Background()); n != 0 || trail.Len() != 0 {
+		t.Errorf("idle pass deleted %d and audited %q", n, trail.String())
+	}
+}
@@ -0,0 +1,40 @@
+#!/bin/sh
+# vendor-hpke.sh — bundle the HPKE modules used by nb.js and the web UI into
+# cmd/noisybufferd/web/vendor/hpke.js and record its SRI hash in
+# vendor/integrity.json. noisybufferd refuses to start if the two disagree.
+#
+# Needs node, npm and network access. Review and commit the output; bump the
+# versions here (never in the JS) to upgrade.
+set -eu
+
+CORE_VERSION=1.7.2
+KYBER_VERSION=1.6.1
+ESBUILD_VERSION=0.24.2
+
+root=$(cd "$(dirname "$0")/.." && pwd)
+out="$root/cmd/noisybufferd/web/vendor"
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+cd "$work"
+npm init -y >/dev/null
+npm install --save-exact --ignore-scripts --no-audit --no-fund \
+	"@hpke/core@$CORE_VERSION" \
+	"@hpke/hybridkem-x25519-kyber768@$KYBER_VERSION" \
+	"esbuild@$ESBUILD_VERSION"
+
+cat >entry.js <<'JS'
+export { Aes128Gcm, CipherSuite, HkdfSha256 } from "@hpke/core";
+export { HybridkemX25519Kyber768 } from "@hpke/hybridkem-x25519-kyber768";
+JS
+npx esbuild entry.js --bundle --format=esm --minify --legal-comments=inline \
+	--outfile=hpke.js
+
+mkdir -p "$out"
+cp hpke.js "$out/hpke.js"
+# Tarball hashes of exactly what went into the bundle, for review.
+cp package-lock.json "$root/scripts/hpke-package-lock.json"
+
+sri="sha384-$(openssl dgst -sha384 -binary hpke.js | openssl base64 -A)"
+printf '{\n  "hpke.js": "%s"\n}\n' "$sri" >"$out/integrity.json"
+echo "vendored hpke.js $sri"
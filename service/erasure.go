@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/audit"
+	"github.com/collapsinghierarchy/noisybuffer/receipt"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrLegalHold          = errors.New("app is under legal hold")
+	ErrSubmissionNotFound = errors.New("submission not found")
+	ErrInvalidRange       = errors.New("time range needs from or to, and from before to")
+)
+
+// Receipts returns the signer of erasure receipts, whose public key
+// verifies them.
+func (s *Service) Receipts() *receipt.Signer { return s.receipts }
+
+// DeleteSubmission erases one submission on the owner's request.
+func (s *Service) DeleteSubmission(ctx context.Context, appID, id uuid.UUID) (e *receipt.Erasure, signed string, err error) {
+	ctx, span := tracer.Start(ctx, "service.DeleteSubmission")
+	defer func() { tracing.End(span, err) }()
+	deleted, err := s.Store.DeleteSubmissions(ctx, appID, []uuid.UUID{id})
+	if err != nil {
+		return nil, "", storeErr(err)
+	}
+	if len(deleted) == 0 {
+		return nil, "", ErrSubmissionNotFound
+	}
+	e = &receipt.Erasure{
+		AppID: appID, Scope: receipt.ScopeSubmissions,
+		SubmissionIDs: deleted, Submissions: int64(len(deleted)),
+	}
+	signed, err = s.issue(ctx, audit.ActorOwner, audit.ActionErase, e)
+	return e, signed, err
+}
+
+// DeleteRange erases the app's submissions with from <= ts < to. One bound
+// may be zero (open), not both, so a forgotten parameter cannot erase
+// everything.
+func (s *Service) DeleteRange(ctx context.Context, appID uuid.UUID, from, to time.Time) (e *receipt.Erasure, signed string, err error) {
+	ctx, span := tracer.Start(ctx, "service.DeleteRange")
+	defer func() { tracing.End(span, err) }()
+	if (from.IsZero() && to.IsZero()) || (!from.IsZero() && !to.IsZero() && !from.Before(to)) {
+		return nil, "", ErrInvalidRange
+	}
+	n, err := s.Store.DeleteSubmissionsBetween(ctx, appID, from, to)
+	if err != nil {
+		return nil, "", storeErr(err)
+	}
+	e = &receipt.Erasure{AppID: appID, Scope: receipt.ScopeRange, Submissions: n}
+	if !from.IsZero() {
+		e.From = &from
+	}
+	if !to.IsZero() {
+		e.To = &to
+	}
+	signed, err = s.issue(ctx, audit.ActorOwner, audit.ActionErase, e)
+	return e, signed, err
+}
+
+// WithdrawSubmission deletes a submission on its submitter's behalf, who
+// proves it with the deletion token from the push receipt. Unknown IDs and
+// wrong tokens are indistinguishable (ErrSubmissionNotFound).
+func (s *Service) WithdrawSubmission(ctx context.Context, id uuid.UUID, token string) (e *receipt.Erasure, signed string, err error) {
+	ctx, span := tracer.Start(ctx, "service.WithdrawSubmission")
+	defer func() { tracing.End(span, err) }()
+	if token == "" {
+		return nil, "", ErrSubmissionNotFound
+	}
+	appID, err := s.Store.DeleteSubmissionByToken(ctx, id, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrSubmissionNotFound
+	}
+	if err != nil {
+		return nil, "", storeErr(err)
+	}
+	e = &receipt.Erasure{
+		AppID: appID, Scope: receipt.ScopeSubmissions,
+		SubmissionIDs: []uuid.UUID{id}, Submissions: 1,
+	}
+	signed, err = s.issue(ctx, audit.ActorSubmitter, audit.ActionWithdraw, e)
+	return e, signed, err
+}
+
+// EraseApp deletes the app and everything stored for it: key, owner
+// token, submissions, idempotency records, webhooks and retention policy.
+// The owner token stops working with it.
+func (s *Service) EraseApp(ctx context.Context, appID uuid.UUID) (e *receipt.Erasure, signed string, err error) {
+	ctx, span := tracer.Start(ctx, "service.EraseApp")
+	defer func() { tracing.End(span, err) }()
+	counts, err := s.Store.DeleteApp(ctx, appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrAppNotFound
+	}
+	if err != nil {
+		return nil, "", storeErr(err)
+	}
+	s.formRates.drop(appID, "")
+	e = &receipt.Erasure{
+		AppID: appID, Scope: receipt.ScopeApp,
+		Submissions: counts.Submissions, Webhooks: counts.Webhooks, IdemKeys: counts.IdempotencyKeys,
+	}
+	signed, err = s.issue(ctx, audit.ActorOwner, audit.ActionEraseApp, e)
+	return e, signed, err
+}
+
+// issue stamps, signs and audits a receipt for an erasure that already
+// happened.
+func (s *Service) issue(ctx context.Context, actor, action string, e *receipt.Erasure) (string, error) {
+	e.ID = uuid.New()
+	e.ErasedAt = time.Now().UTC().Truncate(time.Microsecond)
+	s.record(ctx, audit.Event{
+		Action: action, Actor: actor, AppID: e.AppID, Count: e.Submissions,
+		Detail: map[string]any{"receipt": e.ID, "scope": e.Scope},
+	})
+	return s.receipts.Sign(e)
+}
+
+func storeErr(err error) error {
+	if errors.Is(err, store.ErrLegalHold) {
+		return ErrLegalHold
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in an app's submission feed. It is the
+// store-assigned model.FeedPos, not the submission's timestamp: those are
+// taken before the insert commits and can arrive out of order.
+type Cursor model.FeedPos
+
+// CursorOf returns the cursor pointing just at sub.
+func CursorOf(sub *model.Submission) Cursor { return Cursor(sub.Pos) }
+
+// String encodes the cursor as "<xact>_<seq>", which is what the SSE feed
+// uses as event ID.
+func (c Cursor) String() string {
+	return strconv.FormatInt(c.Xact, 10) + "_" + strconv.FormatInt(c.Seq, 10)
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	xact, seq, ok := strings.Cut(s, "_")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	x, err := strconv.ParseInt(xact, 10, 64)
+	if err != nil || x < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || n < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Xact: x, Seq: n}, nil
+}
+
+// FeedHead returns the cursor of a client connecting now: only
+// submissions committed from here on sort after it.
+func (s *Service) FeedHead(ctx context.Context) (c Cursor, err error) {
+	ctx, span := tracer.Start(ctx, "service.FeedHead")
+	defer func() { tracing.End(span, err) }()
+	pos, err := s.Store.FeedHead(ctx)
+	return Cursor(pos), err
+}
+
+// PullAfter streams the app's submissions that sort after the cursor.
+func (s *Service) PullAfter(ctx context.Context, appID uuid.UUID, after Cursor, fn func(*model.Submission) error) (err error) {
+	ctx, span := tracer.Start(ctx, "service.PullAfter")
+	defer func() { tracing.End(span, err) }()
+	return s.Store.StreamSubmissionsAfter(ctx, appID, model.FeedPos(after), fn)
+}
+
+// Subscribe returns a channel that receives a tick whenever new submissions
+// for appID may be available. Ticks coalesce; the caller should re-read
+// from its cursor on every tick. cancel must be called when done.
+func (s *Service) Subscribe(appID uuid.UUID) (ticks <-chan struct{}, cancel func()) {
+	return s.feed.Subscribe(appID)
+}
+
+// ListenSubmissions relays the store's cross-process insert notifications
+// into the feed until ctx is done. Stores without store.Notifier are fed
+// in-process by the push path instead, and this returns immediately.
+func (s *Service) ListenSubmissions(ctx context.Context) {
+	n, ok := s.Store.(store.Notifier)
+	if !ok {
+		return
+	}
+	for ctx.Err() == nil {
+		err := n.ListenSubmissions(ctx, s.feed.Publish)
+		if ctx.Err() != nil {
+			return
+		}
+		s.log.WarnContext(ctx, "feed: listen for submissions, retrying", "err", err)
+		// a dropped listener may have missed inserts: wake everyone up
+		s.feed.PublishAll()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// announce wakes local subscribers of appID, unless the store already
+// broadcasts inserts itself.
+func (s *Service) announce(appID uuid.UUID) {
+	if _, ok := s.Store.(store.Notifier); !ok {
+		s.feed.Publish(appID)
+	}
+}
+
+// Broadcaster fans out "something new for app X" ticks to subscribers in
+// this process.
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[chan struct{}]struct{}
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[uuid.UUID]map[chan struct{}]struct{})}
+}
+
+func (b *Broadcaster) Subscribe(appID uuid.UUID) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.subs[appID] == nil {
+		b.subs[appID] = make(map[chan struct{}]struct{})
+	}
+	b.subs[appID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs[appID], ch)
+			if len(b.subs[appID]) == 0 {
+				delete(b.subs, appID)
+			}
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Publish ticks every subscriber of appID without blocking.
+func (b *Broadcaster) Publish(appID uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[appID] {
+		tick(ch)
+	}
+}
+
+// PublishAll ticks every subscriber of every app.
+func (b *Broadcaster) PublishAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, chans := range b.subs {
+		for ch := range chans {
+			tick(ch)
+		}
+	}
+}
+
+func tick(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default: // a tick is already pending
+	}
+}
@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/collapsinghierarchy/noisybuffer/audit"
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrFormNotFound = errors.New("form not found")
+	ErrInvalidForm  = errors.New("invalid form definition")
+)
+
+// Hosted form limits. They keep a definition small enough to render and
+// to read back in full on every decrypt.
+const (
+	MaxFormFields  = 50
+	MaxFormOptions = 50
+	MaxFormLabel   = 200
+	MaxFormText    = 2000
+	MaxFormInput   = 64 * 1024 // largest MaxLength a field may ask for
+)
+
+// Field names become the keys of the sealed JSON; names starting with
+// "_nb" are reserved for nb.js.
+var formFieldName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+
+// SetForm validates f and stores it as the next version of appID's form.
+// Earlier versions stay readable so old submissions can still be matched.
+// f.FormID, if set, must name a registered form.
+func (s *Service) SetForm(ctx context.Context, appID uuid.UUID, f model.Form) (d *model.FormDef, err error) {
+	ctx, span := tracer.Start(ctx, "service.SetForm")
+	defer func() { tracing.End(span, err) }()
+	if err := validateForm(&f); err != nil {
+		return nil, err
+	}
+	if f.FormID != "" {
+		_, err := s.Store.AppForm(ctx, appID, f.FormID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUnknownForm
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	d = &model.FormDef{AppID: appID, Form: f, CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+	err = s.Store.SaveFormDef(ctx, d)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAppNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Form returns the given version of appID's form, or the newest for 0.
+func (s *Service) Form(ctx context.Context, appID uuid.UUID, version int) (d *model.FormDef, err error) {
+	ctx, span := tracer.Start(ctx, "service.Form")
+	defer func() { tracing.End(span, err) }()
+	if version < 0 {
+		return nil, ErrFormNotFound
+	}
+	d, err = s.Store.FormDef(ctx, appID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFormNotFound
+	}
+	return d, err
+}
+
+func validateForm(f *model.Form) error {
+	bad := func(format string, args ...any) error {
+		return fmt.Errorf("%w: "+format, append([]any{ErrInvalidForm}, args...)...)
+	}
+	f.Title = strings.TrimSpace(f.Title)
+	switch {
+	case f.Title == "" || len(f.Title) > MaxFormLabel:
+		return bad("title needs 1 to 200 bytes")
+	case len(f.Submit) > MaxFormLabel:
+		return bad("submit label is longer than 200 bytes")
+	case len(f.Intro) > MaxFormText || len(f.Success) > MaxFormText:
+		return bad("intro and success texts are limited to 2000 bytes")
+	case len(f.Fields) == 0 || len(f.Fields) > MaxFormFields:
+		return bad("form needs 1 to 50 fields")
+	}
+	seen := make(map[string]bool, len(f.Fields))
+	for i := range f.Fields {
+		fd := &f.Fields[i]
+		switch {
+		case !formFieldName.MatchString(fd.Name) || strings.HasPrefix(fd.Name, "_nb"):
+			return bad("field name %q must start with a letter and use at most 64 letters, digits, - or _", fd.Name)
+		case seen[fd.Name]:
+			return bad("duplicate field name %q", fd.Name)
+		case !slices.Contains(model.FormFieldTypes, fd.Type):
+			return bad("field %s has unknown type %q", fd.Name, fd.Type)
+		case fd.Label == "" || len(fd.Label) > MaxFormLabel:
+			return bad("field %s needs a label of 1 to 200 bytes", fd.Name)
+		case len(fd.Placeholder) > MaxFormLabel || len(fd.Help) > MaxFormText:
+			return bad("field %s has an overlong placeholder or help text", fd.Name)
+		case fd.MaxLength < 0 || fd.MaxLength > MaxFormInput:
+			return bad("field %s has maxLength outside 0 to 65536", fd.Name)
+		case fd.Type == "select" && (len(fd.Options) == 0 || len(fd.Options) > MaxFormOptions):
+			return bad("select field %s needs 1 to 50 options", fd.Name)
+		case fd.Type != "select" && len(fd.Options) > 0:
+			return bad("only select fields take options")
+		}
+		for _, o := range fd.Options {
+			if o == "" || len(o) > MaxFormLabel {
+				return bad("options of %s need 1 to 200 bytes each", fd.Name)
+			}
+		}
+		seen[fd.Name] = true
+	}
+	return nil
+}
+
+// -------- form IDs ----------------------------------------------------------
+
+var (
+	ErrInvalidAppForm  = errors.New("form ID must be 1 to 64 letters, digits, - or _; limits must not be negative")
+	ErrUnknownForm     = errors.New("form is not registered for this app")
+	ErrTooManyForms    = errors.New("too many forms for this app")
+	ErrFormRateLimited = errors.New("form rate limit exceeded")
+)
+
+// MaxAppForms caps the forms registered per app.
+const MaxAppForms = 50
+
+var formIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidFormID reports whether id can name a form.
+func ValidFormID(id string) bool { return formIDPattern.MatchString(id) }
+
+// WithFormID tags the push with one of the app's registered forms. Pushes
+// naming an unregistered form are refused with ErrUnknownForm.
+func WithFormID(id string) PushOption {
+	return func(c *pushConfig) { c.formID = id }
+}
+
+// PutAppForm registers (or updates) a form of appID. Its retention limits
+// apply on top of the app's and are enforced by the janitor; a zero
+// RateBurst allows a minute's worth of pushes at once.
+func (s *Service) PutAppForm(ctx context.Context, f *model.AppForm) (err error) {
+	ctx, span := tracer.Start(ctx, "service.PutAppForm")
+	defer func() { tracing.End(span, err) }()
+	if !ValidFormID(f.ID) || len(f.Label) > MaxFormLabel ||
+		f.MaxAge < 0 || f.MaxCount < 0 || f.RateMin < 0 || f.RateBurst < 0 {
+		return ErrInvalidAppForm
+	}
+	forms, err := s.Store.ListAppForms(ctx, f.AppID)
+	if err != nil {
+		return err
+	}
+	if len(forms) >= MaxAppForms && !slices.ContainsFunc(forms, func(o *model.AppForm) bool { return o.ID == f.ID }) {
+		return ErrTooManyForms
+	}
+	f.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	err = s.Store.PutAppForm(ctx, f)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAppNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.record(ctx, audit.Event{
+		Action: audit.ActionFormSet, Actor: audit.ActorOwner, AppID: f.AppID,
+		Detail: map[string]any{
+			"formID":        f.ID,
+			"maxAgeSeconds": int64(f.MaxAge.Seconds()),
+			"maxCount":      f.MaxCount,
+			"ratePerMinute": f.RateMin,
+			"rateBurst":     f.RateBurst,
+		},
+	})
+	return nil
+}
+
+func (s *Service) AppForm(ctx context.Context, appID uuid.UUID, id string) (f *model.AppForm, err error) {
+	ctx, span := tracer.Start(ctx, "service.AppForm")
+	defer func() { tracing.End(span, err) }()
+	f, err = s.Store.AppForm(ctx, appID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFormNotFound
+	}
+	return f, err
+}
+
+func (s *Service) ListAppForms(ctx context.Context, appID uuid.UUID) (fs []*model.AppForm, err error) {
+	ctx, span := tracer.Start(ctx, "service.ListAppForms")
+	defer func() { tracing.End(span, err) }()
+	return s.Store.ListAppForms(ctx, appID)
+}
+
+// DeleteAppForm unregisters the form and removes its webhooks. Its
+// submissions stay, still tagged, under the app's retention policy.
+func (s *Service) DeleteAppForm(ctx context.Context, appID uuid.UUID, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "service.DeleteAppForm")
+	defer func() { tracing.End(span, err) }()
+	err = s.Store.DeleteAppForm(ctx, appID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrFormNotFound
+	}
+	if err == nil {
+		s.formRates.drop(appID, id)
+	}
+	return err
+}
+
+// checkForm makes sure formID is registered for appID and takes a token
+// from its rate limit. The empty form ID always passes. If the push ends
+// up storing nothing (a replay, a failed insert), release must be called
+// to hand the token back.
+func (s *Service) checkForm(ctx context.Context, appID uuid.UUID, formID string) (release func(), err error) {
+	release = func() {}
+	if formID == "" {
+		return release, nil
+	}
+	if !ValidFormID(formID) {
+		return release, ErrUnknownForm
+	}
+	f, err := s.Store.AppForm(ctx, appID, formID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return release, ErrUnknownForm
+	}
+	if err != nil {
+		return release, err
+	}
+	now := time.Now()
+	r, ok := s.formRates.reserve(f, now)
+	if !ok {
+		return release, ErrFormRateLimited
+	}
+	if r != nil {
+		// Cancel at the reservation's own time: a reservation that acts
+		// immediately is past by now, and Cancel would restore nothing.
+		release = func() { r.CancelAt(now) }
+	}
+	return release, nil
+}
+
+// formIdle is how long a form's bucket survives without pushes.
+const formIdle = 10 * time.Minute
+
+// formRates keeps one token bucket per registered form. Buckets live in
+// this process only, so with several replicas each enforces the limit on
+// its own share of the traffic.
+type formRates struct {
+	mu      sync.Mutex
+	buckets map[formKey]*formBucket
+	swept   time.Time
+}
+
+type formKey struct {
+	app  uuid.UUID
+	form string
+}
+
+type formBucket struct {
+	perMin, burst int
+	lim           *rate.Limiter
+	seen          time.Time
+}
+
+// reserve takes a token from f's bucket; r is nil for forms without a
+// limit.
+func (fr *formRates) reserve(f *model.AppForm, now time.Time) (r *rate.Reservation, ok bool) {
+	if f.RateMin <= 0 {
+		return nil, true
+	}
+	burst := f.RateBurst
+	if burst == 0 {
+		burst = f.RateMin
+	}
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.buckets == nil {
+		fr.buckets = make(map[formKey]*formBucket)
+	}
+	if now.Sub(fr.swept) > formIdle {
+		for k, b := range fr.buckets {
+			if now.Sub(b.seen) > formIdle {
+				delete(fr.buckets, k)
+			}
+		}
+		fr.swept = now
+	}
+	k := formKey{f.AppID, f.ID}
+	b, ok := fr.buckets[k]
+	if !ok || b.perMin != f.RateMin || b.burst != burst {
+		b = &formBucket{perMin: f.RateMin, burst: burst,
+			lim: rate.NewLimiter(rate.Limit(float64(f.RateMin)/60), burst)}
+		fr.buckets[k] = b
+	}
+	b.seen = now
+	r = b.lim.ReserveN(now, 1)
+	if d := r.DelayFrom(now); d > 0 || !r.OK() {
+		r.CancelAt(now)
+		return nil, false
+	}
+	return r, true
+}
+
+// drop forgets the bucket of form id of appID, or with an empty id those
+// of all its forms, so a form registered again starts afresh.
+func (fr *formRates) drop(appID uuid.UUID, id string) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	for k := range fr.buckets {
+		if k.app == appID && (id == "" || k.form == id) {
+			delete(fr.buckets, k)
+		}
+	}
+}
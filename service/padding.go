@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidPadding  = errors.New("invalid padding policy")
+	ErrPaddingMismatch = errors.New("blob length does not match the app's padding policy")
+)
+
+// DefaultPaddingSize is the smallest pow2 bucket when the owner names
+// none: room for a hybrid KEM's encapsulated key and a short form.
+const DefaultPaddingSize = 4 << 10
+
+// Padding returns appID's padding policy; apps that never set one are off.
+func (s *Service) Padding(ctx context.Context, appID uuid.UUID) (p model.Padding, err error) {
+	ctx, span := tracer.Start(ctx, "service.Padding")
+	defer func() { tracing.End(span, err) }()
+	p, err = s.Store.Padding(ctx, appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrAppNotFound
+	}
+	return p, err
+}
+
+// SetPadding replaces appID's padding policy. It applies to the next push;
+// stored submissions keep their size.
+func (s *Service) SetPadding(ctx context.Context, appID uuid.UUID, p model.Padding) (_ model.Padding, err error) {
+	ctx, span := tracer.Start(ctx, "service.SetPadding")
+	defer func() { tracing.End(span, err) }()
+	if p, err = s.validPadding(p); err != nil {
+		return p, err
+	}
+	err = s.Store.SetPadding(ctx, appID, p)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrAppNotFound
+	}
+	return p, err
+}
+
+// validPadding checks p and fills in its defaults.
+func (s *Service) validPadding(p model.Padding) (model.Padding, error) {
+	maxBlob := s.MaxBlob()
+	switch p.Mode {
+	case "", model.PaddingOff:
+		return model.Padding{Mode: model.PaddingOff}, nil
+	case model.PaddingPow2:
+		if p.Size == 0 {
+			p.Size = DefaultPaddingSize
+		}
+		if p.Size < 0 || p.Size&(p.Size-1) != 0 {
+			return p, fmt.Errorf("%w: pow2 size must be a power of two", ErrInvalidPadding)
+		}
+	case model.PaddingFixed:
+		if p.Size <= 0 {
+			return p, fmt.Errorf("%w: fixed padding needs a size", ErrInvalidPadding)
+		}
+	default:
+		return p, fmt.Errorf("%w: unknown mode %q", ErrInvalidPadding, p.Mode)
+	}
+	if int64(p.Size) > maxBlob {
+		return p, fmt.Errorf("%w: size %d exceeds the %d byte blob limit", ErrInvalidPadding, p.Size, maxBlob)
+	}
+	return p, nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrReplyTooLarge = errors.New("reply too large")
+	ErrEmptyReply    = errors.New("empty reply")
+	ErrMailboxTaken  = errors.New("mailbox belongs to another app")
+	ErrMailboxFull   = errors.New("mailbox is full")
+)
+
+// Reply defaults for settings the config leaves unset.
+const (
+	DefaultMaxReply       = 16 * 1024
+	DefaultReplyTTL       = 30 * 24 * time.Hour
+	DefaultMailboxReplies = 20
+)
+
+// MaxReply reports the upper bound for a sealed reply in bytes.
+func (s *Service) MaxReply() int64 {
+	if n := s.cfg.Load().MaxReplyBytes; n > 0 {
+		return n
+	}
+	return DefaultMaxReply
+}
+
+func (s *Service) replyTTL() time.Duration {
+	if ttl := s.cfg.Load().ReplyTTL; ttl > 0 {
+		return ttl
+	}
+	return DefaultReplyTTL
+}
+
+func (s *Service) mailboxReplies() int {
+	if n := s.cfg.Load().MailboxReplies; n > 0 {
+		return n
+	}
+	return DefaultMailboxReplies
+}
+
+// Reply leaves the owner's sealed answer in mailbox. The caller must have
+// authorised the owner of appID; the first app to reply to a mailbox keeps
+// it until its replies expire.
+func (s *Service) Reply(ctx context.Context, appID, mailbox uuid.UUID, blob []byte) (r *model.Reply, err error) {
+	ctx, span := tracer.Start(ctx, "service.Reply")
+	defer func() { tracing.End(span, err) }()
+	switch {
+	case len(blob) == 0:
+		return nil, ErrEmptyReply
+	case int64(len(blob)) > s.MaxReply():
+		return nil, ErrReplyTooLarge
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	r = &model.Reply{
+		ID:        uuid.New(),
+		AppID:     appID,
+		Mailbox:   mailbox,
+		TS:        now,
+		Blob:      blob,
+		ExpiresAt: now.Add(s.replyTTL()),
+	}
+	switch err := s.Store.InsertReply(ctx, r, s.mailboxReplies()); {
+	case errors.Is(err, store.ErrMailboxTaken):
+		return nil, ErrMailboxTaken
+	case errors.Is(err, store.ErrMailboxFull):
+		return nil, ErrMailboxFull
+	case err != nil:
+		return nil, err
+	}
+	return r, nil
+}
+
+// Replies returns the unexpired replies in mailbox, oldest first. Knowing
+// the mailbox ID is all it takes, and all it reveals is ciphertext.
+func (s *Service) Replies(ctx context.Context, mailbox uuid.UUID) (rs []*model.Reply, err error) {
+	ctx, span := tracer.Start(ctx, "service.Replies")
+	defer func() { tracing.End(span, err) }()
+	return s.Store.ListReplies(ctx, mailbox, time.Now().UTC())
+}
+
+// DeleteReplies empties mailbox, for submitters done reading.
+func (s *Service) DeleteReplies(ctx context.Context, mailbox uuid.UUID) (n int64, err error) {
+	ctx, span := tracer.Start(ctx, "service.DeleteReplies")
+	defer func() { tracing.End(span, err) }()
+	return s.Store.DeleteReplies(ctx, mailbox)
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/collapsinghierarchy/noisybuffer/audit"
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRetention = errors.New("retention limits must not be negative")
+	ErrTooManyIDs       = errors.New("too many submission ids")
+)
+
+// MaxAckIDs caps the submission IDs accepted by one Ack call.
+const MaxAckIDs = 1000
+
+// GetRetention returns the app's policy, zero if it never set one.
+func (s *Service) GetRetention(ctx context.Context, appID uuid.UUID) (r *model.Retention, err error) {
+	ctx, span := tracer.Start(ctx, "service.GetRetention")
+	defer func() { tracing.End(span, err) }()
+	r, err = s.Store.GetRetention(ctx, appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAppNotFound
+	}
+	return r, err
+}
+
+// SetRetention replaces the owner's part of the policy; r.LegalHold is
+// ignored. The janitor applies it on its next pass.
+func (s *Service) SetRetention(ctx context.Context, r *model.Retention) (err error) {
+	ctx, span := tracer.Start(ctx, "service.SetRetention")
+	defer func() { tracing.End(span, err) }()
+	if r.MaxAge < 0 || r.MaxCount < 0 {
+		return ErrInvalidRetention
+	}
+	if err := s.Store.SetRetention(ctx, r); err != nil {
+		return err
+	}
+	s.record(ctx, audit.Event{
+		Action: audit.ActionRetentionSet, Actor: audit.ActorOwner, AppID: r.AppID,
+		Detail: map[string]any{
+			"maxAgeSeconds":    int64(r.MaxAge.Seconds()),
+			"maxCount":         r.MaxCount,
+			"deleteAcked":      r.DeleteAcked,
+			"burnAfterReading": r.BurnAfterReading,
+		},
+	})
+	return nil
+}
+
+// SetLegalHold suspends (or resumes) every deletion for the app. It is an
+// operator action, not an owner one.
+func (s *Service) SetLegalHold(ctx context.Context, appID uuid.UUID, hold bool) (err error) {
+	ctx, span := tracer.Start(ctx, "service.SetLegalHold")
+	defer func() { tracing.End(span, err) }()
+	err = s.Store.SetLegalHold(ctx, appID, hold)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAppNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.record(ctx, audit.Event{
+		Action: audit.ActionLegalHold, Actor: audit.ActorOperator, AppID: appID,
+		Detail: map[string]any{"hold": hold},
+	})
+	return nil
+}
+
+// Ack marks submissions the owner has pulled and decrypted, and deletes
+// those meant to be burnt after reading. Nothing is deleted before this
+// call, so a pull that broke off midway loses nothing: the owner acks only
+// the IDs it actually decrypted. Unknown or already acknowledged IDs are
+// skipped; acked counts the newly acknowledged, burned the deleted.
+func (s *Service) Ack(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (acked, burned int64, err error) {
+	ctx, span := tracer.Start(ctx, "service.Ack")
+	defer func() { tracing.End(span, err) }()
+	if len(ids) > MaxAckIDs {
+		return 0, 0, ErrTooManyIDs
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	acked, burned, err = s.Store.AckSubmissions(ctx, appID, ids)
+	if err != nil {
+		return 0, 0, err
+	}
+	if acked > 0 {
+		s.record(ctx, audit.Event{Action: audit.ActionAck, Actor: audit.ActorOwner, AppID: appID, Count: acked})
+	}
+	if burned > 0 {
+		s.record(ctx, audit.Event{Action: audit.ActionBurn, Actor: audit.ActorOwner, AppID: appID, Count: burned})
+	}
+	return acked, burned, nil
+}
+
+// record writes an audit event. A failing audit sink is logged, not
+// returned: the data change has already happened.
+func (s *Service) record(ctx context.Context, e audit.Event) {
+	if err := s.audit.Record(ctx, e); err != nil {
+		s.log.ErrorContext(ctx, "audit: write failed", "action", e.Action, "err", err)
+	}
+}
@@ -2,68 +2,559 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"io"
+	"log/slog"
 	"time"
 
+	"github.com/collapsinghierarchy/noisybuffer/audit"
+	"github.com/collapsinghierarchy/noisybuffer/config"
 	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/receipt"
 	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
 )
 
 var (
-	ErrAppNotFound = errors.New("app not found")
+	ErrAppNotFound   = errors.New("app not found")
+	ErrBlobTooLarge  = errors.New("blob too large")
+	ErrBatchTooLarge = errors.New("batch too large")
+
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	// ErrReplayGone answers a retry whose original submission was already
+	// deleted (burnt, withdrawn, erased or expired): it is not stored again.
+	ErrReplayGone = errors.New("submission for this idempotency key was deleted")
 )
 
+// tracer opens a span per Service call; see package tracing.
+var tracer = otel.Tracer("github.com/collapsinghierarchy/noisybuffer/service")
+
+// MaxIdempotencyKey is the longest accepted Idempotency-Key.
+const MaxIdempotencyKey = 255
+
+// DefaultIdempotencyTTL is how long an idempotency key keeps pointing at its
+// submission when the config leaves IdempotencyTTL unset.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// MaxBatch caps the number of items accepted by a single PushBatch call.
+const MaxBatch = 100
+
+// DefaultMaxBatchBytes caps a whole PushBatch request body when the config
+// leaves MaxBatchBytes unset.
+const DefaultMaxBatchBytes = 8 << 20
+
 type Service struct {
-	Store   store.Store // dependency-injected DAL interface
-	maxBlob int64       // configurable size guard
-	kemPub  []byte
-	kid     uint8
+	Store  store.Store  // dependency-injected DAL interface
+	cfg    *config.Live // size guard, replay window, allowed KEMs
+	kemPub []byte
+	kid    uint8
+
+	feed      *Broadcaster // wakes live feed subscribers on new submissions
+	formRates formRates    // per-form push limits
+	log       *slog.Logger
+	audit     *audit.Log      // deletions and retention changes; nil: not recorded
+	receipts  *receipt.Signer // signs erasure receipts
+}
+
+// Option tweaks a Service at construction time.
+type Option func(*Service)
+
+// WithLogger sets the logger for background work such as the feed relay.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Service) { s.log = l }
+}
+
+// WithAudit records deletions and retention changes in a.
+func WithAudit(a *audit.Log) Option {
+	return func(s *Service) { s.audit = a }
 }
 
-func New(st store.Store, maxBlob int64) *Service {
-	return &Service{Store: st, maxBlob: maxBlob}
+// WithReceipts signs erasure receipts with r. Without it every Service
+// makes up a key of its own, which nobody can verify receipts against once
+// the process is gone.
+func WithReceipts(r *receipt.Signer) Option {
+	return func(s *Service) { s.receipts = r }
+}
+
+// New builds a Service that reads its settings from cfg on every call, so
+// reloaded values apply to the next request.
+func New(st store.Store, cfg *config.Live, opts ...Option) *Service {
+	s := &Service{
+		Store: st,
+		cfg:   cfg,
+		feed:  NewBroadcaster(),
+		log:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.receipts == nil {
+		s.receipts = receipt.Generate()
+	}
+	return s
 }
 
 var (
-	ErrKeyNotFound = errors.New("public key not registered")
-	ErrKeyExists   = errors.New("public key already registered")
+	ErrKeyNotFound   = errors.New("public key not registered")
+	ErrKeyExists     = errors.New("public key already registered")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrKEMNotAllowed = errors.New("public key is not for an allowed KEM")
 )
 
-func (s *Service) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub []byte) error {
-	return s.Store.RegisterKey(ctx, appID, kid, pub)
+// RegisterKey stores the app's public key and issues a fresh owner token.
+// The token is returned exactly once; only its hash is kept.
+func (s *Service) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub []byte) (token string, err error) {
+	ctx, span := tracer.Start(ctx, "service.RegisterKey")
+	defer func() { tracing.End(span, err) }()
+	if !kemAllowed(s.cfg.Load().AllowedKEMs, pub) {
+		return "", ErrKEMNotAllowed
+	}
+	token, err = randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = s.Store.RegisterKey(ctx, appID, kid, pub, hashToken(token))
+	if errors.Is(err, store.ErrAppExists) {
+		return "", ErrKeyExists
+	} else if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// AuthorizeOwner checks token against the owner token issued for appID.
+func (s *Service) AuthorizeOwner(ctx context.Context, appID uuid.UUID, token string) (err error) {
+	ctx, span := tracer.Start(ctx, "service.AuthorizeOwner")
+	defer func() { tracing.End(span, err) }()
+	want, err := s.Store.OwnerTokenHash(ctx, appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnauthorized
+	}
+	if err != nil {
+		return err
+	}
+	if token == "" || len(want) == 0 ||
+		subtle.ConstantTimeCompare(hashToken(token), want) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// ResetOwnerToken issues a new owner token for appID, replacing any
+// earlier one. It is an operator action: the way back in for apps
+// registered before owner tokens existed, or whose token was lost.
+func (s *Service) ResetOwnerToken(ctx context.Context, appID uuid.UUID) (token string, err error) {
+	ctx, span := tracer.Start(ctx, "service.ResetOwnerToken")
+	defer func() { tracing.End(span, err) }()
+	if token, err = randomToken(); err != nil {
+		return "", err
+	}
+	err = s.Store.SetOwnerTokenHash(ctx, appID, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrAppNotFound
+	} else if err != nil {
+		return "", err
+	}
+	s.record(ctx, audit.Event{Action: audit.ActionOwnerToken, Actor: audit.ActorOperator, AppID: appID})
+	return token, nil
+}
+
+// kemAllowed recognises the KEM of pub by its size. An empty allow-list
+// accepts any key.
+func kemAllowed(allowed []string, pub []byte) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, name := range allowed {
+		if config.KEMs[name] == len(pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
 }
 
-func (s *Service) GetKey(ctx context.Context, appID uuid.UUID) (uint8, []byte, error) {
-	kid, pub, err := s.Store.GetKey(ctx, appID)
+func (s *Service) GetKey(ctx context.Context, appID uuid.UUID) (kid uint8, pub []byte, err error) {
+	ctx, span := tracer.Start(ctx, "service.GetKey")
+	defer func() { tracing.End(span, err) }()
+	kid, pub, err = s.Store.GetKey(ctx, appID)
 	if errors.Is(err, sql.ErrNoRows) { // adapter returns sql.ErrNoRows
 		return 0, nil, ErrKeyNotFound
 	}
 	return kid, pub, err
 }
 
-func (s *Service) Push(ctx context.Context, appID uuid.UUID, kid uint8, blob []byte) error {
-	if int64(len(blob)) > s.maxBlob {
-		return errors.New("blob too large")
+// MaxBlob reports the upper bound for a single ciphertext in bytes.
+func (s *Service) MaxBlob() int64 { return s.cfg.Load().MaxBlobBytes }
+
+// MaxBatchBytes reports the upper bound for a whole batch request in bytes.
+// It does not grow with MaxBlob: a large blob limit must not let one
+// request make the server buffer MaxBatch of them.
+func (s *Service) MaxBatchBytes() int64 {
+	if n := s.cfg.Load().MaxBatchBytes; n > 0 {
+		return n
+	}
+	return DefaultMaxBatchBytes
+}
+
+// idemTTL is the replay window for idempotency keys.
+func (s *Service) idemTTL() time.Duration {
+	if ttl := s.cfg.Load().IdempotencyTTL; ttl != 0 {
+		return ttl
 	}
-	exists, err := s.Store.AppExists(ctx, appID)
+	return DefaultIdempotencyTTL
+}
+
+// Receipt acknowledges a stored submission.
+type Receipt struct {
+	ID       uuid.UUID
+	TS       time.Time
+	Replayed bool // an earlier push with the same idempotency key was returned
+
+	// DeletionToken lets the submitter withdraw the submission (see
+	// WithdrawSubmission). Only set when asked for with WithDeletionToken,
+	// and never on a replay: the token is not kept.
+	DeletionToken string
+}
+
+// PushOption carries optional per-push settings.
+type PushOption func(*pushConfig)
+
+type pushConfig struct {
+	idemKey     string
+	burn        bool
+	withToken   bool
+	uploadToken string
+	formID      string
+}
+
+// WithIdempotencyKey makes the push replay-safe: a retry carrying the same
+// key for the same app returns the original receipt instead of inserting
+// a duplicate.
+func WithIdempotencyKey(key string) PushOption {
+	return func(c *pushConfig) { c.idemKey = key }
+}
+
+// WithDeletionToken asks for a deletion token in the receipt.
+func WithDeletionToken(want bool) PushOption {
+	return func(c *pushConfig) { c.withToken = want }
+}
+
+// WithBurnAfterReading asks for the submission to be deleted as soon as
+// the owner acknowledges it, whatever the app's retention policy says.
+func WithBurnAfterReading(burn bool) PushOption {
+	return func(c *pushConfig) { c.burn = burn }
+}
+
+func (s *Service) Push(ctx context.Context, appID uuid.UUID, kid uint8, blob []byte, opts ...PushOption) (rcpt *Receipt, err error) {
+	ctx, span := tracer.Start(ctx, "service.Push")
+	defer func() { tracing.End(span, err) }()
+	cfg, err := newPushConfig(opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if int64(len(blob)) > s.MaxBlob() {
+		return nil, ErrBlobTooLarge
 	}
-	if !exists {
-		return ErrAppNotFound
+	pad, err := s.checkApp(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	if !pad.Allows(len(blob)) {
+		return nil, ErrPaddingMismatch
+	}
+	release, err := s.checkForm(ctx, appID, cfg.formID)
+	if err != nil {
+		return s.replayLimited(ctx, appID, cfg, err)
+	}
+	defer func() { releaseUnless(release, rcpt, err) }()
+	return s.insert(ctx, appID, kid, blob, cfg)
+}
+
+// PushReader is the streaming flavour of Push. The app is checked before the
+// body is touched, and reading stops as soon as the ciphertext grows past
+// MaxBlob, so oversized uploads are never buffered in full.
+func (s *Service) PushReader(ctx context.Context, appID uuid.UUID, kid uint8, r io.Reader, opts ...PushOption) (rcpt *Receipt, err error) {
+	ctx, span := tracer.Start(ctx, "service.PushReader")
+	defer func() { tracing.End(span, err) }()
+	cfg, err := newPushConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	pad, err := s.checkApp(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	release, err := s.checkForm(ctx, appID, cfg.formID)
+	if err != nil {
+		return s.replayLimited(ctx, appID, cfg, err)
+	}
+	defer func() { releaseUnless(release, rcpt, err) }()
+	maxBlob := s.MaxBlob()
+	blob, err := io.ReadAll(io.LimitReader(r, maxBlob+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(blob)) > maxBlob {
+		return nil, ErrBlobTooLarge
+	}
+	if !pad.Allows(len(blob)) {
+		return nil, ErrPaddingMismatch
+	}
+	return s.insert(ctx, appID, kid, blob, cfg)
+}
+
+// replayLimited handles a push its form's rate limit refused. A retry of
+// a push that was already stored is answered from its idempotency claim
+// all the same: it costs nothing, and a 429 would leave the client
+// unsure whether the original went through.
+func (s *Service) replayLimited(ctx context.Context, appID uuid.UUID, cfg pushConfig, err error) (*Receipt, error) {
+	if !errors.Is(err, ErrFormRateLimited) || cfg.idemKey == "" {
+		return nil, err
+	}
+	id, ts, claimErr := s.Store.IdempotencyClaim(ctx, appID, cfg.idemKey, time.Now().Add(-s.idemTTL()))
+	switch {
+	case errors.Is(claimErr, sql.ErrNoRows):
+		return nil, err
+	case errors.Is(claimErr, store.ErrReplayGone):
+		return nil, ErrReplayGone
+	case claimErr != nil:
+		return nil, claimErr
+	}
+	return &Receipt{ID: id, TS: ts, Replayed: true}, nil
+}
+
+// releaseUnless hands a form rate limit token back unless the push it was
+// taken for stored a new submission.
+func releaseUnless(release func(), rcpt *Receipt, err error) {
+	if err != nil || rcpt.Replayed {
+		release()
+	}
+}
+
+func newPushConfig(opts []PushOption) (pushConfig, error) {
+	var cfg pushConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if !validIdempotencyKey(cfg.idemKey) {
+		return cfg, ErrInvalidIdempotencyKey
+	}
+	return cfg, nil
+}
+
+// validIdempotencyKey accepts the empty key (no idempotency) or up to
+// MaxIdempotencyKey printable ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) > MaxIdempotencyKey {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// BatchItem is one sealed submission inside a PushBatch call.
+type BatchItem struct {
+	AppID  uuid.UUID
+	Kid    uint8
+	Blob   []byte
+	Burn   bool   // see WithBurnAfterReading
+	FormID string // see WithFormID
+}
+
+// BatchResult reports the outcome for the BatchItem at the same index.
+// Err is nil when the item was stored under ID.
+type BatchResult struct {
+	ID  uuid.UUID
+	Err error
+}
+
+// PushBatch validates every item on its own and stores the valid ones in a
+// single atomic insert. Per-item problems (unknown app, oversized or
+// unpadded blob) are reported in the results; the returned error is
+// reserved for failures that affect the whole batch.
+func (s *Service) PushBatch(ctx context.Context, items []BatchItem) (results []BatchResult, err error) {
+	ctx, span := tracer.Start(ctx, "service.PushBatch")
+	defer func() { tracing.End(span, err) }()
+	if len(items) > MaxBatch {
+		return nil, ErrBatchTooLarge
+	}
+	results = make([]BatchResult, len(items))
+	known := make(map[uuid.UUID]error)
+	pads := make(map[uuid.UUID]model.Padding)
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	maxBlob := s.MaxBlob()
+
+	var subs []*model.Submission
+	var releases []func() // form rate limit tokens taken for subs
+	defer func() {
+		if err != nil {
+			for _, release := range releases {
+				release()
+			}
+		}
+	}()
+	for i, it := range items {
+		if int64(len(it.Blob)) > maxBlob {
+			results[i].Err = ErrBlobTooLarge
+			continue
+		}
+		appErr, seen := known[it.AppID]
+		if !seen {
+			var pad model.Padding
+			pad, appErr = s.checkBatchApp(ctx, it.AppID)
+			if appErr != nil && !errors.Is(appErr, ErrAppNotFound) && !errors.Is(appErr, ErrUploadTokenRequired) {
+				return nil, appErr
+			}
+			known[it.AppID] = appErr
+			pads[it.AppID] = pad
+		}
+		if appErr != nil {
+			results[i].Err = appErr
+			continue
+		}
+		if pad := pads[it.AppID]; !pad.Allows(len(it.Blob)) {
+			results[i].Err = ErrPaddingMismatch
+			continue
+		}
+		release, err := s.checkForm(ctx, it.AppID, it.FormID)
+		if err != nil {
+			if !errors.Is(err, ErrUnknownForm) && !errors.Is(err, ErrFormRateLimited) {
+				return nil, err
+			}
+			results[i].Err = err
+			continue
+		}
+		releases = append(releases, release)
+		sub := &model.Submission{
+			ID:     uuid.New(),
+			AppID:  it.AppID,
+			Kid:    it.Kid,
+			TS:     now,
+			Blob:   it.Blob,
+			Burn:   it.Burn,
+			FormID: it.FormID,
+		}
+		results[i].ID = sub.ID
+		subs = append(subs, sub)
+	}
+
+	if len(subs) > 0 {
+		if err := s.Store.InsertSubmissions(ctx, subs); err != nil {
+			return nil, uploadErr(err)
+		}
+	}
+	// only apps that got a submission; one whose items were all refused
+	// has nothing new to show
+	announced := make(map[uuid.UUID]bool)
+	for _, sub := range subs {
+		if !announced[sub.AppID] {
+			announced[sub.AppID] = true
+			s.announce(sub.AppID)
+		}
+	}
+	return results, nil
+}
+
+// checkApp returns the app's padding policy, which pushes need anyway, so
+// loading it doubles as the existence check.
+func (s *Service) checkApp(ctx context.Context, appID uuid.UUID) (model.Padding, error) {
+	p, err := s.Store.Padding(ctx, appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrAppNotFound
+	}
+	return p, err
+}
+
+// checkBatchApp is checkApp for batch items, which cannot carry upload
+// tokens and so are refused by apps in dead-drop mode.
+func (s *Service) checkBatchApp(ctx context.Context, appID uuid.UUID) (model.Padding, error) {
+	p, err := s.checkApp(ctx, appID)
+	if err != nil {
+		return p, err
+	}
+	on, err := s.Store.DeadDrop(ctx, appID)
+	if err != nil {
+		return p, err
+	}
+	if on {
+		return p, ErrUploadTokenRequired
+	}
+	return p, nil
+}
+
+func (s *Service) insert(ctx context.Context, appID uuid.UUID, kid uint8, blob []byte, cfg pushConfig) (*Receipt, error) {
+	tokenID, err := s.uploadToken(ctx, appID, cfg.uploadToken)
+	if err != nil {
+		return nil, err
 	}
 	sub := &model.Submission{
-		ID:    uuid.New(),
-		AppID: appID,
-		Kid:   kid,
-		TS:    time.Now().UTC(),
-		Blob:  blob,
+		ID:            uuid.New(),
+		AppID:         appID,
+		Kid:           kid,
+		TS:            time.Now().UTC().Truncate(time.Microsecond), // store precision
+		Blob:          blob,
+		Burn:          cfg.burn,
+		UploadTokenID: tokenID,
+		FormID:        cfg.formID,
+	}
+	var token string
+	if cfg.withToken {
+		if token, err = randomToken(); err != nil {
+			return nil, err
+		}
+		sub.DeleteTokenHash = hashToken(token)
+	}
+	if cfg.idemKey == "" {
+		if err := s.Store.InsertSubmission(ctx, sub); err != nil {
+			return nil, uploadErr(err)
+		}
+		s.announce(appID)
+		return &Receipt{ID: sub.ID, TS: sub.TS, DeletionToken: token}, nil
+	}
+
+	// On a replay the store swaps in the original ID and TS.
+	replayed, err := s.Store.InsertSubmissionOnce(ctx, sub, cfg.idemKey, sub.TS.Add(-s.idemTTL()))
+	if errors.Is(err, store.ErrReplayGone) {
+		return nil, ErrReplayGone
+	} else if err != nil {
+		return nil, uploadErr(err)
+	}
+	if replayed {
+		return &Receipt{ID: sub.ID, TS: sub.TS, Replayed: true}, nil
 	}
-	return s.Store.InsertSubmission(ctx, sub)
+	s.announce(appID)
+	return &Receipt{ID: sub.ID, TS: sub.TS, DeletionToken: token}, nil
 }
 
-func (s *Service) Pull(ctx context.Context, appID uuid.UUID, fn func(*model.Submission) error) error {
-	return s.Store.StreamSubmissions(ctx, appID, fn)
+// Pull streams the app's submissions, oldest first; a non-empty formID
+// restricts them to that form.
+func (s *Service) Pull(ctx context.Context, appID uuid.UUID, formID string, fn func(*model.Submission) error) (err error) {
+	ctx, span := tracer.Start(ctx, "service.Pull")
+	defer func() { tracing.End(span, err) }()
+	return s.Store.StreamSubmissions(ctx, appID, formID, fn)
 }
@@ -3,6 +3,7 @@ package service_test
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
@@ -12,30 +13,114 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 
 	"github.com/cloudflare/circl/kem/hybrid"
+	"github.com/collapsinghierarchy/noisybuffer/config"
 	"github.com/collapsinghierarchy/noisybuffer/model"
 	"github.com/collapsinghierarchy/noisybuffer/pkc/kem"
 	"github.com/collapsinghierarchy/noisybuffer/service"
+	"github.com/collapsinghierarchy/noisybuffer/store"
 	"github.com/google/uuid"
 )
 
-// fakeStore implements the minimal store.Store interface for tests.
+func testConfig(maxBlob int64) *config.Live {
+	return config.NewLive(config.Config{MaxBlobBytes: maxBlob})
+}
+
+// fakeStore implements the minimal store.Store interface for tests. The
+// embedded interface makes unexercised methods panic.
 type fakeStore struct {
+	store.Store
 	exists         bool
 	existsErr      error
 	inserted       *model.Submission
 	insertErr      error
+	batch          []*model.Submission
+	claims         map[string]*model.Submission // idempotency key -> original
+	ownerHash      []byte
+	webhooks       []*model.Webhook
 	submissions    []*model.Submission
 	streamErr      error
 	streamedCalled bool
+	deadDrop       bool
+	forms          map[string]*model.AppForm
+	padding        model.Padding
+}
+
+func (f *fakeStore) AppForm(ctx context.Context, appID uuid.UUID, id string) (*model.AppForm, error) {
+	if fm, ok := f.forms[id]; ok {
+		return fm, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeStore) DeleteAppForm(ctx context.Context, appID uuid.UUID, id string) error {
+	if _, ok := f.forms[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(f.forms, id)
+	return nil
 }
 
 func (f *fakeStore) AppExists(ctx context.Context, id uuid.UUID) (bool, error) {
 	return f.exists, f.existsErr
 }
 
+func (f *fakeStore) DeadDrop(ctx context.Context, id uuid.UUID) (bool, error) {
+	return f.deadDrop, nil
+}
+
+func (f *fakeStore) Padding(ctx context.Context, id uuid.UUID) (model.Padding, error) {
+	if f.existsErr != nil {
+		return model.Padding{}, f.existsErr
+	}
+	if !f.exists {
+		return model.Padding{}, sql.ErrNoRows
+	}
+	return f.padding, nil
+}
+
+func (f *fakeStore) SetPadding(ctx context.Context, id uuid.UUID, p model.Padding) error {
+	f.padding = p
+	return nil
+}
+
+func (f *fakeStore) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub, ownerHash []byte) error {
+	f.ownerHash = ownerHash
+	return nil
+}
+
+func (f *fakeStore) OwnerTokenHash(ctx context.Context, appID uuid.UUID) ([]byte, error) {
+	return f.ownerHash, nil
+}
+
+func (f *fakeStore) SetOwnerTokenHash(ctx context.Context, appID uuid.UUID, hash []byte) error {
+	if !f.exists {
+		return sql.ErrNoRows
+	}
+	f.ownerHash = hash
+	return nil
+}
+
+func (f *fakeStore) CreateWebhook(ctx context.Context, w *model.Webhook, max int) error {
+	if len(f.webhooks) >= max {
+		return store.ErrTooManyWebhooks
+	}
+	f.webhooks = append(f.webhooks, w)
+	return nil
+}
+
+func (f *fakeStore) ListWebhooks(ctx context.Context, appID uuid.UUID) ([]*model.Webhook, error) {
+	var out []*model.Webhook
+	for _, w := range f.webhooks {
+		c := *w
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
 func (f *fakeStore) InsertSubmission(ctx context.Context, s *model.Submission) error {
 	if f.insertErr != nil {
 		return f.insertErr
@@ -46,9 +131,42 @@ func (f *fakeStore) InsertSubmission(ctx context.Context, s *model.Submission) e
 	return nil
 }
 
-func (f *fakeStore) StreamSubmissions(ctx context.Context, appID uuid.UUID, fn func(*model.Submission) error) error {
+func (f *fakeStore) InsertSubmissions(ctx context.Context, subs []*model.Submission) error {
+	if f.insertErr != nil {
+		return f.insertErr
+	}
+	f.batch = append(f.batch, subs...)
+	return nil
+}
+
+func (f *fakeStore) InsertSubmissionOnce(ctx context.Context, s *model.Submission, key string, notBefore time.Time) (bool, error) {
+	if orig, ok := f.claims[key]; ok && !orig.TS.Before(notBefore) {
+		s.ID, s.TS = orig.ID, orig.TS
+		return true, nil
+	}
+	if err := f.InsertSubmission(ctx, s); err != nil {
+		return false, err
+	}
+	if f.claims == nil {
+		f.claims = make(map[string]*model.Submission)
+	}
+	f.claims[key] = f.inserted
+	return false, nil
+}
+
+func (f *fakeStore) IdempotencyClaim(ctx context.Context, appID uuid.UUID, key string, notBefore time.Time) (uuid.UUID, time.Time, error) {
+	if orig, ok := f.claims[key]; ok && !orig.TS.Before(notBefore) {
+		return orig.ID, orig.TS, nil
+	}
+	return uuid.Nil, time.Time{}, sql.ErrNoRows
+}
+
+func (f *fakeStore) StreamSubmissions(ctx context.Context, appID uuid.UUID, formID string, fn func(*model.Submission) error) error {
 	f.streamedCalled = true
 	for _, s := range f.submissions {
+		if formID != "" && s.FormID != formID {
+			continue
+		}
 		if err := fn(s); err != nil {
 			return err
 		}
@@ -58,13 +176,13 @@ func (f *fakeStore) StreamSubmissions(ctx context.Context, appID uuid.UUID, fn f
 
 func TestPush_Success(t *testing.T) {
 	fs := &fakeStore{exists: true}
-	svc := service.New(fs, 1024)
+	svc := service.New(fs, testConfig(1024))
 
 	id := uuid.New()
 	blob := []byte("data")
 	kid := uint8(5)
 
-	err := svc.Push(context.Background(), id, kid, blob)
+	rcpt, err := svc.Push(context.Background(), id, kid, blob)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -87,12 +205,15 @@ func TestPush_Success(t *testing.T) {
 	if s.ID == uuid.Nil {
 		t.Error("ID should be generated, got Nil UUID")
 	}
+	if rcpt.ID != s.ID || !rcpt.TS.Equal(s.TS) || rcpt.Replayed {
+		t.Errorf("receipt mismatch: got %+v for submission %v", rcpt, s.ID)
+	}
 }
 
 func TestPush_BlobTooLarge(t *testing.T) {
 	fs := &fakeStore{exists: true}
-	svc := service.New(fs, 2) // maxBlob = 2 bytes
-	err := svc.Push(context.Background(), uuid.New(), 1, []byte("toolarge"))
+	svc := service.New(fs, testConfig(2)) // maxBlob = 2 bytes
+	_, err := svc.Push(context.Background(), uuid.New(), 1, []byte("toolarge"))
 	if err == nil || err.Error() != "blob too large" {
 		t.Fatalf("expected blob too large error, got %v", err)
 	}
@@ -103,8 +224,8 @@ func TestPush_BlobTooLarge(t *testing.T) {
 
 func TestPush_AppNotFound(t *testing.T) {
 	fs := &fakeStore{exists: false}
-	svc := service.New(fs, 1024)
-	err := svc.Push(context.Background(), uuid.New(), 1, []byte("ok"))
+	svc := service.New(fs, testConfig(1024))
+	_, err := svc.Push(context.Background(), uuid.New(), 1, []byte("ok"))
 	if !errors.Is(err, service.ErrAppNotFound) {
 		t.Fatalf("expected ErrAppNotFound, got %v", err)
 	}
@@ -112,13 +233,506 @@ func TestPush_AppNotFound(t *testing.T) {
 
 func TestPush_AppExistsError(t *testing.T) {
 	fs := &fakeStore{existsErr: errors.New("db down")}
-	svc := service.New(fs, 1024)
-	err := svc.Push(context.Background(), uuid.New(), 1, []byte("ok"))
+	svc := service.New(fs, testConfig(1024))
+	_, err := svc.Push(context.Background(), uuid.New(), 1, []byte("ok"))
 	if err == nil || err.Error() != "db down" {
 		t.Fatalf("expected db down error, got %v", err)
 	}
 }
 
+func TestPushReader_Success(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(1024))
+
+	_, err := svc.PushReader(context.Background(), uuid.New(), 2, bytes.NewReader([]byte("stream")))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if fs.inserted == nil || string(fs.inserted.Blob) != "stream" {
+		t.Fatalf("unexpected stored submission: %+v", fs.inserted)
+	}
+}
+
+func TestPushReader_BlobTooLarge(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(4))
+
+	_, err := svc.PushReader(context.Background(), uuid.New(), 1, bytes.NewReader([]byte("toolarge")))
+	if !errors.Is(err, service.ErrBlobTooLarge) {
+		t.Fatalf("expected ErrBlobTooLarge, got %v", err)
+	}
+	if fs.inserted != nil {
+		t.Error("InsertSubmission should not be called on too-large blob")
+	}
+}
+
+func TestPushReader_AppNotFound(t *testing.T) {
+	fs := &fakeStore{exists: false}
+	svc := service.New(fs, testConfig(1024))
+	_, err := svc.PushReader(context.Background(), uuid.New(), 1, bytes.NewReader([]byte("ok")))
+	if !errors.Is(err, service.ErrAppNotFound) {
+		t.Fatalf("expected ErrAppNotFound, got %v", err)
+	}
+}
+
+func TestPush_IdempotentReplay(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(1024))
+	id := uuid.New()
+
+	first, err := svc.Push(context.Background(), id, 1, []byte("once"), service.WithIdempotencyKey("retry-1"))
+	if err != nil {
+		t.Fatalf("first push: %v", err)
+	}
+	fs.inserted = nil
+
+	second, err := svc.Push(context.Background(), id, 1, []byte("once"), service.WithIdempotencyKey("retry-1"))
+	if err != nil {
+		t.Fatalf("replayed push: %v", err)
+	}
+	if fs.inserted != nil {
+		t.Error("replay should not insert a second submission")
+	}
+	if !second.Replayed || second.ID != first.ID || !second.TS.Equal(first.TS) {
+		t.Errorf("replay receipt %+v does not match original %+v", second, first)
+	}
+}
+
+func TestPush_DeletionToken(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(1024))
+	id := uuid.New()
+
+	first, err := svc.Push(context.Background(), id, 1, []byte("x"),
+		service.WithIdempotencyKey("k"), service.WithDeletionToken(true))
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	sum := sha256.Sum256([]byte(first.DeletionToken))
+	if first.DeletionToken == "" || !bytes.Equal(fs.inserted.DeleteTokenHash, sum[:]) {
+		t.Fatalf("token %q, stored hash %x", first.DeletionToken, fs.inserted.DeleteTokenHash)
+	}
+	second, err := svc.Push(context.Background(), id, 1, []byte("x"),
+		service.WithIdempotencyKey("k"), service.WithDeletionToken(true))
+	if err != nil || !second.Replayed || second.DeletionToken != "" {
+		t.Errorf("replay %+v (%v) must not carry a token it cannot know", second, err)
+	}
+}
+
+func TestPush_IdempotencyKeyExpired(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, config.NewLive(config.Config{MaxBlobBytes: 1024, IdempotencyTTL: -time.Second}))
+	id := uuid.New()
+
+	first, _ := svc.Push(context.Background(), id, 1, []byte("a"), service.WithIdempotencyKey("k"))
+	second, err := svc.Push(context.Background(), id, 1, []byte("a"), service.WithIdempotencyKey("k"))
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if second.Replayed || second.ID == first.ID {
+		t.Error("expired key should allow a fresh submission")
+	}
+}
+
+func TestPush_InvalidIdempotencyKey(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(1024))
+	_, err := svc.Push(context.Background(), uuid.New(), 1, []byte("a"), service.WithIdempotencyKey("has space"))
+	if !errors.Is(err, service.ErrInvalidIdempotencyKey) {
+		t.Fatalf("expected ErrInvalidIdempotencyKey, got %v", err)
+	}
+}
+
+func TestPush_FormID(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{exists: true, forms: map[string]*model.AppForm{
+		"contact": {AppID: appID, ID: "contact"},
+		"jobs":    {AppID: appID, ID: "jobs", RateMin: 1},
+	}}
+	svc := service.New(fs, testConfig(1024))
+	ctx := context.Background()
+
+	if _, err := svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("contact")); err != nil {
+		t.Fatalf("push to registered form: %v", err)
+	}
+	if fs.inserted.FormID != "contact" {
+		t.Errorf("submission form %q, want contact", fs.inserted.FormID)
+	}
+	if _, err := svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("bugs")); !errors.Is(err, service.ErrUnknownForm) {
+		t.Errorf("unregistered form: %v", err)
+	}
+	if _, err := svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("jobs")); err != nil {
+		t.Fatalf("first push within the form limit: %v", err)
+	}
+	if _, err := svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("jobs")); !errors.Is(err, service.ErrFormRateLimited) {
+		t.Errorf("second push in the same minute: %v", err)
+	}
+	if _, err := svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("contact")); err != nil {
+		t.Errorf("other forms keep their own limit: %v", err)
+	}
+
+	res, err := svc.PushBatch(ctx, []service.BatchItem{
+		{AppID: appID, Kid: 1, Blob: []byte("a"), FormID: "contact"},
+		{AppID: appID, Kid: 1, Blob: []byte("b"), FormID: "bugs"},
+	})
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	if res[0].Err != nil || !errors.Is(res[1].Err, service.ErrUnknownForm) {
+		t.Errorf("batch results %+v", res)
+	}
+	if len(fs.batch) != 1 || fs.batch[0].FormID != "contact" {
+		t.Errorf("batch stored %+v", fs.batch)
+	}
+}
+
+func TestPush_FormRateLimitSparesReplays(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{exists: true, forms: map[string]*model.AppForm{
+		"jobs": {AppID: appID, ID: "jobs", RateMin: 1, RateBurst: 2},
+	}}
+	svc := service.New(fs, testConfig(1024))
+	ctx := context.Background()
+	push := func(key string) (*service.Receipt, error) {
+		return svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("jobs"), service.WithIdempotencyKey(key))
+	}
+
+	first, err := push("k1")
+	if err != nil {
+		t.Fatalf("first push: %v", err)
+	}
+	if r, err := push("k1"); err != nil || !r.Replayed || r.ID != first.ID {
+		t.Fatalf("retry with a token left: %+v, %v", r, err)
+	}
+	if _, err := push("k2"); err != nil {
+		t.Fatalf("the retry used up the second token: %v", err)
+	}
+	if r, err := push("k2"); err != nil || !r.Replayed {
+		t.Fatalf("retry with the bucket empty: %+v, %v", r, err)
+	}
+	if _, err := push("k3"); !errors.Is(err, service.ErrFormRateLimited) {
+		t.Errorf("new push past the limit: %v", err)
+	}
+}
+
+func TestDeleteAppForm_ForgetsRateLimit(t *testing.T) {
+	appID := uuid.New()
+	jobs := &model.AppForm{AppID: appID, ID: "jobs", RateMin: 1}
+	fs := &fakeStore{exists: true, forms: map[string]*model.AppForm{"jobs": jobs}}
+	svc := service.New(fs, testConfig(1024))
+	ctx := context.Background()
+
+	if _, err := svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("jobs")); err != nil {
+		t.Fatalf("first push: %v", err)
+	}
+	if err := svc.DeleteAppForm(ctx, appID, "jobs"); err != nil {
+		t.Fatalf("DeleteAppForm: %v", err)
+	}
+	fs.forms["jobs"] = jobs // registered again
+	if _, err := svc.Push(ctx, appID, 1, []byte("a"), service.WithFormID("jobs")); err != nil {
+		t.Errorf("recreated form inherited the old bucket: %v", err)
+	}
+}
+
+func TestPush_Padding(t *testing.T) {
+	appID := uuid.New()
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(1024))
+	ctx := context.Background()
+
+	for _, bad := range []model.Padding{
+		{Mode: "random"},
+		{Mode: model.PaddingPow2, Size: 100},
+		{Mode: model.PaddingFixed},
+		{Mode: model.PaddingFixed, Size: 2048},
+	} {
+		if _, err := svc.SetPadding(ctx, appID, bad); !errors.Is(err, service.ErrInvalidPadding) {
+			t.Errorf("SetPadding(%+v): %v", bad, err)
+		}
+	}
+	if p, err := svc.SetPadding(ctx, appID, model.Padding{Mode: model.PaddingPow2, Size: 64}); err != nil || p != fs.padding {
+		t.Fatalf("SetPadding: %+v %v", p, err)
+	}
+
+	for n, ok := range map[int]bool{64: true, 256: true, 1024: true, 32: false, 65: false, 100: false} {
+		_, err := svc.Push(ctx, appID, 1, make([]byte, n))
+		if ok && err != nil {
+			t.Errorf("push of %d bytes: %v", n, err)
+		} else if !ok && !errors.Is(err, service.ErrPaddingMismatch) {
+			t.Errorf("push of %d bytes: %v, want ErrPaddingMismatch", n, err)
+		}
+	}
+	if _, err := svc.PushReader(ctx, appID, 1, bytes.NewReader(make([]byte, 70))); !errors.Is(err, service.ErrPaddingMismatch) {
+		t.Errorf("streamed push of 70 bytes: %v", err)
+	}
+
+	res, err := svc.PushBatch(ctx, []service.BatchItem{
+		{AppID: appID, Kid: 1, Blob: make([]byte, 128)},
+		{AppID: appID, Kid: 1, Blob: make([]byte, 129)},
+	})
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	if res[0].Err != nil || !errors.Is(res[1].Err, service.ErrPaddingMismatch) {
+		t.Errorf("batch results %+v", res)
+	}
+
+	if _, err := svc.SetPadding(ctx, appID, model.Padding{Mode: model.PaddingFixed, Size: 512}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Push(ctx, appID, 1, make([]byte, 256)); !errors.Is(err, service.ErrPaddingMismatch) {
+		t.Errorf("fixed padding accepted a smaller blob: %v", err)
+	}
+	if _, err := svc.Push(ctx, appID, 1, make([]byte, 512)); err != nil {
+		t.Errorf("fixed padding refused an exact blob: %v", err)
+	}
+}
+
+func TestRegisterKey_OwnerToken(t *testing.T) {
+	fs := &fakeStore{}
+	svc := service.New(fs, testConfig(1024))
+	id := uuid.New()
+
+	token, err := svc.RegisterKey(context.Background(), id, 0, []byte("pub"))
+	if err != nil {
+		t.Fatalf("RegisterKey: %v", err)
+	}
+	if token == "" || bytes.Contains(fs.ownerHash, []byte(token)) {
+		t.Fatal("expected a token with only its hash stored")
+	}
+	if err := svc.AuthorizeOwner(context.Background(), id, token); err != nil {
+		t.Errorf("owner token rejected: %v", err)
+	}
+	if err := svc.AuthorizeOwner(context.Background(), id, token+"x"); !errors.Is(err, service.ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for wrong token, got %v", err)
+	}
+	if err := svc.AuthorizeOwner(context.Background(), id, ""); !errors.Is(err, service.ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for empty token, got %v", err)
+	}
+}
+
+func TestResetOwnerToken(t *testing.T) {
+	fs := &fakeStore{exists: true} // registered before owner tokens: no hash
+	svc := service.New(fs, testConfig(1024))
+	ctx := context.Background()
+	id := uuid.New()
+
+	if err := svc.AuthorizeOwner(ctx, id, "anything"); !errors.Is(err, service.ErrUnauthorized) {
+		t.Fatalf("app without a token: %v", err)
+	}
+	first, err := svc.ResetOwnerToken(ctx, id)
+	if err != nil {
+		t.Fatalf("ResetOwnerToken: %v", err)
+	}
+	if err := svc.AuthorizeOwner(ctx, id, first); err != nil {
+		t.Errorf("reset token rejected: %v", err)
+	}
+	second, err := svc.ResetOwnerToken(ctx, id)
+	if err != nil {
+		t.Fatalf("ResetOwnerToken: %v", err)
+	}
+	if err := svc.AuthorizeOwner(ctx, id, first); !errors.Is(err, service.ErrUnauthorized) {
+		t.Errorf("replaced token still accepted: %v", err)
+	}
+	if err := svc.AuthorizeOwner(ctx, id, second); err != nil {
+		t.Errorf("new token rejected: %v", err)
+	}
+
+	fs.exists = false
+	if _, err := svc.ResetOwnerToken(ctx, id); !errors.Is(err, service.ErrAppNotFound) {
+		t.Errorf("unknown app: %v", err)
+	}
+}
+
+func TestRegisterKey_AllowedKEMs(t *testing.T) {
+	cfg := config.NewLive(config.Config{MaxBlobBytes: 1024, AllowedKEMs: []string{"X25519Kyber768"}})
+	svc := service.New(&fakeStore{}, cfg)
+	ctx := context.Background()
+
+	if _, err := svc.RegisterKey(ctx, uuid.New(), 0, make([]byte, 32)); !errors.Is(err, service.ErrKEMNotAllowed) {
+		t.Errorf("X25519-sized key: expected ErrKEMNotAllowed, got %v", err)
+	}
+	if _, err := svc.RegisterKey(ctx, uuid.New(), 0, make([]byte, config.KEMs["X25519Kyber768"])); err != nil {
+		t.Errorf("hybrid key rejected: %v", err)
+	}
+
+	next := *cfg.Load()
+	next.AllowedKEMs = append(next.AllowedKEMs, "DHKEM-X25519")
+	cfg.Reload(next)
+	if _, err := svc.RegisterKey(ctx, uuid.New(), 0, make([]byte, 32)); err != nil {
+		t.Errorf("X25519 key rejected after reload: %v", err)
+	}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	fs := &fakeStore{}
+	svc := service.New(fs, testConfig(1024))
+	id := uuid.New()
+
+	if _, err := svc.CreateWebhook(context.Background(), id, "ftp://example.com", false, ""); !errors.Is(err, service.ErrInvalidWebhookURL) {
+		t.Fatalf("expected ErrInvalidWebhookURL, got %v", err)
+	}
+	for _, u := range []string{"http://127.0.0.1:9090/metrics", "http://localhost/", "http://169.254.169.254/", "https://[::1]/", "http://10.0.0.7/"} {
+		if _, err := svc.CreateWebhook(context.Background(), id, u, false, ""); !errors.Is(err, service.ErrPrivateWebhookURL) {
+			t.Errorf("%s: expected ErrPrivateWebhookURL, got %v", u, err)
+		}
+	}
+	hook, err := svc.CreateWebhook(context.Background(), id, "https://example.com/hook", true, "")
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if len(hook.Secret) != 32 || !hook.IncludeBlob || hook.AppID != id {
+		t.Errorf("unexpected webhook: %+v", hook)
+	}
+	listed, err := svc.ListWebhooks(context.Background(), id)
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("ListWebhooks = %v, %v", listed, err)
+	}
+	if listed[0].Secret != nil {
+		t.Error("ListWebhooks must not expose secrets")
+	}
+	for len(fs.webhooks) < service.MaxWebhooks {
+		if _, err := svc.CreateWebhook(context.Background(), id, "https://example.com/hook", false, ""); err != nil {
+			t.Fatalf("CreateWebhook below the cap: %v", err)
+		}
+	}
+	if _, err := svc.CreateWebhook(context.Background(), id, "https://example.com/hook", false, ""); !errors.Is(err, service.ErrTooManyWebhooks) {
+		t.Errorf("CreateWebhook at the cap: got %v, want ErrTooManyWebhooks", err)
+	}
+}
+
+func TestCreateWebhook_AllowPrivate(t *testing.T) {
+	svc := service.New(&fakeStore{}, config.NewLive(config.Config{MaxBlobBytes: 1024, WebhookAllowPrivate: true}))
+	if _, err := svc.CreateWebhook(context.Background(), uuid.New(), "http://10.0.0.7/hook", false, ""); err != nil {
+		t.Fatalf("private target with WebhookAllowPrivate: %v", err)
+	}
+}
+
+func TestPushBatch_PartialFailure(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(4))
+	id := uuid.New()
+
+	results, err := svc.PushBatch(context.Background(), []service.BatchItem{
+		{AppID: id, Kid: 1, Blob: []byte("ok")},
+		{AppID: id, Kid: 1, Blob: []byte("toolarge")},
+		{AppID: id, Kid: 2, Blob: []byte("fine")},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Fatalf("unexpected item errors: %v, %v", results[0].Err, results[2].Err)
+	}
+	if !errors.Is(results[1].Err, service.ErrBlobTooLarge) {
+		t.Errorf("expected ErrBlobTooLarge for item 1, got %v", results[1].Err)
+	}
+	if len(fs.batch) != 2 {
+		t.Fatalf("expected 2 stored submissions, got %d", len(fs.batch))
+	}
+	if fs.batch[0].ID != results[0].ID || fs.batch[1].ID != results[2].ID {
+		t.Error("result IDs do not match stored submissions")
+	}
+}
+
+func TestPushBatch_AppNotFound(t *testing.T) {
+	fs := &fakeStore{exists: false}
+	svc := service.New(fs, testConfig(1024))
+	results, err := svc.PushBatch(context.Background(), []service.BatchItem{
+		{AppID: uuid.New(), Kid: 1, Blob: []byte("ok")},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !errors.Is(results[0].Err, service.ErrAppNotFound) {
+		t.Errorf("expected ErrAppNotFound, got %v", results[0].Err)
+	}
+	if fs.batch != nil {
+		t.Error("InsertSubmissions should not be called without valid items")
+	}
+}
+
+func TestPushBatch_AnnouncesOnlyStoredApps(t *testing.T) {
+	fs := &fakeStore{exists: true}
+	svc := service.New(fs, testConfig(1024))
+	stored, refused := uuid.New(), uuid.New()
+	storedTicks, cancel := svc.Subscribe(stored)
+	defer cancel()
+	refusedTicks, cancel := svc.Subscribe(refused)
+	defer cancel()
+
+	results, err := svc.PushBatch(context.Background(), []service.BatchItem{
+		{AppID: stored, Kid: 1, Blob: []byte("ok")},
+		{AppID: refused, Kid: 1, Blob: []byte("ok"), FormID: "nope"},
+	})
+	if err != nil || !errors.Is(results[1].Err, service.ErrUnknownForm) {
+		t.Fatalf("PushBatch = %v, %v", results, err)
+	}
+	select {
+	case <-storedTicks:
+	default:
+		t.Error("no tick for the app that got a submission")
+	}
+	select {
+	case <-refusedTicks:
+		t.Error("tick for an app whose items were all refused")
+	default:
+	}
+}
+
+func TestPushBatch_TooManyItems(t *testing.T) {
+	svc := service.New(&fakeStore{exists: true}, testConfig(1024))
+	_, err := svc.PushBatch(context.Background(), make([]service.BatchItem, service.MaxBatch+1))
+	if !errors.Is(err, service.ErrBatchTooLarge) {
+		t.Fatalf("expected ErrBatchTooLarge, got %v", err)
+	}
+}
+
+func TestBroadcaster_SubscribePublish(t *testing.T) {
+	b := service.NewBroadcaster()
+	app, other := uuid.New(), uuid.New()
+	ticks, cancel := b.Subscribe(app)
+
+	b.Publish(other)
+	select {
+	case <-ticks:
+		t.Fatal("tick for another app")
+	default:
+	}
+
+	b.Publish(app)
+	b.Publish(app) // coalesces, must not block
+	select {
+	case <-ticks:
+	default:
+		t.Fatal("expected a tick")
+	}
+
+	cancel()
+	b.Publish(app)
+	select {
+	case <-ticks:
+		t.Fatal("tick after cancel")
+	default:
+	}
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	c := service.Cursor{Xact: 1<<40 + 7, Seq: 42}
+	got, err := service.ParseCursor(c.String())
+	if err != nil {
+		t.Fatalf("ParseCursor: %v", err)
+	}
+	if got != c {
+		t.Errorf("round trip mismatch: %v vs %v", got, c)
+	}
+	for _, bad := range []string{"garbage", "1_", "-1_2", "1_-2", "1735689600000000_" + uuid.NewString()} {
+		if _, err := service.ParseCursor(bad); !errors.Is(err, service.ErrInvalidCursor) {
+			t.Errorf("%q: expected ErrInvalidCursor, got %v", bad, err)
+		}
+	}
+}
+
 func TestPull_StreamsAll(t *testing.T) {
 	id := uuid.New()
 	subs := []*model.Submission{
@@ -126,10 +740,10 @@ func TestPull_StreamsAll(t *testing.T) {
 		{ID: uuid.New(), AppID: id, Blob: []byte("b")},
 	}
 	fs := &fakeStore{submissions: subs}
-	svc := service.New(fs, 1024)
+	svc := service.New(fs, testConfig(1024))
 
 	var collected []*model.Submission
-	err := svc.Pull(context.Background(), id, func(s *model.Submission) error {
+	err := svc.Pull(context.Background(), id, "", func(s *model.Submission) error {
 		collected = append(collected, s)
 		return nil
 	})
@@ -151,8 +765,8 @@ func TestPull_StreamsAll(t *testing.T) {
 
 func TestPull_StreamError(t *testing.T) {
 	fs := &fakeStore{streamErr: errors.New("stream fail")}
-	svc := service.New(fs, 1024)
-	err := svc.Pull(context.Background(), uuid.New(), func(s *model.Submission) error { return nil })
+	svc := service.New(fs, testConfig(1024))
+	err := svc.Pull(context.Background(), uuid.New(), "", func(s *model.Submission) error { return nil })
 	if err == nil || err.Error() != "stream fail" {
 		t.Errorf("expected stream fail error, got %v", err)
 	}
@@ -189,10 +803,10 @@ func TestPush_EncryptedStream(t *testing.T) {
 
 	// --- push via the service -------------------------------------------------
 	fs := &fakeStore{exists: true}
-	svc := service.New(fs, int64(len(blob)+10))
+	svc := service.New(fs, testConfig(int64(len(blob)+10)))
 	appID := uuid.New()
 
-	if err := svc.Push(context.Background(), appID, 1, blob); err != nil {
+	if _, err := svc.Push(context.Background(), appID, 1, blob); err != nil {
 		t.Fatalf("Push error: %v", err)
 	}
 	stored := fs.inserted
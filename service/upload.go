@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUploadTokenRequired = errors.New("app only accepts uploads with a token")
+	ErrUploadTokenInvalid  = errors.New("upload token is unknown, used up, expired or revoked")
+	ErrUploadTokenNotFound = errors.New("upload token not found")
+	ErrInvalidUploadToken  = errors.New("upload token needs 1 to 1000 uses, a positive lifetime of at most a year and a label of at most 200 bytes")
+)
+
+// Upload token limits. A zero MaxUses or TTL asks for the default.
+const (
+	DefaultUploadTokenTTL = 7 * 24 * time.Hour
+	MaxUploadTokenTTL     = 365 * 24 * time.Hour
+	MaxUploadTokenUses    = 1000
+	MaxUploadTokenLabel   = 200
+)
+
+// WithUploadToken redeems token, an upload link minted by the app's owner,
+// for the push. Apps in dead-drop mode accept nothing else.
+func WithUploadToken(token string) PushOption {
+	return func(c *pushConfig) { c.uploadToken = token }
+}
+
+// CreateUploadToken mints an upload link for appID. The token is returned
+// exactly once; only its hash is kept.
+func (s *Service) CreateUploadToken(ctx context.Context, appID uuid.UUID, label string, maxUses int, ttl time.Duration) (t *model.UploadToken, token string, err error) {
+	ctx, span := tracer.Start(ctx, "service.CreateUploadToken")
+	defer func() { tracing.End(span, err) }()
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	if ttl == 0 {
+		ttl = DefaultUploadTokenTTL
+	}
+	if maxUses < 0 || maxUses > MaxUploadTokenUses || ttl < 0 || ttl > MaxUploadTokenTTL ||
+		len(label) > MaxUploadTokenLabel {
+		return nil, "", ErrInvalidUploadToken
+	}
+	if token, err = randomToken(); err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	t = &model.UploadToken{
+		ID:        uuid.New(),
+		AppID:     appID,
+		TokenHash: hashToken(token),
+		Label:     label,
+		MaxUses:   maxUses,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := s.Store.CreateUploadToken(ctx, t); err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+func (s *Service) ListUploadTokens(ctx context.Context, appID uuid.UUID) (ts []*model.UploadToken, err error) {
+	ctx, span := tracer.Start(ctx, "service.ListUploadTokens")
+	defer func() { tracing.End(span, err) }()
+	return s.Store.ListUploadTokens(ctx, appID)
+}
+
+// RevokeUploadToken stops the link from working. Submissions made with it
+// keep their reference.
+func (s *Service) RevokeUploadToken(ctx context.Context, appID, id uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "service.RevokeUploadToken")
+	defer func() { tracing.End(span, err) }()
+	err = s.Store.RevokeUploadToken(ctx, appID, id, time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUploadTokenNotFound
+	}
+	return err
+}
+
+// DeadDrop reports whether appID accepts uploads only with a token.
+func (s *Service) DeadDrop(ctx context.Context, appID uuid.UUID) (on bool, err error) {
+	ctx, span := tracer.Start(ctx, "service.DeadDrop")
+	defer func() { tracing.End(span, err) }()
+	on, err = s.Store.DeadDrop(ctx, appID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrAppNotFound
+	}
+	return on, err
+}
+
+// SetDeadDrop switches dead-drop mode. It applies to the next push; links
+// minted earlier keep working either way.
+func (s *Service) SetDeadDrop(ctx context.Context, appID uuid.UUID, on bool) (err error) {
+	ctx, span := tracer.Start(ctx, "service.SetDeadDrop")
+	defer func() { tracing.End(span, err) }()
+	err = s.Store.SetDeadDrop(ctx, appID, on)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAppNotFound
+	}
+	return err
+}
+
+// uploadToken resolves the push's upload token to its ID. Whether it still
+// has uses left is decided by the insert, which redeems it atomically, so
+// an idempotent retry of the push that used it up is still answered.
+func (s *Service) uploadToken(ctx context.Context, appID uuid.UUID, token string) (*uuid.UUID, error) {
+	if token == "" {
+		return nil, nil
+	}
+	t, err := s.Store.UploadTokenByHash(ctx, appID, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t.ID, nil
+}
+
+// uploadErr maps the store's upload token errors.
+func uploadErr(err error) error {
+	switch {
+	case errors.Is(err, store.ErrUploadTokenRequired):
+		return ErrUploadTokenRequired
+	case errors.Is(err, store.ErrUploadTokenSpent):
+		return ErrUploadTokenInvalid
+	}
+	return err
+}
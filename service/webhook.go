@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+	"github.com/collapsinghierarchy/noisybuffer/webhook"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidWebhookURL = errors.New("webhook url must be an absolute http(s) url")
+	ErrPrivateWebhookURL = errors.New("webhook url must not point to a loopback, private or link-local address")
+	ErrTooManyWebhooks   = errors.New("too many webhooks for this app")
+	ErrWebhookNotFound   = errors.New("webhook not found")
+)
+
+// MaxWebhooks caps the subscriptions per app.
+const MaxWebhooks = 10
+
+// CreateWebhook subscribes rawURL to new-submission events of appID, or
+// only to those of the registered form formID. The returned webhook
+// carries the signing secret, which is not shown again.
+func (s *Service) CreateWebhook(ctx context.Context, appID uuid.UUID, rawURL string, includeBlob bool, formID string) (w *model.Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "service.CreateWebhook")
+	defer func() { tracing.End(span, err) }()
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, ErrInvalidWebhookURL
+	}
+	if !s.cfg.Load().WebhookAllowPrivate && !publicHost(u.Hostname()) {
+		return nil, ErrPrivateWebhookURL
+	}
+	if formID != "" {
+		_, err := s.Store.AppForm(ctx, appID, formID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUnknownForm
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	w = &model.Webhook{
+		ID:          uuid.New(),
+		AppID:       appID,
+		URL:         u.String(),
+		Secret:      secret,
+		IncludeBlob: includeBlob,
+		FormID:      formID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	err = s.Store.CreateWebhook(ctx, w, MaxWebhooks)
+	if errors.Is(err, store.ErrTooManyWebhooks) {
+		return nil, ErrTooManyWebhooks
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListWebhooks returns the app's subscriptions with their secrets cleared.
+func (s *Service) ListWebhooks(ctx context.Context, appID uuid.UUID) (ws []*model.Webhook, err error) {
+	ctx, span := tracer.Start(ctx, "service.ListWebhooks")
+	defer func() { tracing.End(span, err) }()
+	hooks, err := s.Store.ListWebhooks(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range hooks {
+		w.Secret = nil
+	}
+	return hooks, nil
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, appID, id uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "service.DeleteWebhook")
+	defer func() { tracing.End(span, err) }()
+	err = s.Store.DeleteWebhook(ctx, appID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// publicHost catches webhook hosts that are plainly internal. Names are
+// only resolved when delivering, where the dispatcher checks again.
+func publicHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return webhook.PublicAddr(ip)
+	}
+	return true
+}
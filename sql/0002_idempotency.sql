@@ -0,0 +1,10 @@
+
+-- Idempotency keys map a client-chosen key to the submission it created, so
+-- retried pushes return the original receipt instead of a duplicate row.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    app_id         UUID        NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    key            TEXT        NOT NULL,
+    submission_id  UUID        NOT NULL REFERENCES submissions(id) ON DELETE CASCADE,
+    ts             TIMESTAMPTZ NOT NULL,   -- when the key was claimed
+    PRIMARY KEY (app_id, key)
+);
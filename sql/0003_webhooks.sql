@@ -0,0 +1,33 @@
+
+-- Owner bearer token (hash only), issued when the key is registered.
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS owner_token_hash BYTEA;
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id           UUID PRIMARY KEY,
+    app_id       UUID        NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    url          TEXT        NOT NULL,
+    secret       BYTEA       NOT NULL,
+    include_blob BOOLEAN     NOT NULL DEFAULT false,
+    created_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_app_idx ON webhooks (app_id);
+
+-- Transactional outbox: rows are written in the same transaction as the
+-- submission and removed once the receiver acknowledged the event.
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id            BIGSERIAL PRIMARY KEY,
+    webhook_id    UUID        NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    submission_id UUID        NOT NULL,  -- no FK: the event outlives deletion
+    app_id        UUID        NOT NULL,
+    kid           SMALLINT    NOT NULL,
+    ts            TIMESTAMPTZ NOT NULL,
+    size          INTEGER     NOT NULL,
+    attempts      INTEGER     NOT NULL DEFAULT 0,
+    next_attempt  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error    TEXT,
+    dead_at       TIMESTAMPTZ            -- set once retries are exhausted
+);
+
+CREATE INDEX IF NOT EXISTS webhook_outbox_due_idx
+    ON webhook_outbox (next_attempt) WHERE dead_at IS NULL;
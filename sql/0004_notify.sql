@@ -0,0 +1,14 @@
+
+-- Announce every new submission on channel nb_submissions (payload: app id)
+-- so all noisybufferd replicas can wake their live feed subscribers.
+CREATE OR REPLACE FUNCTION nb_notify_submission() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('nb_submissions', NEW.app_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS submissions_notify ON submissions;
+CREATE TRIGGER submissions_notify
+    AFTER INSERT ON submissions
+    FOR EACH ROW EXECUTE FUNCTION nb_notify_submission();
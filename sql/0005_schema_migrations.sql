@@ -0,0 +1,12 @@
+
+-- Record which migrations have been applied so readiness checks can tell an
+-- outdated schema from a healthy one. Every later migration appends its own
+-- number; store/postgres.SchemaVersion names the one the binary expects.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INT         PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version)
+VALUES (1), (2), (3), (4), (5)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,19 @@
+-- Owners acknowledge submissions they have pulled and decrypted; retention
+-- policies may then delete them.
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS acked_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS submissions_acked_idx
+    ON submissions (app_id, acked_at) WHERE acked_at IS NOT NULL;
+
+-- Per-app retention, enforced by the janitor in small batches. legal_hold
+-- is set by the operator and suspends every deletion for the app.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    app_id        UUID        PRIMARY KEY REFERENCES apps(id) ON DELETE CASCADE,
+    max_age_secs  BIGINT      NOT NULL DEFAULT 0,   -- 0: no age limit
+    max_count     INTEGER     NOT NULL DEFAULT 0,   -- 0: no count limit
+    delete_acked  BOOLEAN     NOT NULL DEFAULT false,
+    legal_hold    BOOLEAN     NOT NULL DEFAULT false,
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version) VALUES (6) ON CONFLICT DO NOTHING;
@@ -0,0 +1,13 @@
+-- Burn-after-reading: such submissions are deleted by the owner's ack, not
+-- by the janitor. Per submission (chosen by the submitter) or per app.
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS burn BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE retention_policies
+    ADD COLUMN IF NOT EXISTS burn_after_reading BOOLEAN NOT NULL DEFAULT false;
+
+-- Outbox entries now carry their event type, so a burn can tell receivers
+-- that already hold a copy of the ciphertext to drop it.
+ALTER TABLE webhook_outbox
+    ADD COLUMN IF NOT EXISTS event TEXT NOT NULL DEFAULT 'submission.created';
+
+INSERT INTO schema_migrations (version) VALUES (7) ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+-- Submitters may ask for a deletion token at push time and later withdraw
+-- their submission with it. Only the token's SHA-256 is stored.
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS delete_token_hash BYTEA;
+
+INSERT INTO schema_migrations (version) VALUES (8) ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+-- Owners answer submitters through mailboxes: random IDs chosen by the
+-- submitter and sealed inside the submission, so only the owner can link a
+-- mailbox to a submission. Replies are ciphertext to a key the submitter
+-- keeps; the janitor deletes them after expires_at.
+CREATE TABLE IF NOT EXISTS replies (
+    id          UUID        PRIMARY KEY,
+    app_id      UUID        NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    mailbox     UUID        NOT NULL,
+    ts          TIMESTAMPTZ NOT NULL,
+    blob        BYTEA       NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS replies_mailbox_idx ON replies (mailbox, ts);
+CREATE INDEX IF NOT EXISTS replies_expires_idx ON replies (expires_at);
+
+INSERT INTO schema_migrations (version) VALUES (9) ON CONFLICT DO NOTHING;
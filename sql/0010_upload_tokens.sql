@@ -0,0 +1,51 @@
+-- One-time upload links. Owners mint tokens (only the SHA-256 is stored);
+-- apps in dead-drop mode accept submissions only with one, and each
+-- submission records the token it used.
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS dead_drop BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS upload_tokens (
+    id          UUID        PRIMARY KEY,
+    app_id      UUID        NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    token_hash  BYTEA       NOT NULL UNIQUE,
+    label       TEXT        NOT NULL DEFAULT '',
+    max_uses    INTEGER     NOT NULL CHECK (max_uses > 0),
+    uses        INTEGER     NOT NULL DEFAULT 0,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS upload_tokens_app_idx ON upload_tokens (app_id, created_at);
+
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS upload_token_id UUID
+    REFERENCES upload_tokens(id) ON DELETE SET NULL;
+
+-- Enforced in the database so every insert path (single, COPY, idempotent)
+-- redeems the token in the submission's own transaction: a rolled-back
+-- insert gives the use back.
+CREATE OR REPLACE FUNCTION nb_redeem_upload_token() RETURNS trigger AS $$
+BEGIN
+    IF NEW.upload_token_id IS NULL THEN
+        IF EXISTS (SELECT 1 FROM apps WHERE id = NEW.app_id AND dead_drop) THEN
+            RAISE EXCEPTION 'app % only accepts uploads with a token', NEW.app_id
+                USING ERRCODE = 'NB001';
+        END IF;
+        RETURN NEW;
+    END IF;
+    UPDATE upload_tokens SET uses = uses + 1
+     WHERE id = NEW.upload_token_id AND app_id = NEW.app_id
+       AND uses < max_uses AND expires_at > now() AND revoked_at IS NULL;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'upload token % is used up, expired or revoked', NEW.upload_token_id
+            USING ERRCODE = 'NB002';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS submissions_upload_token ON submissions;
+CREATE TRIGGER submissions_upload_token
+    BEFORE INSERT ON submissions
+    FOR EACH ROW EXECUTE FUNCTION nb_redeem_upload_token();
+
+INSERT INTO schema_migrations (version) VALUES (10) ON CONFLICT DO NOTHING;
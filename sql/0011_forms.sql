@@ -0,0 +1,12 @@
+-- Hosted forms: the public definition (fields, labels, texts) noisybufferd
+-- renders at /f/{appID}. Every change is a new, immutable version, so a
+-- decrypted submission can be matched to the schema it was filled against.
+CREATE TABLE IF NOT EXISTS form_definitions (
+    app_id      UUID        NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    version     INTEGER     NOT NULL,
+    definition  JSONB       NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (app_id, version)
+);
+
+INSERT INTO schema_migrations (version) VALUES (11) ON CONFLICT DO NOTHING;
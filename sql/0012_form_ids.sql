@@ -0,0 +1,29 @@
+-- Form IDs. One app can back several forms (contact, job applications, bug
+-- reports); owners register them, and pushes may name one. The ID is kept
+-- in the clear next to the ciphertext so owners can tell submissions apart
+-- without decrypting them. Each form may tighten retention and limit its
+-- own submission rate.
+CREATE TABLE IF NOT EXISTS app_forms (
+    app_id        UUID        NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    id            TEXT        NOT NULL,
+    label         TEXT        NOT NULL DEFAULT '',
+    max_age_secs  BIGINT      NOT NULL DEFAULT 0,   -- 0: app policy only
+    max_count     INTEGER     NOT NULL DEFAULT 0,   -- 0: app policy only
+    rate_minute   INTEGER     NOT NULL DEFAULT 0,   -- 0: no form limit
+    rate_burst    INTEGER     NOT NULL DEFAULT 0,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (app_id, id)
+);
+
+-- No foreign key: submissions keep their form ID when the form is removed.
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS form_id TEXT;
+
+CREATE INDEX IF NOT EXISTS submissions_form_idx
+    ON submissions (app_id, form_id, ts) WHERE form_id IS NOT NULL;
+
+-- Webhooks may subscribe to a single form (NULL: all of them); they are
+-- removed with it. Outbox entries copy the form like the other columns.
+ALTER TABLE webhooks       ADD COLUMN IF NOT EXISTS form_id TEXT;
+ALTER TABLE webhook_outbox ADD COLUMN IF NOT EXISTS form_id TEXT;
+
+INSERT INTO schema_migrations (version) VALUES (12) ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- Per-app padding policy. Sizes are blob lengths; pushes that do not hit
+-- an allowed one are refused, so stored sizes only reveal the bucket.
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS padding_mode TEXT NOT NULL DEFAULT 'off'
+    CHECK (padding_mode IN ('off', 'pow2', 'fixed'));
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS padding_size INTEGER NOT NULL DEFAULT 0
+    CHECK (padding_size >= 0);
+
+INSERT INTO schema_migrations (version) VALUES (13) ON CONFLICT DO NOTHING;
@@ -0,0 +1,12 @@
+-- Live feed order. ts is assigned by the app before the insert commits, so
+-- a slow transaction can land behind a cursor already handed out. xact is
+-- the inserting transaction and seq breaks ties within it: readers only
+-- stream rows of transactions older than every running writer of this
+-- table, so nothing can commit behind what they have seen.
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS xact xid8 NOT NULL DEFAULT pg_current_xact_id();
+ALTER TABLE submissions ADD COLUMN IF NOT EXISTS seq BIGSERIAL;
+
+CREATE INDEX IF NOT EXISTS submissions_feed_idx
+    ON submissions (app_id, xact, seq);
+
+INSERT INTO schema_migrations (version) VALUES (14) ON CONFLICT DO NOTHING;
@@ -0,0 +1,14 @@
+-- Keep idempotency claims when their submission goes away (burn,
+-- withdrawal, erasure, retention): a retry inside the replay window must
+-- not store a consumed submission again. A NULL submission_id is such a
+-- tombstone; it expires with the claim's ts like any other.
+ALTER TABLE idempotency_keys ALTER COLUMN submission_id DROP NOT NULL;
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_submission_id_fkey;
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_submission_id_fkey
+    FOREIGN KEY (submission_id) REFERENCES submissions(id) ON DELETE SET NULL;
+
+-- the SET NULL looks claims up by submission on every delete
+CREATE INDEX IF NOT EXISTS idempotency_keys_submission_idx
+    ON idempotency_keys (submission_id);
+
+INSERT INTO schema_migrations (version) VALUES (15) ON CONFLICT DO NOTHING;
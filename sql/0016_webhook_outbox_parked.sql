@@ -0,0 +1,6 @@
+-- The janitor deletes outbox entries parked for longer than
+-- WEBHOOK_PARKED_TTL; this keeps it from scanning the live queue.
+CREATE INDEX IF NOT EXISTS webhook_outbox_parked_idx
+    ON webhook_outbox (dead_at) WHERE dead_at IS NOT NULL;
+
+INSERT INTO schema_migrations (version) VALUES (16) ON CONFLICT DO NOTHING;
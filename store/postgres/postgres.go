@@ -2,8 +2,15 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/collapsinghierarchy/noisybuffer/model"
@@ -14,25 +21,273 @@ type pgStore struct{ db *pgxpool.Pool }
 
 func NewStore(db *pgxpool.Pool) store.Store { return &pgStore{db: db} }
 
+// -------- health -----------------------------------------------------------
+
+// SchemaVersion is the newest sql/ migration this package relies on.
+const SchemaVersion = 16
+
+// ErrSchemaOutdated means the database lacks migrations this build needs.
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// Ping checks connectivity and that migrations up to SchemaVersion ran.
+func (p *pgStore) Ping(ctx context.Context) error {
+	var version int
+	err := p.db.QueryRow(ctx,
+		`SELECT COALESCE(max(version), 0) FROM schema_migrations`).Scan(&version)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" { // undefined_table
+		return fmt.Errorf("%w: no schema_migrations table", ErrSchemaOutdated)
+	}
+	if err != nil {
+		return err
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("%w: at %d, need %d", ErrSchemaOutdated, version, SchemaVersion)
+	}
+	return nil
+}
+
 // -------- submissions ------------------------------------------------------
 
-func (p *pgStore) InsertSubmission(ctx context.Context, s *model.Submission) error {
-	_, err := p.db.Exec(ctx,
-		`INSERT INTO submissions (id, app_id, kid, ts, blob)
-         VALUES ($1,$2,$3,$4,$5)`,
-		s.ID, s.AppID, s.Kid, s.TS, s.Blob)
+// insertSubmissionSQL writes one submission and its webhook outbox entries
+// in a single statement.
+const insertSubmissionSQL = `
+    WITH s AS (
+        INSERT INTO submissions (id, app_id, kid, ts, blob, burn, delete_token_hash, upload_token_id, form_id)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+        RETURNING id, app_id, kid, ts, octet_length(blob) AS size, form_id
+    )
+    INSERT INTO webhook_outbox (webhook_id, submission_id, app_id, kid, ts, size, form_id)
+    SELECT w.id, s.id, s.app_id, s.kid, s.ts, s.size, s.form_id
+    FROM s JOIN webhooks w ON w.app_id = s.app_id
+     AND (w.form_id IS NULL OR w.form_id = s.form_id)`
+
+// enqueueWebhooksSQL fills the outbox for submissions that are already
+// written in the current transaction.
+const enqueueWebhooksSQL = `
+    INSERT INTO webhook_outbox (webhook_id, submission_id, app_id, kid, ts, size, form_id)
+    SELECT w.id, s.id, s.app_id, s.kid, s.ts, octet_length(s.blob), s.form_id
+    FROM submissions s JOIN webhooks w ON w.app_id = s.app_id
+     AND (w.form_id IS NULL OR w.form_id = s.form_id)
+    WHERE s.id = ANY($1)`
+
+// formID maps the empty form ID to NULL.
+func formID(id string) *string {
+	if id == "" {
+		return nil
+	}
+	return &id
+}
+
+// uploadTokenErr maps the errors raised by the submissions_upload_token
+// trigger (sql/0010_upload_tokens.sql).
+func uploadTokenErr(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "NB001":
+			return store.ErrUploadTokenRequired
+		case "NB002":
+			return store.ErrUploadTokenSpent
+		}
+	}
 	return err
 }
 
+func (p *pgStore) InsertSubmission(ctx context.Context, s *model.Submission) error {
+	_, err := p.db.Exec(ctx, insertSubmissionSQL,
+		s.ID, s.AppID, s.Kid, s.TS, s.Blob, s.Burn, s.DeleteTokenHash, s.UploadTokenID, formID(s.FormID))
+	return uploadTokenErr(err)
+}
+
+// InsertSubmissions bulk-loads subs with a single COPY inside a transaction
+// that also enqueues their webhook events.
+func (p *pgStore) InsertSubmissions(ctx context.Context, subs []*model.Submission) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ids := make([]uuid.UUID, len(subs))
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"submissions"},
+		[]string{"id", "app_id", "kid", "ts", "blob", "burn", "delete_token_hash", "upload_token_id", "form_id"},
+		pgx.CopyFromSlice(len(subs), func(i int) ([]any, error) {
+			s := subs[i]
+			ids[i] = s.ID
+			return []any{s.ID, s.AppID, int16(s.Kid), s.TS, s.Blob, s.Burn, s.DeleteTokenHash, s.UploadTokenID, formID(s.FormID)}, nil
+		}))
+	if err != nil {
+		return uploadTokenErr(err)
+	}
+	if _, err := tx.Exec(ctx, enqueueWebhooksSQL, ids); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// InsertSubmissionOnce writes the submission and claims the idempotency key
+// in one transaction. The claim uses ON CONFLICT, so a concurrent push with
+// the same key blocks on the row lock and then sees the winner's claim; an
+// expired claim (older than notBefore) is taken over instead.
+//
+// A retry whose upload token the original push used up fails the insert
+// before the claim is reached; it is answered from the claim all the same.
+// Deleting a submission only clears its claim's submission_id (see
+// sql/0015_idempotency_tombstones.sql), which replayClaim reports as
+// store.ErrReplayGone.
+func (p *pgStore) InsertSubmissionOnce(
+	ctx context.Context, s *model.Submission, key string, notBefore time.Time,
+) (bool, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, insertSubmissionSQL,
+		s.ID, s.AppID, s.Kid, s.TS, s.Blob, s.Burn, s.DeleteTokenHash, s.UploadTokenID, formID(s.FormID)); err != nil {
+		err = uploadTokenErr(err)
+		if !errors.Is(err, store.ErrUploadTokenSpent) {
+			return false, err
+		}
+		if err := tx.Rollback(ctx); err != nil {
+			return false, err
+		}
+		claimErr := p.replayClaim(ctx, s, key, notBefore)
+		if errors.Is(claimErr, pgx.ErrNoRows) {
+			return false, err
+		}
+		return claimErr == nil, claimErr
+	}
+
+	var claimed bool
+	err = tx.QueryRow(ctx, `
+        INSERT INTO idempotency_keys (app_id, key, submission_id, ts)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (app_id, key) DO UPDATE
+          SET submission_id = EXCLUDED.submission_id,
+              ts            = EXCLUDED.ts
+          WHERE idempotency_keys.ts < $5
+        RETURNING true
+    `, s.AppID, key, s.ID, s.TS, notBefore).Scan(&claimed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// live claim by an earlier push: drop our row, hand back the original
+		if err := tx.Rollback(ctx); err != nil {
+			return false, err
+		}
+		err = p.replayClaim(ctx, s, key, notBefore)
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, tx.Commit(ctx)
+}
+
+func (p *pgStore) IdempotencyClaim(ctx context.Context, appID uuid.UUID, key string, notBefore time.Time) (uuid.UUID, time.Time, error) {
+	s := &model.Submission{AppID: appID}
+	err := p.replayClaim(ctx, s, key, notBefore)
+	return s.ID, s.TS, err
+}
+
+// replayClaim copies the submission claimed by key at or after notBefore
+// into s, or fails with store.ErrReplayGone if it has been deleted.
+func (p *pgStore) replayClaim(ctx context.Context, s *model.Submission, key string, notBefore time.Time) error {
+	var id *uuid.UUID
+	err := p.db.QueryRow(ctx,
+		`SELECT submission_id, ts FROM idempotency_keys
+         WHERE app_id=$1 AND key=$2 AND ts >= $3`, s.AppID, key, notBefore).
+		Scan(&id, &s.TS)
+	if err != nil {
+		return err
+	}
+	if id == nil {
+		return store.ErrReplayGone
+	}
+	s.ID = *id
+	return nil
+}
+
 func (p *pgStore) StreamSubmissions(
-	ctx context.Context, appID uuid.UUID,
+	ctx context.Context, appID uuid.UUID, formID string,
 	fn func(*model.Submission) error,
 ) error {
 	rows, err := p.db.Query(ctx,
-		`SELECT id, app_id, kid, ts, blob
+		`SELECT id, app_id, kid, ts, blob, burn, upload_token_id, COALESCE(form_id, '')
          FROM submissions
-         WHERE app_id=$1
-         ORDER BY ts ASC`, appID)
+         WHERE app_id=$1 AND ($2 = '' OR form_id = $2)
+         ORDER BY ts ASC`, appID, formID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s model.Submission
+		if err := rows.Scan(&s.ID, &s.AppID, &s.Kid, &s.TS, &s.Blob, &s.Burn, &s.UploadTokenID, &s.FormID); err != nil {
+			return err
+		}
+		if err := fn(&s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// feedHorizonSQL returns the oldest transaction that was writing to
+// submissions when the statement's snapshot was taken and still is, or
+// the snapshot's xmax if there is none. Every submission insert writes
+// that table first, so its relation lock (in pg_locks, joined to the
+// writer's own transaction ID lock) is held before it has an ID at all.
+// Writers that finished between the snapshot and the pg_locks read are
+// no longer counted, but they have committed before the query returns.
+// Transactions that never write submissions do not hold the feed back.
+const feedHorizonSQL = `
+    WITH snap AS (SELECT pg_current_snapshot() AS s),
+    writers AS (
+        SELECT x.transactionid
+          FROM pg_locks r
+          JOIN pg_locks x ON x.pid = r.pid AND x.locktype = 'transactionid'
+                         AND x.mode = 'ExclusiveLock' AND x.granted
+         WHERE r.locktype = 'relation' AND r.relation = 'submissions'::regclass
+           AND r.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+           AND r.mode = 'RowExclusiveLock' AND r.granted
+    )
+    SELECT COALESCE(
+        (SELECT min(x) FROM snap, pg_snapshot_xip(snap.s) AS x
+          WHERE x::xid IN (SELECT transactionid FROM writers)),
+        (SELECT pg_snapshot_xmax(s) FROM snap))::text::bigint`
+
+// feedHorizon returns the transaction ID below which no submission can
+// still commit. It must run in a statement of its own, before the read
+// that relies on it, so that read's snapshot sees every writer it passed.
+func (p *pgStore) feedHorizon(ctx context.Context) (xact int64, err error) {
+	err = p.db.QueryRow(ctx, feedHorizonSQL).Scan(&xact)
+	return xact, err
+}
+
+// StreamSubmissionsAfter orders the feed by (xact, seq), see
+// sql/0014_feed_pos.sql. Rows of transactions at or past the feed horizon
+// are held back: an older writer may still be running and commit rows
+// that sort before them.
+func (p *pgStore) StreamSubmissionsAfter(
+	ctx context.Context, appID uuid.UUID, after model.FeedPos,
+	fn func(*model.Submission) error,
+) error {
+	horizon, err := p.feedHorizon(ctx)
+	if err != nil {
+		return err
+	}
+	rows, err := p.db.Query(ctx,
+		`SELECT id, app_id, kid, ts, blob, burn, upload_token_id, COALESCE(form_id, ''),
+                xact::text::bigint, seq
+         FROM submissions
+         WHERE app_id=$1 AND (xact, seq) > ($2::text::xid8, $3)
+           AND xact < $4::text::xid8
+         ORDER BY xact ASC, seq ASC`,
+		appID, strconv.FormatInt(after.Xact, 10), after.Seq, strconv.FormatInt(horizon, 10))
 	if err != nil {
 		return err
 	}
@@ -40,7 +295,8 @@ func (p *pgStore) StreamSubmissions(
 
 	for rows.Next() {
 		var s model.Submission
-		if err := rows.Scan(&s.ID, &s.AppID, &s.Kid, &s.TS, &s.Blob); err != nil {
+		if err := rows.Scan(&s.ID, &s.AppID, &s.Kid, &s.TS, &s.Blob, &s.Burn, &s.UploadTokenID, &s.FormID,
+			&s.Pos.Xact, &s.Pos.Seq); err != nil {
 			return err
 		}
 		if err := fn(&s); err != nil {
@@ -50,6 +306,94 @@ func (p *pgStore) StreamSubmissions(
 	return rows.Err()
 }
 
+// FeedHead sits just before the feed horizon: anything committed from now
+// on comes from the writer there or a newer one. Rows that newer writers
+// committed a moment ago sort after it too.
+func (p *pgStore) FeedHead(ctx context.Context) (pos model.FeedPos, err error) {
+	pos.Xact, err = p.feedHorizon(ctx)
+	return pos, err
+}
+
+// holdSQL is a CTE "p" with the burn_after_reading and legal_hold flags
+// of app $1, both false without a policy.
+const holdSQL = `
+    p AS (
+        SELECT COALESCE(bool_or(burn_after_reading), false) AS burn,
+               COALESCE(bool_or(legal_hold), false)         AS hold
+          FROM retention_policies WHERE app_id = $1
+    )`
+
+// settleWebhooksSQL follows a CTE "gone" (id, app_id, kid, ts, form_id) of deleted
+// submissions, in the same statement so a crash can never leave their
+// webhook events behind. Outbox entries never claimed are dropped (the
+// receiver never saw the ciphertext); every other webhook with
+// include_blob gets a "submission.deleted" tombstone.
+const settleWebhooksSQL = `
+    dropped AS (
+        DELETE FROM webhook_outbox o USING gone
+         WHERE o.submission_id = gone.id AND o.attempts = 0
+        RETURNING o.webhook_id, o.submission_id
+    ),
+    tombstones AS (
+        INSERT INTO webhook_outbox (webhook_id, submission_id, app_id, kid, ts, size, form_id, event)
+        SELECT w.id, g.id, g.app_id, g.kid, g.ts, 0, g.form_id, 'submission.deleted'
+          FROM gone g JOIN webhooks w ON w.app_id = g.app_id AND w.include_blob
+                                     AND (w.form_id IS NULL OR w.form_id = g.form_id)
+         WHERE NOT EXISTS (SELECT 1 FROM dropped d
+                            WHERE d.webhook_id = w.id AND d.submission_id = g.id)
+    )`
+
+// ackSubmissionsSQL burns or acknowledges in one statement.
+const ackSubmissionsSQL = `WITH` + holdSQL + `,
+    gone AS (
+        DELETE FROM submissions s USING p
+         WHERE s.app_id = $1 AND s.id = ANY($2)
+           AND NOT p.hold AND (s.burn OR p.burn)
+        RETURNING s.id, s.app_id, s.kid, s.ts, s.form_id
+    ),` + settleWebhooksSQL + `,
+    acked AS (
+        UPDATE submissions SET acked_at = now()
+         WHERE app_id = $1 AND id = ANY($2) AND acked_at IS NULL
+           AND id NOT IN (SELECT id FROM gone)
+        RETURNING id
+    )
+    SELECT (SELECT count(*) FROM acked), (SELECT count(*) FROM gone)`
+
+func (p *pgStore) AckSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (acked, burned int64, err error) {
+	err = p.db.QueryRow(ctx, ackSubmissionsSQL, appID, ids).Scan(&acked, &burned)
+	return acked, burned, err
+}
+
+// notifyChannel is raised by the submissions_notify trigger with the app
+// ID as payload (see sql/0004_notify.sql).
+const notifyChannel = "nb_submissions"
+
+// ListenSubmissions takes one connection out of the pool for LISTEN, so
+// pushes handled by any replica reach this process's live feed. The
+// connection is closed rather than returned, so no pooled connection is
+// left subscribed.
+func (p *pgStore) ListenSubmissions(ctx context.Context, fn func(appID uuid.UUID)) error {
+	pooled, err := p.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if appID, err := uuid.Parse(n.Payload); err == nil {
+			fn(appID)
+		}
+	}
+}
+
 // -------- apps / key registry ---------------------------------------------
 
 func (p *pgStore) AppExists(ctx context.Context, id uuid.UUID) (bool, error) {
@@ -59,15 +403,19 @@ func (p *pgStore) AppExists(ctx context.Context, id uuid.UUID) (bool, error) {
 	return exists, err
 }
 
-func (p *pgStore) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub []byte) error {
-	_, err := p.db.Exec(ctx, `
-        INSERT INTO apps (id, name, pubkey, kid)
-        VALUES ($1, '', $2, $3)            -- <- supply a non-NULL name
-        ON CONFLICT (id) DO UPDATE
-          SET pubkey = EXCLUDED.pubkey,
-              kid    = EXCLUDED.kid
-    `, appID, pub, kid)
-	return err
+func (p *pgStore) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub, ownerHash []byte) error {
+	tag, err := p.db.Exec(ctx, `
+        INSERT INTO apps (id, name, pubkey, kid, owner_token_hash)
+        VALUES ($1, '', $2, $3, $4)        -- <- supply a non-NULL name
+        ON CONFLICT (id) DO NOTHING
+    `, appID, pub, kid, ownerHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return store.ErrAppExists
+	}
+	return nil
 }
 
 func (p *pgStore) GetKey(ctx context.Context, appID uuid.UUID) (uint8, []byte, error) {
@@ -78,3 +426,686 @@ func (p *pgStore) GetKey(ctx context.Context, appID uuid.UUID) (uint8, []byte, e
 		Scan(&kid, &pub)
 	return kid, pub, err
 }
+
+func (p *pgStore) OwnerTokenHash(ctx context.Context, appID uuid.UUID) ([]byte, error) {
+	var hash []byte
+	err := p.db.QueryRow(ctx,
+		`SELECT owner_token_hash FROM apps WHERE id=$1`, appID).Scan(&hash)
+	return hash, err
+}
+
+func (p *pgStore) SetOwnerTokenHash(ctx context.Context, appID uuid.UUID, hash []byte) error {
+	tag, err := p.db.Exec(ctx, `UPDATE apps SET owner_token_hash = $2 WHERE id = $1`, appID, hash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// -------- retention ---------------------------------------------------------
+
+func (p *pgStore) GetRetention(ctx context.Context, appID uuid.UUID) (*model.Retention, error) {
+	r := &model.Retention{AppID: appID}
+	var maxAge int64
+	err := p.db.QueryRow(ctx, `
+        SELECT COALESCE(r.max_age_secs, 0), COALESCE(r.max_count, 0),
+               COALESCE(r.delete_acked, false), COALESCE(r.legal_hold, false),
+               COALESCE(r.burn_after_reading, false)
+        FROM apps a LEFT JOIN retention_policies r ON r.app_id = a.id
+        WHERE a.id=$1`, appID).
+		Scan(&maxAge, &r.MaxCount, &r.DeleteAcked, &r.LegalHold, &r.BurnAfterReading)
+	r.MaxAge = time.Duration(maxAge) * time.Second
+	return r, err
+}
+
+func (p *pgStore) SetRetention(ctx context.Context, r *model.Retention) error {
+	_, err := p.db.Exec(ctx, `
+        INSERT INTO retention_policies
+               (app_id, max_age_secs, max_count, delete_acked, burn_after_reading)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (app_id) DO UPDATE
+          SET max_age_secs       = EXCLUDED.max_age_secs,
+              max_count          = EXCLUDED.max_count,
+              delete_acked       = EXCLUDED.delete_acked,
+              burn_after_reading = EXCLUDED.burn_after_reading,
+              updated_at         = now()
+    `, r.AppID, int64(r.MaxAge/time.Second), r.MaxCount, r.DeleteAcked, r.BurnAfterReading)
+	return err
+}
+
+func (p *pgStore) SetLegalHold(ctx context.Context, appID uuid.UUID, hold bool) error {
+	tag, err := p.db.Exec(ctx, `
+        INSERT INTO retention_policies (app_id, legal_hold)
+        SELECT id, $2 FROM apps WHERE id=$1
+        ON CONFLICT (app_id) DO UPDATE
+          SET legal_hold = EXCLUDED.legal_hold,
+              updated_at = now()
+    `, appID, hold)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (p *pgStore) ListRetention(ctx context.Context) ([]*model.Retention, error) {
+	rows, err := p.db.Query(ctx, `
+        SELECT app_id, '', max_age_secs, max_count, delete_acked, legal_hold, burn_after_reading
+        FROM retention_policies
+        WHERE max_age_secs > 0 OR max_count > 0 OR delete_acked
+        UNION ALL
+        SELECT f.app_id, f.id, f.max_age_secs, f.max_count, false,
+               COALESCE(r.legal_hold, false), false
+        FROM app_forms f LEFT JOIN retention_policies r ON r.app_id = f.app_id
+        WHERE f.max_age_secs > 0 OR f.max_count > 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*model.Retention
+	for rows.Next() {
+		var r model.Retention
+		var maxAge int64
+		if err := rows.Scan(&r.AppID, &r.FormID, &maxAge, &r.MaxCount, &r.DeleteAcked, &r.LegalHold, &r.BurnAfterReading); err != nil {
+			return nil, err
+		}
+		r.MaxAge = time.Duration(maxAge) * time.Second
+		out = append(out, &r)
+	}
+	return out, rows.Err()
+}
+
+// purgeSubmissionsSQL deletes one retention batch and settles its webhook
+// events like every other deletion. The legal hold is re-checked in the
+// statement itself, so a hold placed after the janitor read the policy
+// still wins; SKIP LOCKED keeps replicas from fighting over rows.
+const purgeSubmissionsSQL = `
+    WITH victims AS (
+        SELECT s.id FROM submissions s
+         WHERE s.app_id = $1 AND ($6 = '' OR s.form_id = $6)
+           AND NOT EXISTS (SELECT 1 FROM retention_policies h
+                            WHERE h.app_id = $1 AND h.legal_hold)
+           AND (   ($2::timestamptz IS NOT NULL AND s.ts < $2)
+                OR ($3 AND s.acked_at IS NOT NULL)
+                OR ($4 > 0 AND s.id NOT IN (
+                       SELECT id FROM submissions
+                        WHERE app_id = $1 AND ($6 = '' OR form_id = $6)
+                        ORDER BY ts DESC, id DESC LIMIT $4)))
+         LIMIT $5
+         FOR UPDATE SKIP LOCKED
+    ),
+    gone AS (
+        DELETE FROM submissions s USING victims v
+         WHERE s.id = v.id
+        RETURNING s.id, s.app_id, s.kid, s.ts, s.form_id
+    ),` + settleWebhooksSQL + `
+    SELECT count(*) FROM gone`
+
+// PurgeSubmissions deletes one batch; see purgeSubmissionsSQL.
+func (p *pgStore) PurgeSubmissions(ctx context.Context, r *model.Retention, now time.Time, limit int) (int64, error) {
+	var cutoff *time.Time
+	if r.MaxAge > 0 {
+		t := now.Add(-r.MaxAge)
+		cutoff = &t
+	}
+	var n int64
+	err := p.db.QueryRow(ctx, purgeSubmissionsSQL,
+		r.AppID, cutoff, r.DeleteAcked, r.MaxCount, limit, r.FormID).Scan(&n)
+	return n, err
+}
+
+// -------- erasure -----------------------------------------------------------
+
+func (p *pgStore) DeleteSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error) {
+	var hold bool
+	var deleted []uuid.UUID
+	err := p.db.QueryRow(ctx, `WITH`+holdSQL+`,
+        gone AS (
+            DELETE FROM submissions s USING p
+             WHERE s.app_id = $1 AND s.id = ANY($2) AND NOT p.hold
+            RETURNING s.id, s.app_id, s.kid, s.ts, s.form_id
+        ),`+settleWebhooksSQL+`
+        SELECT (SELECT hold FROM p), ARRAY(SELECT id FROM gone)`, appID, ids).
+		Scan(&hold, &deleted)
+	if err != nil {
+		return nil, err
+	}
+	if hold {
+		return nil, store.ErrLegalHold
+	}
+	return deleted, nil
+}
+
+func (p *pgStore) DeleteSubmissionsBetween(ctx context.Context, appID uuid.UUID, from, to time.Time) (int64, error) {
+	var lo, hi *time.Time
+	if !from.IsZero() {
+		lo = &from
+	}
+	if !to.IsZero() {
+		hi = &to
+	}
+	var hold bool
+	var n int64
+	err := p.db.QueryRow(ctx, `WITH`+holdSQL+`,
+        gone AS (
+            DELETE FROM submissions s USING p
+             WHERE s.app_id = $1 AND NOT p.hold
+               AND ($2::timestamptz IS NULL OR s.ts >= $2)
+               AND ($3::timestamptz IS NULL OR s.ts <  $3)
+            RETURNING s.id, s.app_id, s.kid, s.ts, s.form_id
+        ),`+settleWebhooksSQL+`
+        SELECT (SELECT hold FROM p), (SELECT count(*) FROM gone)`, appID, lo, hi).
+		Scan(&hold, &n)
+	if err != nil {
+		return 0, err
+	}
+	if hold {
+		return 0, store.ErrLegalHold
+	}
+	return n, nil
+}
+
+// DeleteSubmissionByToken looks the row up by primary key and token hash;
+// the app, and with it the legal hold, is only known once both match.
+func (p *pgStore) DeleteSubmissionByToken(ctx context.Context, id uuid.UUID, tokenHash []byte) (uuid.UUID, error) {
+	var appID uuid.UUID
+	var hold, deleted bool
+	err := p.db.QueryRow(ctx, `
+        WITH target AS (
+            SELECT id, app_id FROM submissions
+             WHERE id = $1 AND delete_token_hash = $2
+        ),
+        held AS (
+            SELECT EXISTS (SELECT 1 FROM target t JOIN retention_policies r
+                             ON r.app_id = t.app_id AND r.legal_hold) AS hold
+        ),
+        gone AS (
+            DELETE FROM submissions s USING held
+             WHERE s.id IN (SELECT id FROM target) AND NOT held.hold
+            RETURNING s.id, s.app_id, s.kid, s.ts, s.form_id
+        ),`+settleWebhooksSQL+`
+        SELECT COALESCE((SELECT app_id FROM target), '00000000-0000-0000-0000-000000000000'),
+               (SELECT hold FROM held), EXISTS (SELECT 1 FROM gone)`, id, tokenHash).
+		Scan(&appID, &hold, &deleted)
+	switch {
+	case err != nil:
+		return uuid.Nil, err
+	case hold:
+		return appID, store.ErrLegalHold
+	case !deleted:
+		return uuid.Nil, pgx.ErrNoRows
+	}
+	return appID, nil
+}
+
+// DeleteApp relies on ON DELETE CASCADE from apps (and from webhooks to
+// their outbox). The counts come from the statement's own snapshot.
+func (p *pgStore) DeleteApp(ctx context.Context, appID uuid.UUID) (*model.AppErasure, error) {
+	var hold, gone bool
+	var e model.AppErasure
+	err := p.db.QueryRow(ctx, `WITH`+holdSQL+`,
+        gone AS (
+            DELETE FROM apps a USING p
+             WHERE a.id = $1 AND NOT p.hold
+            RETURNING a.id
+        )
+        SELECT p.hold, EXISTS (SELECT 1 FROM gone),
+               (SELECT count(*) FROM submissions      WHERE app_id = $1),
+               (SELECT count(*) FROM webhooks         WHERE app_id = $1),
+               (SELECT count(*) FROM idempotency_keys WHERE app_id = $1)
+          FROM p`, appID).
+		Scan(&hold, &gone, &e.Submissions, &e.Webhooks, &e.IdempotencyKeys)
+	if err != nil {
+		return nil, err
+	}
+	if hold {
+		return nil, store.ErrLegalHold
+	}
+	if !gone {
+		return nil, pgx.ErrNoRows
+	}
+	return &e, nil
+}
+
+// -------- replies -----------------------------------------------------------
+
+// InsertReply serialises writers of one mailbox with a transaction-scoped
+// advisory lock, so the ownership and size checks cannot race.
+func (p *pgStore) InsertReply(ctx context.Context, r *model.Reply, max int) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))`, r.Mailbox); err != nil {
+		return err
+	}
+	var foreign, n int
+	err = tx.QueryRow(ctx, `
+        SELECT count(*) FILTER (WHERE app_id <> $2), count(*)
+          FROM replies WHERE mailbox = $1 AND expires_at > $3`,
+		r.Mailbox, r.AppID, r.TS).Scan(&foreign, &n)
+	if err != nil {
+		return err
+	}
+	switch {
+	case foreign > 0:
+		return store.ErrMailboxTaken
+	case n >= max:
+		return store.ErrMailboxFull
+	}
+	if _, err := tx.Exec(ctx, `
+        INSERT INTO replies (id, app_id, mailbox, ts, blob, expires_at)
+        VALUES ($1,$2,$3,$4,$5,$6)`,
+		r.ID, r.AppID, r.Mailbox, r.TS, r.Blob, r.ExpiresAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *pgStore) ListReplies(ctx context.Context, mailbox uuid.UUID, now time.Time) ([]*model.Reply, error) {
+	rows, err := p.db.Query(ctx, `
+        SELECT id, app_id, mailbox, ts, blob, expires_at FROM replies
+         WHERE mailbox = $1 AND expires_at > $2
+         ORDER BY ts, id`, mailbox, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*model.Reply
+	for rows.Next() {
+		var r model.Reply
+		if err := rows.Scan(&r.ID, &r.AppID, &r.Mailbox, &r.TS, &r.Blob, &r.ExpiresAt); err != nil {
+			return nil, err
+		}
+		out = append(out, &r)
+	}
+	return out, rows.Err()
+}
+
+func (p *pgStore) DeleteReplies(ctx context.Context, mailbox uuid.UUID) (int64, error) {
+	tag, err := p.db.Exec(ctx, `DELETE FROM replies WHERE mailbox = $1`, mailbox)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (p *pgStore) PurgeReplies(ctx context.Context, now time.Time, limit int) (int64, error) {
+	tag, err := p.db.Exec(ctx, `
+        DELETE FROM replies WHERE id IN (
+            SELECT id FROM replies WHERE expires_at <= $1
+             LIMIT $2 FOR UPDATE SKIP LOCKED)`, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// -------- upload tokens -----------------------------------------------------
+
+const uploadTokenColumns = `id, app_id, token_hash, label, max_uses, uses, expires_at, created_at, revoked_at`
+
+func scanUploadToken(row pgx.Row) (*model.UploadToken, error) {
+	var t model.UploadToken
+	err := row.Scan(&t.ID, &t.AppID, &t.TokenHash, &t.Label, &t.MaxUses, &t.Uses,
+		&t.ExpiresAt, &t.CreatedAt, &t.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (p *pgStore) CreateUploadToken(ctx context.Context, t *model.UploadToken) error {
+	_, err := p.db.Exec(ctx, `
+        INSERT INTO upload_tokens (id, app_id, token_hash, label, max_uses, expires_at, created_at)
+        VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		t.ID, t.AppID, t.TokenHash, t.Label, t.MaxUses, t.ExpiresAt, t.CreatedAt)
+	return err
+}
+
+func (p *pgStore) UploadTokenByHash(ctx context.Context, appID uuid.UUID, hash []byte) (*model.UploadToken, error) {
+	return scanUploadToken(p.db.QueryRow(ctx,
+		`SELECT `+uploadTokenColumns+` FROM upload_tokens
+          WHERE app_id = $1 AND token_hash = $2`, appID, hash))
+}
+
+func (p *pgStore) ListUploadTokens(ctx context.Context, appID uuid.UUID) ([]*model.UploadToken, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT `+uploadTokenColumns+` FROM upload_tokens
+          WHERE app_id = $1 ORDER BY created_at, id`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*model.UploadToken
+	for rows.Next() {
+		t, err := scanUploadToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (p *pgStore) RevokeUploadToken(ctx context.Context, appID, id uuid.UUID, at time.Time) error {
+	tag, err := p.db.Exec(ctx, `
+        UPDATE upload_tokens SET revoked_at = COALESCE(revoked_at, $3)
+         WHERE app_id = $1 AND id = $2`, appID, id, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (p *pgStore) DeadDrop(ctx context.Context, appID uuid.UUID) (bool, error) {
+	var on bool
+	err := p.db.QueryRow(ctx, `SELECT dead_drop FROM apps WHERE id = $1`, appID).Scan(&on)
+	return on, err
+}
+
+func (p *pgStore) SetDeadDrop(ctx context.Context, appID uuid.UUID, on bool) error {
+	tag, err := p.db.Exec(ctx, `UPDATE apps SET dead_drop = $2 WHERE id = $1`, appID, on)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// -------- padding -----------------------------------------------------------
+
+func (p *pgStore) Padding(ctx context.Context, appID uuid.UUID) (model.Padding, error) {
+	var pad model.Padding
+	err := p.db.QueryRow(ctx, `
+        SELECT padding_mode, padding_size FROM apps WHERE id = $1`, appID).Scan(&pad.Mode, &pad.Size)
+	return pad, err
+}
+
+func (p *pgStore) SetPadding(ctx context.Context, appID uuid.UUID, pad model.Padding) error {
+	tag, err := p.db.Exec(ctx, `
+        UPDATE apps SET padding_mode = $2, padding_size = $3 WHERE id = $1`, appID, pad.Mode, pad.Size)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// -------- forms -------------------------------------------------------------
+
+// SaveFormDef locks the app row first, in a statement of its own, so the
+// version read by the insert sees any concurrent save that got there first.
+func (p *pgStore) SaveFormDef(ctx context.Context, d *model.FormDef) error {
+	doc, err := json.Marshal(d.Form)
+	if err != nil {
+		return err
+	}
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var one int
+	if err := tx.QueryRow(ctx, `SELECT 1 FROM apps WHERE id = $1 FOR UPDATE`, d.AppID).Scan(&one); err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, `
+        INSERT INTO form_definitions (app_id, version, definition, created_at)
+        SELECT $1, COALESCE(max(version), 0) + 1, $2, $3
+          FROM form_definitions WHERE app_id = $1
+        RETURNING version`, d.AppID, doc, d.CreatedAt).Scan(&d.Version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *pgStore) FormDef(ctx context.Context, appID uuid.UUID, version int) (*model.FormDef, error) {
+	d := model.FormDef{AppID: appID}
+	var doc []byte
+	err := p.db.QueryRow(ctx, `
+        SELECT version, definition, created_at FROM form_definitions
+         WHERE app_id = $1 AND ($2 = 0 OR version = $2)
+         ORDER BY version DESC LIMIT 1`, appID, version).
+		Scan(&d.Version, &doc, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(doc, &d.Form); err != nil {
+		return nil, fmt.Errorf("form %s v%d: %w", appID, d.Version, err)
+	}
+	return &d, nil
+}
+
+// -------- form IDs ----------------------------------------------------------
+
+func (p *pgStore) PutAppForm(ctx context.Context, f *model.AppForm) error {
+	err := p.db.QueryRow(ctx, `
+        INSERT INTO app_forms (app_id, id, label, max_age_secs, max_count, rate_minute, rate_burst, created_at)
+        SELECT id, $2, $3, $4, $5, $6, $7, $8 FROM apps WHERE id = $1
+        ON CONFLICT (app_id, id) DO UPDATE
+          SET label        = EXCLUDED.label,
+              max_age_secs = EXCLUDED.max_age_secs,
+              max_count    = EXCLUDED.max_count,
+              rate_minute  = EXCLUDED.rate_minute,
+              rate_burst   = EXCLUDED.rate_burst
+        RETURNING created_at`,
+		f.AppID, f.ID, f.Label, int64(f.MaxAge/time.Second), f.MaxCount, f.RateMin, f.RateBurst, f.CreatedAt).
+		Scan(&f.CreatedAt)
+	return err
+}
+
+const appFormColumns = `app_id, id, label, max_age_secs, max_count, rate_minute, rate_burst, created_at`
+
+func scanAppForm(row pgx.Row) (*model.AppForm, error) {
+	var f model.AppForm
+	var maxAge int64
+	if err := row.Scan(&f.AppID, &f.ID, &f.Label, &maxAge, &f.MaxCount, &f.RateMin, &f.RateBurst, &f.CreatedAt); err != nil {
+		return nil, err
+	}
+	f.MaxAge = time.Duration(maxAge) * time.Second
+	return &f, nil
+}
+
+func (p *pgStore) AppForm(ctx context.Context, appID uuid.UUID, id string) (*model.AppForm, error) {
+	return scanAppForm(p.db.QueryRow(ctx,
+		`SELECT `+appFormColumns+` FROM app_forms WHERE app_id=$1 AND id=$2`, appID, id))
+}
+
+func (p *pgStore) ListAppForms(ctx context.Context, appID uuid.UUID) ([]*model.AppForm, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT `+appFormColumns+` FROM app_forms WHERE app_id=$1 ORDER BY id`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*model.AppForm
+	for rows.Next() {
+		f, err := scanAppForm(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// DeleteAppForm drops the form's webhooks in the same statement; their
+// pending events go with them (ON DELETE CASCADE on the outbox).
+func (p *pgStore) DeleteAppForm(ctx context.Context, appID uuid.UUID, id string) error {
+	var n int64
+	err := p.db.QueryRow(ctx, `
+        WITH hooks AS (
+            DELETE FROM webhooks WHERE app_id = $1 AND form_id = $2
+        ),
+        gone AS (
+            DELETE FROM app_forms WHERE app_id = $1 AND id = $2 RETURNING id
+        )
+        SELECT count(*) FROM gone`, appID, id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// -------- webhooks ----------------------------------------------------------
+
+// CreateWebhook counts and inserts under a transaction-scoped advisory lock
+// on the app, like InsertReply, so concurrent creates cannot overshoot max.
+func (p *pgStore) CreateWebhook(ctx context.Context, w *model.Webhook, max int) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`SELECT pg_advisory_xact_lock(hashtextextended('webhooks:' || $1::text, 0))`, w.AppID); err != nil {
+		return err
+	}
+	var n int
+	if err := tx.QueryRow(ctx,
+		`SELECT count(*) FROM webhooks WHERE app_id = $1`, w.AppID).Scan(&n); err != nil {
+		return err
+	}
+	if n >= max {
+		return store.ErrTooManyWebhooks
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO webhooks (id, app_id, url, secret, include_blob, form_id, created_at)
+         VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		w.ID, w.AppID, w.URL, w.Secret, w.IncludeBlob, formID(w.FormID), w.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *pgStore) ListWebhooks(ctx context.Context, appID uuid.UUID) ([]*model.Webhook, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT id, app_id, url, secret, include_blob, COALESCE(form_id, ''), created_at
+         FROM webhooks
+         WHERE app_id=$1
+         ORDER BY created_at ASC`, appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []*model.Webhook
+	for rows.Next() {
+		var w model.Webhook
+		if err := rows.Scan(&w.ID, &w.AppID, &w.URL, &w.Secret, &w.IncludeBlob, &w.FormID, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, &w)
+	}
+	return hooks, rows.Err()
+}
+
+func (p *pgStore) DeleteWebhook(ctx context.Context, appID, id uuid.UUID) error {
+	tag, err := p.db.Exec(ctx,
+		`DELETE FROM webhooks WHERE app_id=$1 AND id=$2`, appID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries leases due entries with SKIP LOCKED, so several
+// noisybufferd replicas can drain the outbox side by side.
+func (p *pgStore) ClaimWebhookDeliveries(
+	ctx context.Context, limit int, lease time.Duration,
+) ([]*model.WebhookDelivery, error) {
+	rows, err := p.db.Query(ctx, `
+        UPDATE webhook_outbox o
+           SET attempts     = o.attempts + 1,
+               next_attempt = now() + make_interval(secs => $2)
+          FROM webhooks w
+         WHERE w.id = o.webhook_id
+           AND o.id IN (
+                SELECT id FROM webhook_outbox
+                 WHERE dead_at IS NULL AND next_attempt <= now()
+                 ORDER BY next_attempt
+                 LIMIT $1
+                 FOR UPDATE SKIP LOCKED)
+        RETURNING o.id, o.event, o.attempts, o.submission_id, o.app_id, o.kid, o.ts, o.size,
+                  COALESCE(o.form_id, ''),
+                  w.id, w.url, w.secret, w.include_blob,
+                  CASE WHEN w.include_blob AND o.event = 'submission.created'
+                       THEN (SELECT blob FROM submissions WHERE id = o.submission_id)
+                  END
+    `, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*model.WebhookDelivery
+	for rows.Next() {
+		var d model.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.Attempts,
+			&d.Submission.ID, &d.Submission.AppID, &d.Submission.Kid, &d.Submission.TS, &d.Size,
+			&d.Submission.FormID,
+			&d.Webhook.ID, &d.Webhook.URL, &d.Webhook.Secret, &d.Webhook.IncludeBlob,
+			&d.Submission.Blob); err != nil {
+			return nil, err
+		}
+		d.Webhook.AppID = d.Submission.AppID
+		out = append(out, &d)
+	}
+	return out, rows.Err()
+}
+
+func (p *pgStore) CompleteWebhookDelivery(ctx context.Context, id int64) error {
+	_, err := p.db.Exec(ctx, `DELETE FROM webhook_outbox WHERE id=$1`, id)
+	return err
+}
+
+func (p *pgStore) FailWebhookDelivery(ctx context.Context, id int64, next time.Time, reason string) error {
+	if next.IsZero() {
+		_, err := p.db.Exec(ctx,
+			`UPDATE webhook_outbox SET dead_at = now(), last_error = $2 WHERE id=$1`,
+			id, reason)
+		return err
+	}
+	_, err := p.db.Exec(ctx,
+		`UPDATE webhook_outbox SET next_attempt = $2, last_error = $3 WHERE id=$1`,
+		id, next, reason)
+	return err
+}
+
+func (p *pgStore) PurgeWebhookDeliveries(ctx context.Context, before time.Time, limit int) (int64, error) {
+	tag, err := p.db.Exec(ctx, `
+        DELETE FROM webhook_outbox WHERE id IN (
+            SELECT id FROM webhook_outbox WHERE dead_at < $1
+             LIMIT $2 FOR UPDATE SKIP LOCKED)`, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,344 @@
+package postgres_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/store/postgres"
+)
+
+// EnvTestDatabase names a Postgres database the tests may create scratch
+// schemas in. Unset, they are skipped.
+const EnvTestDatabase = "NB_TEST_DATABASE_URL"
+
+// newTestStore migrates a fresh schema and returns a store bound to it.
+func newTestStore(t *testing.T) (store.Store, *pgxpool.Pool) {
+	t.Helper()
+	url := os.Getenv(EnvTestDatabase)
+	if url == "" {
+		t.Skip(EnvTestDatabase + " not set")
+	}
+	ctx := context.Background()
+	suffix := make([]byte, 6)
+	_, _ = rand.Read(suffix)
+	schema := "nbtest_" + hex.EncodeToString(suffix)
+
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		pool.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+		pool.Close()
+	})
+
+	files, err := filepath.Glob("../../sql/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("migrations: %v", err)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		body, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pool.Exec(ctx, string(body)); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(f), err)
+		}
+	}
+	return postgres.NewStore(pool), pool
+}
+
+// settleFixture registers an app with an include_blob webhook and two
+// submissions, the first of which has already been handed to the
+// receiver once.
+func settleFixture(t *testing.T, st store.Store, burn bool) (appID, sent, unsent uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+	appID = uuid.New()
+	if err := st.RegisterKey(ctx, appID, 1, []byte("pub"), []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	hook := &model.Webhook{
+		ID: uuid.New(), AppID: appID, URL: "https://example.com/hook",
+		Secret: []byte("s"), IncludeBlob: true, CreatedAt: time.Now().UTC(),
+	}
+	if err := st.CreateWebhook(ctx, hook, 10); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Now().UTC().Add(-time.Hour).Truncate(time.Microsecond)
+	for i, id := range []*uuid.UUID{&sent, &unsent} {
+		*id = uuid.New()
+		sub := &model.Submission{ID: *id, AppID: appID, Kid: 1, TS: ts.Add(time.Duration(i) * time.Second), Blob: []byte("ct"), Burn: burn}
+		if err := st.InsertSubmission(ctx, sub); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			due, err := st.ClaimWebhookDeliveries(ctx, 1, time.Hour)
+			if err != nil || len(due) != 1 || due[0].Submission.ID != sent {
+				t.Fatalf("claim: %v %v", due, err)
+			}
+		}
+	}
+	return appID, sent, unsent
+}
+
+// checkSettled expects no creation event left for either submission and a
+// tombstone only for the one the receiver may hold.
+func checkSettled(t *testing.T, pool *pgxpool.Pool, sent, unsent uuid.UUID) {
+	t.Helper()
+	count := func(id uuid.UUID, event string) (n int) {
+		err := pool.QueryRow(context.Background(),
+			`SELECT count(*) FROM webhook_outbox WHERE submission_id = $1 AND event = $2`, id, event).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(unsent, "submission.created"); n != 0 {
+		t.Errorf("undelivered creation event still queued (%d)", n)
+	}
+	if n := count(unsent, "submission.deleted"); n != 0 {
+		t.Errorf("tombstone queued for an event never sent (%d)", n)
+	}
+	if n := count(sent, "submission.deleted"); n != 1 {
+		t.Errorf("tombstones for the delivered submission: %d, want 1", n)
+	}
+}
+
+func TestAckSubmissions_SettlesWebhooks(t *testing.T) {
+	st, pool := newTestStore(t)
+	appID, sent, unsent := settleFixture(t, st, true)
+
+	_, burned, err := st.AckSubmissions(context.Background(), appID, []uuid.UUID{sent, unsent})
+	if err != nil || burned != 2 {
+		t.Fatalf("AckSubmissions: burned %d, %v", burned, err)
+	}
+	checkSettled(t, pool, sent, unsent)
+}
+
+func TestPurgeSubmissions_SettlesWebhooks(t *testing.T) {
+	st, pool := newTestStore(t)
+	appID, sent, unsent := settleFixture(t, st, false)
+
+	r := &model.Retention{AppID: appID, MaxAge: time.Minute}
+	n, err := st.PurgeSubmissions(context.Background(), r, time.Now(), 100)
+	if err != nil || n != 2 {
+		t.Fatalf("PurgeSubmissions: %d, %v", n, err)
+	}
+	checkSettled(t, pool, sent, unsent)
+}
+
+func TestStreamSubmissionsAfter_LateCommit(t *testing.T) {
+	st, pool := newTestStore(t)
+	ctx := context.Background()
+	appID := uuid.New()
+	if err := st.RegisterKey(ctx, appID, 1, []byte("pub"), []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	head, err := st.FeedHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed := func(after model.FeedPos) (ids []uuid.UUID, last model.FeedPos) {
+		err := st.StreamSubmissionsAfter(ctx, appID, after, func(s *model.Submission) error {
+			ids, last = append(ids, s.ID), s.Pos
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, last
+	}
+
+	// the slow push takes its timestamp first but commits last
+	now := time.Now().UTC()
+	slow, fast := uuid.New(), uuid.New()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO submissions (id, app_id, kid, ts, blob) VALUES ($1, $2, 1, $3, 'ct')`,
+		slow, appID, now); err != nil {
+		t.Fatal(err)
+	}
+	sub := &model.Submission{ID: fast, AppID: appID, Kid: 1, TS: now.Add(time.Second), Blob: []byte("ct")}
+	if err := st.InsertSubmission(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := feed(head); len(ids) != 0 {
+		t.Fatalf("streamed %v while an older insert was still running", ids)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ids, last := feed(head)
+	if len(ids) != 2 || ids[0] != slow || ids[1] != fast {
+		t.Fatalf("feed = %v, want [%v %v]", ids, slow, fast)
+	}
+	if ids, _ := feed(last); len(ids) != 0 {
+		t.Errorf("replayed %v past the last cursor", ids)
+	}
+}
+
+func TestInsertSubmissionOnce_ReplayAfterBurn(t *testing.T) {
+	st, pool := newTestStore(t)
+	ctx := context.Background()
+	appID := uuid.New()
+	if err := st.RegisterKey(ctx, appID, 1, []byte("pub"), []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	push := func() (*model.Submission, bool, error) {
+		s := &model.Submission{ID: uuid.New(), AppID: appID, Kid: 1, TS: time.Now().UTC(), Blob: []byte("ct"), Burn: true}
+		replayed, err := st.InsertSubmissionOnce(ctx, s, "once", s.TS.Add(-time.Hour))
+		return s, replayed, err
+	}
+	first, replayed, err := push()
+	if err != nil || replayed {
+		t.Fatalf("first push: replayed %v, %v", replayed, err)
+	}
+	if _, burned, err := st.AckSubmissions(ctx, appID, []uuid.UUID{first.ID}); err != nil || burned != 1 {
+		t.Fatalf("AckSubmissions: burned %d, %v", burned, err)
+	}
+
+	if _, replayed, err := push(); !errors.Is(err, store.ErrReplayGone) || replayed {
+		t.Fatalf("replay after burn: replayed %v, %v", replayed, err)
+	}
+	var n int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM submissions WHERE app_id = $1`, appID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("replay stored %d submissions", n)
+	}
+}
+
+func TestStreamSubmissionsAfter_IgnoresOtherTransactions(t *testing.T) {
+	st, pool := newTestStore(t)
+	ctx := context.Background()
+	appID := uuid.New()
+	if err := st.RegisterKey(ctx, appID, 1, []byte("pub"), []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	head, err := st.FeedHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an old transaction that never touches submissions, like a pg_dump
+	other, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Rollback(ctx)
+	if _, err := other.Exec(ctx, `SELECT pg_current_xact_id()`); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := &model.Submission{ID: uuid.New(), AppID: appID, Kid: 1, TS: time.Now().UTC(), Blob: []byte("ct")}
+	if err := st.InsertSubmission(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	var ids []uuid.UUID
+	err = st.StreamSubmissionsAfter(ctx, appID, head, func(s *model.Submission) error {
+		ids = append(ids, s.ID)
+		return nil
+	})
+	if err != nil || len(ids) != 1 || ids[0] != sub.ID {
+		t.Fatalf("feed = %v, %v; want [%v] while an unrelated transaction is open", ids, err, sub.ID)
+	}
+}
+
+func TestCreateWebhook_ConcurrentCap(t *testing.T) {
+	st, _ := newTestStore(t)
+	ctx := context.Background()
+	appID := uuid.New()
+	if err := st.RegisterKey(ctx, appID, 1, []byte("pub"), []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	const max = 3
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- st.CreateWebhook(ctx, &model.Webhook{
+				ID: uuid.New(), AppID: appID, URL: "https://example.com/hook",
+				Secret: []byte("s"), CreatedAt: time.Now().UTC(),
+			}, max)
+		}()
+	}
+	created := 0
+	for i := 0; i < cap(errs); i++ {
+		switch err := <-errs; {
+		case err == nil:
+			created++
+		case !errors.Is(err, store.ErrTooManyWebhooks):
+			t.Fatal(err)
+		}
+	}
+	hooks, err := st.ListWebhooks(ctx, appID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != max || len(hooks) != max {
+		t.Errorf("created %d, stored %d webhooks; want %d", created, len(hooks), max)
+	}
+}
+
+func TestPurgeWebhookDeliveries_OnlyParked(t *testing.T) {
+	st, _ := newTestStore(t)
+	ctx := context.Background()
+	appID := uuid.New()
+	if err := st.RegisterKey(ctx, appID, 1, []byte("pub"), []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	hook := &model.Webhook{ID: uuid.New(), AppID: appID, URL: "https://example.com/hook", Secret: []byte("s"), CreatedAt: time.Now().UTC()}
+	if err := st.CreateWebhook(ctx, hook, 10); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		sub := &model.Submission{ID: uuid.New(), AppID: appID, Kid: 1, TS: time.Now().UTC(), Blob: []byte("ct")}
+		if err := st.InsertSubmission(ctx, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ds, err := st.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	if err != nil || len(ds) != 2 {
+		t.Fatalf("claimed %d deliveries, %v", len(ds), err)
+	}
+	if err := st.FailWebhookDelivery(ctx, ds[0].ID, time.Time{}, "gave up"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.FailWebhookDelivery(ctx, ds[1].ID, time.Now().Add(time.Hour), "retry"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.PurgeWebhookDeliveries(ctx, time.Now().Add(-time.Hour), 10); err != nil || n != 0 {
+		t.Fatalf("purged %d, %v; want nothing parked that long ago", n, err)
+	}
+	n, err := st.PurgeWebhookDeliveries(ctx, time.Now().Add(time.Minute), 10)
+	if err != nil || n != 1 {
+		t.Fatalf("purged %d, %v; want the one parked delivery", n, err)
+	}
+}
@@ -2,18 +2,226 @@ package store
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/collapsinghierarchy/noisybuffer/model"
 	"github.com/google/uuid"
 )
 
+// ErrAppExists is returned by RegisterKey for an ID that is taken; the
+// existing key and owner token stay as they are.
+var ErrAppExists = errors.New("app already registered")
+
+// ErrReplayGone is returned by InsertSubmissionOnce for a live claim whose
+// submission has been deleted since; nothing is written.
+var ErrReplayGone = errors.New("idempotency key's submission was deleted")
+
+// ErrLegalHold is returned by owner deletions while the app is under legal
+// hold; nothing is deleted then.
+var ErrLegalHold = errors.New("app is under legal hold")
+
+// Upload token errors, returned by the submission inserts: an app in
+// dead-drop mode got a submission without a token, or the token given is
+// used up, expired, revoked or not the app's.
+var (
+	ErrUploadTokenRequired = errors.New("app only accepts uploads with a token")
+	ErrUploadTokenSpent    = errors.New("upload token is no longer valid")
+)
+
+// Reply mailbox errors; see InsertReply.
+var (
+	ErrMailboxTaken = errors.New("mailbox belongs to another app")
+	ErrMailboxFull  = errors.New("mailbox is full")
+)
+
+// ErrTooManyWebhooks is returned by CreateWebhook when the app already has
+// its maximum number of webhooks.
+var ErrTooManyWebhooks = errors.New("too many webhooks for this app")
+
 type Store interface {
+	// Ping reports whether the store is reachable and its schema is what
+	// this build expects. Readiness probes call it.
+	Ping(ctx context.Context) error
+
 	// submissions
+	//
+	// Every insert redeems s.UploadTokenID, if set, in its own transaction
+	// and fails with ErrUploadTokenSpent if it cannot; without one, apps in
+	// dead-drop mode refuse the submission with ErrUploadTokenRequired.
 	InsertSubmission(ctx context.Context, s *model.Submission) error
-	StreamSubmissions(ctx context.Context, appID uuid.UUID, fn func(*model.Submission) error) error
+	// InsertSubmissions stores all of subs atomically: either every row is
+	// written or none is.
+	InsertSubmissions(ctx context.Context, subs []*model.Submission) error
+	// InsertSubmissionOnce stores s unless key was already claimed for
+	// s.AppID at or after notBefore. On such a replay nothing is written,
+	// s.ID and s.TS are overwritten with the original submission's and
+	// replayed is true. Concurrent calls with the same key must not both
+	// insert. Claims outlive their submission: once it is deleted, a
+	// replay gets ErrReplayGone.
+	InsertSubmissionOnce(ctx context.Context, s *model.Submission, key string, notBefore time.Time) (replayed bool, err error)
+	// IdempotencyClaim returns the submission key was claimed for by
+	// appID at or after notBefore, without writing anything: sql.ErrNoRows
+	// if there is no such claim, ErrReplayGone if the submission has been
+	// deleted since.
+	IdempotencyClaim(ctx context.Context, appID uuid.UUID, key string, notBefore time.Time) (id uuid.UUID, ts time.Time, err error)
+	// StreamSubmissions calls fn for the app's submissions, oldest first;
+	// a non-empty formID restricts them to that form.
+	StreamSubmissions(ctx context.Context, appID uuid.UUID, formID string, fn func(*model.Submission) error) error
+	// StreamSubmissionsAfter is StreamSubmissions restricted to rows past
+	// after in feed order, with Pos set. It must only return rows no
+	// uncommitted insert can sort before: once a position was handed out,
+	// nothing may appear behind it. Only transactions writing submissions
+	// may hold rows back, so one left open (idle in transaction after an
+	// insert, say) stalls the feed until it ends; unrelated transactions
+	// on the same database must not.
+	StreamSubmissionsAfter(ctx context.Context, appID uuid.UUID, after model.FeedPos, fn func(*model.Submission) error) error
+	// FeedHead returns a position that every submission committed from now
+	// on sorts after.
+	FeedHead(ctx context.Context) (model.FeedPos, error)
+
+	// AckSubmissions marks the app's submissions in ids as acknowledged by
+	// the owner. Submissions to be burnt after reading (their own flag or
+	// the app's policy) are deleted instead, unless the app is under legal
+	// hold, together with their undelivered webhook events (their
+	// idempotency claims stay, see InsertSubmissionOnce); webhooks that may already hold their ciphertext get a
+	// "submission.deleted" event in the same transaction. acked counts the
+	// kept submissions that were not acknowledged before, burned the
+	// deleted ones.
+	AckSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (acked, burned int64, err error)
+
+	// retention
+	//
+	// GetRetention returns a zero policy for apps without one and
+	// sql.ErrNoRows for unknown apps. SetRetention leaves LegalHold as it
+	// is; only SetLegalHold changes it.
+	GetRetention(ctx context.Context, appID uuid.UUID) (*model.Retention, error)
+	SetRetention(ctx context.Context, r *model.Retention) error
+	SetLegalHold(ctx context.Context, appID uuid.UUID, hold bool) error // sql.ErrNoRows if absent
+	// ListRetention returns the stored policies that ask for deletion,
+	// including those suspended by a legal hold: the apps' and, with
+	// FormID set and LegalHold copied from their app, the forms'.
+	ListRetention(ctx context.Context) ([]*model.Retention, error)
+	// PurgeSubmissions deletes at most limit submissions of r.AppID (of
+	// form r.FormID, if set) that r no longer allows as of now, and returns
+	// how many it deleted. Their webhook events are settled as in
+	// AckSubmissions. It deletes nothing while the app is under legal
+	// hold, even if r says otherwise.
+	PurgeSubmissions(ctx context.Context, r *model.Retention, now time.Time, limit int) (int64, error)
+
+	// erasure
+	//
+	// Owner-initiated deletions. They return ErrLegalHold instead of
+	// deleting anything while the app is under legal hold, and settle the
+	// deleted submissions' webhook events like burns in AckSubmissions.
+	//
+	// DeleteSubmissions returns the IDs in ids it deleted.
+	DeleteSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error)
+	// DeleteSubmissionsBetween deletes submissions with from <= ts < to; a
+	// zero bound is open.
+	DeleteSubmissionsBetween(ctx context.Context, appID uuid.UUID, from, to time.Time) (int64, error)
+	// DeleteSubmissionByToken deletes submission id if tokenHash is its
+	// deletion token hash, and returns its app. Unknown IDs and wrong
+	// tokens both give sql.ErrNoRows; ErrLegalHold only comes with a
+	// matching token.
+	DeleteSubmissionByToken(ctx context.Context, id uuid.UUID, tokenHash []byte) (appID uuid.UUID, err error)
+	// DeleteApp removes the app with its key, owner token, submissions,
+	// idempotency claims, webhooks and their outbox, and retention
+	// policy. sql.ErrNoRows if absent.
+	DeleteApp(ctx context.Context, appID uuid.UUID) (*model.AppErasure, error)
+
+	// replies
+	//
+	// InsertReply stores r in its mailbox. A mailbox belongs to the first
+	// app that replies to it: ErrMailboxTaken if unexpired replies of
+	// another app are in it, ErrMailboxFull if it already holds max
+	// unexpired replies. Concurrent inserts into one mailbox must not both
+	// pass these checks.
+	InsertReply(ctx context.Context, r *model.Reply, max int) error
+	// ListReplies returns the mailbox's replies that have not expired at
+	// now, oldest first.
+	ListReplies(ctx context.Context, mailbox uuid.UUID, now time.Time) ([]*model.Reply, error)
+	DeleteReplies(ctx context.Context, mailbox uuid.UUID) (int64, error)
+	// PurgeReplies deletes at most limit replies expired at now.
+	PurgeReplies(ctx context.Context, now time.Time, limit int) (int64, error)
+
+	// upload tokens
+	//
+	// UploadTokenByHash finds the app's token with that hash, usable or not;
+	// sql.ErrNoRows if there is none. RevokeUploadToken leaves the token in
+	// place, so submissions keep pointing at it.
+	CreateUploadToken(ctx context.Context, t *model.UploadToken) error
+	UploadTokenByHash(ctx context.Context, appID uuid.UUID, hash []byte) (*model.UploadToken, error)
+	ListUploadTokens(ctx context.Context, appID uuid.UUID) ([]*model.UploadToken, error)
+	RevokeUploadToken(ctx context.Context, appID, id uuid.UUID, at time.Time) error // sql.ErrNoRows if absent
+	// DeadDrop reports and SetDeadDrop switches whether the app accepts
+	// uploads only with a token; both give sql.ErrNoRows for unknown apps.
+	DeadDrop(ctx context.Context, appID uuid.UUID) (bool, error)
+	SetDeadDrop(ctx context.Context, appID uuid.UUID, on bool) error
+
+	// padding
+	//
+	// Padding reports and SetPadding replaces the app's padding policy;
+	// both give sql.ErrNoRows for unknown apps.
+	Padding(ctx context.Context, appID uuid.UUID) (model.Padding, error)
+	SetPadding(ctx context.Context, appID uuid.UUID, p model.Padding) error
+
+	// forms
+	//
+	// SaveFormDef stores d as the app's next version and sets d.Version;
+	// sql.ErrNoRows for unknown apps. FormDef returns the given version, or
+	// the newest for version 0; sql.ErrNoRows if there is none.
+	SaveFormDef(ctx context.Context, d *model.FormDef) error
+	FormDef(ctx context.Context, appID uuid.UUID, version int) (*model.FormDef, error)
+
+	// form IDs
+	//
+	// PutAppForm creates or replaces the form (CreatedAt is kept on
+	// replace); sql.ErrNoRows for unknown apps. AppForm gives sql.ErrNoRows
+	// if the app has no such form. DeleteAppForm removes its webhooks too,
+	// but not its submissions; sql.ErrNoRows if absent.
+	PutAppForm(ctx context.Context, f *model.AppForm) error
+	AppForm(ctx context.Context, appID uuid.UUID, id string) (*model.AppForm, error)
+	ListAppForms(ctx context.Context, appID uuid.UUID) ([]*model.AppForm, error)
+	DeleteAppForm(ctx context.Context, appID uuid.UUID, id string) error
 
 	// apps / keys
 	AppExists(ctx context.Context, id uuid.UUID) (bool, error)
-	RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub []byte) error
+	RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub, ownerHash []byte) error // ErrAppExists if taken
 	GetKey(ctx context.Context, appID uuid.UUID) (kid uint8, pub []byte, err error)
+	// OwnerTokenHash returns sql.ErrNoRows for unknown apps and a nil hash
+	// for apps registered before owner tokens existed.
+	OwnerTokenHash(ctx context.Context, appID uuid.UUID) ([]byte, error)
+	SetOwnerTokenHash(ctx context.Context, appID uuid.UUID, hash []byte) error // sql.ErrNoRows if absent
+
+	// webhooks
+	//
+	// Every submission insert must enqueue one outbox entry per webhook of
+	// the app in the same transaction, so no event is lost on a crash.
+	// Webhooks with a FormID only get the events of that form.
+	// CreateWebhook stores w unless its app already has max webhooks
+	// (ErrTooManyWebhooks). Concurrent creates for one app must not both
+	// pass the check.
+	CreateWebhook(ctx context.Context, w *model.Webhook, max int) error
+	ListWebhooks(ctx context.Context, appID uuid.UUID) ([]*model.Webhook, error)
+	DeleteWebhook(ctx context.Context, appID, id uuid.UUID) error // sql.ErrNoRows if absent
+	// ClaimWebhookDeliveries leases up to limit due outbox entries for lease
+	// and bumps their attempt counter. Entries whose lease runs out without
+	// being completed or failed become due again.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*model.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, id int64) error
+	// FailWebhookDelivery schedules a retry at next, or parks the entry for
+	// good when next is the zero time.
+	FailWebhookDelivery(ctx context.Context, id int64, next time.Time, reason string) error
+	// PurgeWebhookDeliveries deletes at most limit outbox entries parked
+	// before before.
+	PurgeWebhookDeliveries(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// Notifier is an optional Store capability for stores shared by several
+// noisybufferd processes. ListenSubmissions blocks until ctx is done or the
+// connection fails, calling fn with the app of every submission inserted
+// by any process.
+type Notifier interface {
+	ListenSubmissions(ctx context.Context, fn func(appID uuid.UUID)) error
 }
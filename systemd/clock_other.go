@@ -0,0 +1,6 @@
+//go:build !linux
+
+package systemd
+
+// monotonicUsec is only meaningful to systemd, which runs on Linux.
+func monotonicUsec() int64 { return 0 }
@@ -0,0 +1,126 @@
+// Package systemd implements the parts of the systemd service protocol
+// noisybufferd uses, without cgo or extra dependencies: inherited sockets
+// (LISTEN_FDS, see sd_listen_fds(3)), readiness and status notifications
+// (NOTIFY_SOCKET, sd_notify(3)) and the watchdog (WATCHDOG_USEC).
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first inherited descriptor, SD_LISTEN_FDS_START.
+const listenFDsStart = 3
+
+// Notification states understood by systemd.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Listeners returns the sockets passed by systemd socket activation, keyed
+// by FileDescriptorName= (systemd names unnamed ones after the unit). It
+// returns nil when the process was not socket-activated. The LISTEN_*
+// variables are cleared so child processes do not inherit them.
+func Listeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	out := make(map[string]net.Listener, n)
+	for i := range n {
+		fd := listenFDsStart + i
+		name := fmt.Sprintf("fd%d", fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close() // FileListener dups the descriptor (close-on-exec)
+		if err != nil {
+			return nil, fmt.Errorf("systemd: inherited %s: %w", name, err)
+		}
+		if _, dup := out[name]; dup {
+			return nil, fmt.Errorf("systemd: two inherited sockets named %q", name)
+		}
+		out[name] = l
+	}
+	return out, nil
+}
+
+// Notify sends state lines to the service manager. It reports false
+// without error when NOTIFY_SOCKET is unset, i.e. not running under
+// systemd with Type=notify.
+func Notify(states ...string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	if addr[0] == '@' { // abstract namespace
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("systemd: notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, fmt.Errorf("systemd: notify: %w", err)
+	}
+	return true, nil
+}
+
+// Status formats a free-form STATUS= line shown by systemctl status.
+func Status(s string) string { return "STATUS=" + s }
+
+// ReloadingNow is the state for Type=notify-reload, which wants the
+// CLOCK_MONOTONIC time alongside RELOADING=1.
+func ReloadingNow() string {
+	return Reloading + "\nMONOTONIC_USEC=" + strconv.FormatInt(monotonicUsec(), 10)
+}
+
+// WatchdogInterval returns WatchdogSec= when systemd expects keep-alive
+// pings from this process.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// RunWatchdog pings the watchdog at half the interval until ctx ends. A
+// wedged process stops pinging and systemd restarts it.
+func RunWatchdog(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			_, _ = Notify(Watchdog)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package systemd_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/systemd"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := systemd.Notify(systemd.Ready); sent || err != nil {
+		t.Fatalf("outside systemd: sent=%v err=%v", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if sent, err := systemd.Notify(systemd.Ready, systemd.Status("serving")); !sent || err != nil {
+		t.Fatalf("Notify: sent=%v err=%v", sent, err)
+	}
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=serving" {
+		t.Errorf("datagram = %q", got)
+	}
+	if r := systemd.ReloadingNow(); !strings.HasPrefix(r, "RELOADING=1\nMONOTONIC_USEC=") {
+		t.Errorf("ReloadingNow = %q", r)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if _, ok := systemd.WatchdogInterval(); ok {
+		t.Error("no watchdog expected without WATCHDOG_USEC")
+	}
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d, ok := systemd.WatchdogInterval(); !ok || d != 30*time.Second {
+		t.Errorf("WatchdogInterval = %v, %v", d, ok)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if _, ok := systemd.WatchdogInterval(); ok {
+		t.Error("watchdog meant for another process")
+	}
+}
+
+func TestListeners_NotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1") // someone else's sockets
+	t.Setenv("LISTEN_FDS", "1")
+	ls, err := systemd.Listeners()
+	if err != nil || ls != nil {
+		t.Fatalf("Listeners = %v, %v", ls, err)
+	}
+	if _, set := os.LookupEnv("LISTEN_FDS"); set {
+		t.Error("LISTEN_FDS should be cleared for child processes")
+	}
+}
@@ -0,0 +1,368 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+// WrapStore returns st with a client span around every call. Optional
+// capabilities of st (store.Notifier) are preserved.
+func WrapStore(st store.Store) store.Store {
+	s := &tracedStore{next: st, tracer: otel.Tracer("github.com/collapsinghierarchy/noisybuffer/store")}
+	if n, ok := st.(store.Notifier); ok {
+		return &tracedNotifier{tracedStore: s, notifier: n}
+	}
+	return s
+}
+
+type tracedStore struct {
+	next   store.Store
+	tracer trace.Tracer
+}
+
+func (s *tracedStore) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "store."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.operation.name", op))...))
+}
+
+func (s *tracedStore) Ping(ctx context.Context) (err error) {
+	ctx, span := s.start(ctx, "Ping")
+	defer func() { End(span, err) }()
+	return s.next.Ping(ctx)
+}
+
+func (s *tracedStore) InsertSubmission(ctx context.Context, sub *model.Submission) (err error) {
+	ctx, span := s.start(ctx, "InsertSubmission", attribute.Int("nb.blob.size", len(sub.Blob)))
+	defer func() { End(span, err) }()
+	return s.next.InsertSubmission(ctx, sub)
+}
+
+func (s *tracedStore) InsertSubmissions(ctx context.Context, subs []*model.Submission) (err error) {
+	ctx, span := s.start(ctx, "InsertSubmissions", attribute.Int("nb.batch.size", len(subs)))
+	defer func() { End(span, err) }()
+	return s.next.InsertSubmissions(ctx, subs)
+}
+
+func (s *tracedStore) IdempotencyClaim(ctx context.Context, appID uuid.UUID, key string, notBefore time.Time) (id uuid.UUID, ts time.Time, err error) {
+	ctx, span := s.start(ctx, "IdempotencyClaim")
+	defer func() { End(span, err) }()
+	return s.next.IdempotencyClaim(ctx, appID, key, notBefore)
+}
+
+func (s *tracedStore) InsertSubmissionOnce(ctx context.Context, sub *model.Submission, key string, notBefore time.Time) (replayed bool, err error) {
+	ctx, span := s.start(ctx, "InsertSubmissionOnce", attribute.Int("nb.blob.size", len(sub.Blob)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("nb.replayed", replayed))
+		End(span, err)
+	}()
+	return s.next.InsertSubmissionOnce(ctx, sub, key, notBefore)
+}
+
+func (s *tracedStore) StreamSubmissions(ctx context.Context, appID uuid.UUID, formID string, fn func(*model.Submission) error) (err error) {
+	ctx, span := s.start(ctx, "StreamSubmissions")
+	defer func() { End(span, err) }()
+	return s.next.StreamSubmissions(ctx, appID, formID, fn)
+}
+
+func (s *tracedStore) StreamSubmissionsAfter(ctx context.Context, appID uuid.UUID, after model.FeedPos, fn func(*model.Submission) error) (err error) {
+	ctx, span := s.start(ctx, "StreamSubmissionsAfter")
+	defer func() { End(span, err) }()
+	return s.next.StreamSubmissionsAfter(ctx, appID, after, fn)
+}
+
+func (s *tracedStore) FeedHead(ctx context.Context) (pos model.FeedPos, err error) {
+	ctx, span := s.start(ctx, "FeedHead")
+	defer func() { End(span, err) }()
+	return s.next.FeedHead(ctx)
+}
+
+func (s *tracedStore) DeleteSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (deleted []uuid.UUID, err error) {
+	ctx, span := s.start(ctx, "DeleteSubmissions")
+	defer func() {
+		span.SetAttributes(attribute.Int("nb.ids", len(ids)), attribute.Int("nb.deleted", len(deleted)))
+		End(span, err)
+	}()
+	return s.next.DeleteSubmissions(ctx, appID, ids)
+}
+
+func (s *tracedStore) DeleteSubmissionsBetween(ctx context.Context, appID uuid.UUID, from, to time.Time) (n int64, err error) {
+	ctx, span := s.start(ctx, "DeleteSubmissionsBetween")
+	defer func() {
+		span.SetAttributes(attribute.Int64("nb.deleted", n))
+		End(span, err)
+	}()
+	return s.next.DeleteSubmissionsBetween(ctx, appID, from, to)
+}
+
+func (s *tracedStore) DeleteSubmissionByToken(ctx context.Context, id uuid.UUID, tokenHash []byte) (appID uuid.UUID, err error) {
+	ctx, span := s.start(ctx, "DeleteSubmissionByToken")
+	defer func() { End(span, err) }()
+	return s.next.DeleteSubmissionByToken(ctx, id, tokenHash)
+}
+
+func (s *tracedStore) DeleteApp(ctx context.Context, appID uuid.UUID) (e *model.AppErasure, err error) {
+	ctx, span := s.start(ctx, "DeleteApp")
+	defer func() { End(span, err) }()
+	return s.next.DeleteApp(ctx, appID)
+}
+
+func (s *tracedStore) InsertReply(ctx context.Context, r *model.Reply, max int) (err error) {
+	ctx, span := s.start(ctx, "InsertReply", attribute.Int("nb.blob.size", len(r.Blob)))
+	defer func() { End(span, err) }()
+	return s.next.InsertReply(ctx, r, max)
+}
+
+func (s *tracedStore) ListReplies(ctx context.Context, mailbox uuid.UUID, now time.Time) (rs []*model.Reply, err error) {
+	ctx, span := s.start(ctx, "ListReplies")
+	defer func() {
+		span.SetAttributes(attribute.Int("nb.replies", len(rs)))
+		End(span, err)
+	}()
+	return s.next.ListReplies(ctx, mailbox, now)
+}
+
+func (s *tracedStore) DeleteReplies(ctx context.Context, mailbox uuid.UUID) (n int64, err error) {
+	ctx, span := s.start(ctx, "DeleteReplies")
+	defer func() {
+		span.SetAttributes(attribute.Int64("nb.deleted", n))
+		End(span, err)
+	}()
+	return s.next.DeleteReplies(ctx, mailbox)
+}
+
+func (s *tracedStore) PurgeReplies(ctx context.Context, now time.Time, limit int) (n int64, err error) {
+	ctx, span := s.start(ctx, "PurgeReplies")
+	defer func() {
+		span.SetAttributes(attribute.Int64("nb.deleted", n))
+		End(span, err)
+	}()
+	return s.next.PurgeReplies(ctx, now, limit)
+}
+
+func (s *tracedStore) PurgeWebhookDeliveries(ctx context.Context, before time.Time, limit int) (n int64, err error) {
+	ctx, span := s.start(ctx, "PurgeWebhookDeliveries")
+	defer func() {
+		span.SetAttributes(attribute.Int64("nb.deleted", n))
+		End(span, err)
+	}()
+	return s.next.PurgeWebhookDeliveries(ctx, before, limit)
+}
+
+func (s *tracedStore) CreateUploadToken(ctx context.Context, t *model.UploadToken) (err error) {
+	ctx, span := s.start(ctx, "CreateUploadToken")
+	defer func() { End(span, err) }()
+	return s.next.CreateUploadToken(ctx, t)
+}
+
+func (s *tracedStore) UploadTokenByHash(ctx context.Context, appID uuid.UUID, hash []byte) (t *model.UploadToken, err error) {
+	ctx, span := s.start(ctx, "UploadTokenByHash")
+	defer func() { End(span, err) }()
+	return s.next.UploadTokenByHash(ctx, appID, hash)
+}
+
+func (s *tracedStore) ListUploadTokens(ctx context.Context, appID uuid.UUID) (ts []*model.UploadToken, err error) {
+	ctx, span := s.start(ctx, "ListUploadTokens")
+	defer func() { End(span, err) }()
+	return s.next.ListUploadTokens(ctx, appID)
+}
+
+func (s *tracedStore) RevokeUploadToken(ctx context.Context, appID, id uuid.UUID, at time.Time) (err error) {
+	ctx, span := s.start(ctx, "RevokeUploadToken")
+	defer func() { End(span, err) }()
+	return s.next.RevokeUploadToken(ctx, appID, id, at)
+}
+
+func (s *tracedStore) DeadDrop(ctx context.Context, appID uuid.UUID) (on bool, err error) {
+	ctx, span := s.start(ctx, "DeadDrop")
+	defer func() { End(span, err) }()
+	return s.next.DeadDrop(ctx, appID)
+}
+
+func (s *tracedStore) SetDeadDrop(ctx context.Context, appID uuid.UUID, on bool) (err error) {
+	ctx, span := s.start(ctx, "SetDeadDrop")
+	defer func() { End(span, err) }()
+	return s.next.SetDeadDrop(ctx, appID, on)
+}
+
+func (s *tracedStore) Padding(ctx context.Context, appID uuid.UUID) (pad model.Padding, err error) {
+	ctx, span := s.start(ctx, "Padding")
+	defer func() { End(span, err) }()
+	return s.next.Padding(ctx, appID)
+}
+
+func (s *tracedStore) SetPadding(ctx context.Context, appID uuid.UUID, pad model.Padding) (err error) {
+	ctx, span := s.start(ctx, "SetPadding")
+	defer func() { End(span, err) }()
+	return s.next.SetPadding(ctx, appID, pad)
+}
+
+func (s *tracedStore) SaveFormDef(ctx context.Context, d *model.FormDef) (err error) {
+	ctx, span := s.start(ctx, "SaveFormDef")
+	defer func() { End(span, err) }()
+	return s.next.SaveFormDef(ctx, d)
+}
+
+func (s *tracedStore) FormDef(ctx context.Context, appID uuid.UUID, version int) (d *model.FormDef, err error) {
+	ctx, span := s.start(ctx, "FormDef")
+	defer func() { End(span, err) }()
+	return s.next.FormDef(ctx, appID, version)
+}
+
+func (s *tracedStore) PutAppForm(ctx context.Context, f *model.AppForm) (err error) {
+	ctx, span := s.start(ctx, "PutAppForm")
+	defer func() { End(span, err) }()
+	return s.next.PutAppForm(ctx, f)
+}
+
+func (s *tracedStore) AppForm(ctx context.Context, appID uuid.UUID, id string) (f *model.AppForm, err error) {
+	ctx, span := s.start(ctx, "AppForm")
+	defer func() { End(span, err) }()
+	return s.next.AppForm(ctx, appID, id)
+}
+
+func (s *tracedStore) ListAppForms(ctx context.Context, appID uuid.UUID) (fs []*model.AppForm, err error) {
+	ctx, span := s.start(ctx, "ListAppForms")
+	defer func() { End(span, err) }()
+	return s.next.ListAppForms(ctx, appID)
+}
+
+func (s *tracedStore) DeleteAppForm(ctx context.Context, appID uuid.UUID, id string) (err error) {
+	ctx, span := s.start(ctx, "DeleteAppForm")
+	defer func() { End(span, err) }()
+	return s.next.DeleteAppForm(ctx, appID, id)
+}
+
+func (s *tracedStore) AppExists(ctx context.Context, id uuid.UUID) (ok bool, err error) {
+	ctx, span := s.start(ctx, "AppExists")
+	defer func() { End(span, err) }()
+	return s.next.AppExists(ctx, id)
+}
+
+func (s *tracedStore) RegisterKey(ctx context.Context, appID uuid.UUID, kid uint8, pub, ownerHash []byte) (err error) {
+	ctx, span := s.start(ctx, "RegisterKey")
+	defer func() { End(span, err) }()
+	return s.next.RegisterKey(ctx, appID, kid, pub, ownerHash)
+}
+
+func (s *tracedStore) GetKey(ctx context.Context, appID uuid.UUID) (kid uint8, pub []byte, err error) {
+	ctx, span := s.start(ctx, "GetKey")
+	defer func() { End(span, err) }()
+	return s.next.GetKey(ctx, appID)
+}
+
+func (s *tracedStore) OwnerTokenHash(ctx context.Context, appID uuid.UUID) (h []byte, err error) {
+	ctx, span := s.start(ctx, "OwnerTokenHash")
+	defer func() { End(span, err) }()
+	return s.next.OwnerTokenHash(ctx, appID)
+}
+
+func (s *tracedStore) SetOwnerTokenHash(ctx context.Context, appID uuid.UUID, hash []byte) (err error) {
+	ctx, span := s.start(ctx, "SetOwnerTokenHash")
+	defer func() { End(span, err) }()
+	return s.next.SetOwnerTokenHash(ctx, appID, hash)
+}
+
+func (s *tracedStore) CreateWebhook(ctx context.Context, w *model.Webhook, max int) (err error) {
+	ctx, span := s.start(ctx, "CreateWebhook")
+	defer func() { End(span, err) }()
+	return s.next.CreateWebhook(ctx, w, max)
+}
+
+func (s *tracedStore) ListWebhooks(ctx context.Context, appID uuid.UUID) (ws []*model.Webhook, err error) {
+	ctx, span := s.start(ctx, "ListWebhooks")
+	defer func() { End(span, err) }()
+	return s.next.ListWebhooks(ctx, appID)
+}
+
+func (s *tracedStore) AckSubmissions(ctx context.Context, appID uuid.UUID, ids []uuid.UUID) (acked, burned int64, err error) {
+	ctx, span := s.start(ctx, "AckSubmissions")
+	defer func() {
+		span.SetAttributes(attribute.Int("nb.ids", len(ids)),
+			attribute.Int64("nb.acked", acked), attribute.Int64("nb.burned", burned))
+		End(span, err)
+	}()
+	return s.next.AckSubmissions(ctx, appID, ids)
+}
+
+func (s *tracedStore) GetRetention(ctx context.Context, appID uuid.UUID) (r *model.Retention, err error) {
+	ctx, span := s.start(ctx, "GetRetention")
+	defer func() { End(span, err) }()
+	return s.next.GetRetention(ctx, appID)
+}
+
+func (s *tracedStore) SetRetention(ctx context.Context, r *model.Retention) (err error) {
+	ctx, span := s.start(ctx, "SetRetention")
+	defer func() { End(span, err) }()
+	return s.next.SetRetention(ctx, r)
+}
+
+func (s *tracedStore) SetLegalHold(ctx context.Context, appID uuid.UUID, hold bool) (err error) {
+	ctx, span := s.start(ctx, "SetLegalHold")
+	defer func() { End(span, err) }()
+	return s.next.SetLegalHold(ctx, appID, hold)
+}
+
+func (s *tracedStore) ListRetention(ctx context.Context) (rs []*model.Retention, err error) {
+	ctx, span := s.start(ctx, "ListRetention")
+	defer func() {
+		span.SetAttributes(attribute.Int("nb.policies", len(rs)))
+		End(span, err)
+	}()
+	return s.next.ListRetention(ctx)
+}
+
+func (s *tracedStore) PurgeSubmissions(ctx context.Context, r *model.Retention, now time.Time, limit int) (n int64, err error) {
+	ctx, span := s.start(ctx, "PurgeSubmissions")
+	defer func() {
+		span.SetAttributes(attribute.Int64("nb.deleted", n))
+		End(span, err)
+	}()
+	return s.next.PurgeSubmissions(ctx, r, now, limit)
+}
+
+func (s *tracedStore) DeleteWebhook(ctx context.Context, appID, id uuid.UUID) (err error) {
+	ctx, span := s.start(ctx, "DeleteWebhook")
+	defer func() { End(span, err) }()
+	return s.next.DeleteWebhook(ctx, appID, id)
+}
+
+func (s *tracedStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (ds []*model.WebhookDelivery, err error) {
+	ctx, span := s.start(ctx, "ClaimWebhookDeliveries")
+	defer func() {
+		span.SetAttributes(attribute.Int("nb.claimed", len(ds)))
+		End(span, err)
+	}()
+	return s.next.ClaimWebhookDeliveries(ctx, limit, lease)
+}
+
+func (s *tracedStore) CompleteWebhookDelivery(ctx context.Context, id int64) (err error) {
+	ctx, span := s.start(ctx, "CompleteWebhookDelivery")
+	defer func() { End(span, err) }()
+	return s.next.CompleteWebhookDelivery(ctx, id)
+}
+
+func (s *tracedStore) FailWebhookDelivery(ctx context.Context, id int64, next time.Time, reason string) (err error) {
+	ctx, span := s.start(ctx, "FailWebhookDelivery")
+	defer func() { End(span, err) }()
+	return s.next.FailWebhookDelivery(ctx, id, next, reason)
+}
+
+// tracedNotifier keeps store.Notifier visible through the decorator. The
+// listener lives as long as the process, so it gets no span.
+type tracedNotifier struct {
+	*tracedStore
+	notifier store.Notifier
+}
+
+func (s *tracedNotifier) ListenSubmissions(ctx context.Context, fn func(appID uuid.UUID)) error {
+	return s.notifier.ListenSubmissions(ctx, fn)
+}
@@ -0,0 +1,84 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/tracing"
+)
+
+type fakeStore struct{ store.Store }
+
+func (fakeStore) AppExists(ctx context.Context, id uuid.UUID) (bool, error) { return true, nil }
+
+type fakeNotifier struct{ fakeStore }
+
+func (fakeNotifier) ListenSubmissions(ctx context.Context, fn func(uuid.UUID)) error { return nil }
+
+// record installs an in-memory tracer provider for the test.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestMiddleware_ContinuesTraceAndNamesRoute(t *testing.T) {
+	rec := record(t)
+	st := tracing.WrapStore(fakeStore{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /apps/{appID}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = st.AppExists(r.Context(), uuid.New())
+		w.WriteHeader(http.StatusTeapot)
+	})
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/apps/"+uuid.NewString(), nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	tracing.Middleware(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want store + server", len(spans))
+	}
+	storeSpan, server := spans[0], spans[1]
+	if server.Name() != "GET /apps/{appID}" {
+		t.Errorf("server span named %q, want the route pattern", server.Name())
+	}
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("incoming trace not continued: %s", got)
+	}
+	if storeSpan.Name() != "store.AppExists" || storeSpan.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("store span %q not a child of the server span", storeSpan.Name())
+	}
+}
+
+func TestWrapStore_KeepsNotifier(t *testing.T) {
+	if _, ok := tracing.WrapStore(fakeStore{}).(store.Notifier); ok {
+		t.Error("plain store must not become a Notifier")
+	}
+	if _, ok := tracing.WrapStore(fakeNotifier{}).(store.Notifier); !ok {
+		t.Error("Notifier capability lost by the decorator")
+	}
+}
+
+func TestSetup_RejectsUnknownExporter(t *testing.T) {
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "zipkin"}); err == nil {
+		t.Error("unknown exporter should be rejected")
+	}
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterFile}); err == nil {
+		t.Error("file exporter without a path should be rejected")
+	}
+}
@@ -0,0 +1,250 @@
+// Package webhook delivers new-submission events from the store's outbox to
+// the URLs owners subscribed. Delivery is at-least-once: an entry is only
+// removed after the receiver answered 2xx, and crashed deliveries become due
+// again once their lease expires.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+)
+
+// Delivery headers. HeaderSignature has the form "t=<unix>,v1=<hex>" where
+// v1 is HMAC-SHA256(secret, "<unix>.<body>").
+const (
+	HeaderSignature = "X-NB-Signature"
+	HeaderEventID   = "X-NB-Event-ID"
+	HeaderAttempt   = "X-NB-Delivery-Attempt"
+)
+
+// Event types. A submission burnt after reading is followed by
+// EventSubmissionDeleted to every webhook that may hold its ciphertext, so
+// the receiver can drop its copy too.
+const (
+	EventSubmissionCreated = "submission.created"
+	EventSubmissionDeleted = "submission.deleted"
+)
+
+// Event is the JSON body POSTed to a webhook URL.
+type Event struct {
+	Type  string    `json:"type"`
+	ID    string    `json:"id"` // submission ID
+	AppID string    `json:"appID"`
+	Kid   uint8     `json:"kid"`
+	TS    time.Time `json:"ts"`
+	Size  int       `json:"size"`
+	Blob  string    `json:"blob,omitempty"` // base64(ciphertext), opt-in
+
+	FormID string `json:"formID,omitempty"`
+}
+
+// Dispatcher polls the outbox and delivers due events. The zero values of
+// the exported fields are replaced by defaults in NewDispatcher; its
+// Client only connects to public addresses (see NewClient).
+type Dispatcher struct {
+	Store       store.Store
+	Client      *http.Client
+	Interval    time.Duration // poll interval
+	Batch       int           // entries claimed per poll
+	Lease       time.Duration // how long a claimed entry stays invisible
+	MaxAttempts int           // after this many failures an entry is parked
+	Logger      *slog.Logger
+}
+
+func NewDispatcher(st store.Store) *Dispatcher {
+	return &Dispatcher{
+		Store:       st,
+		Client:      NewClient(false),
+		Interval:    2 * time.Second,
+		Batch:       32,
+		Lease:       time.Minute,
+		MaxAttempts: 12,
+		Logger:      slog.Default(),
+	}
+}
+
+// Run drains the outbox every Interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.Interval)
+	defer t.Stop()
+	for {
+		for {
+			n, err := d.RunOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				d.Logger.ErrorContext(ctx, "webhook: claim deliveries", "err", err)
+			}
+			if n < d.Batch || err != nil {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce claims one batch of due deliveries, sends them concurrently and
+// records the outcome. It returns the number of claimed entries.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	due, err := d.Store.ClaimWebhookDeliveries(ctx, d.Batch, d.Lease)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for _, dl := range due {
+		wg.Add(1)
+		go func(dl *model.WebhookDelivery) {
+			defer wg.Done()
+			d.settle(ctx, dl, d.deliver(ctx, dl))
+		}(dl)
+	}
+	wg.Wait()
+	return len(due), nil
+}
+
+func (d *Dispatcher) settle(ctx context.Context, dl *model.WebhookDelivery, sendErr error) {
+	if sendErr == nil {
+		if err := d.Store.CompleteWebhookDelivery(ctx, dl.ID); err != nil {
+			d.Logger.ErrorContext(ctx, "webhook: complete delivery", "delivery", dl.ID, "err", err)
+		}
+		return
+	}
+
+	var next time.Time
+	if dl.Attempts < d.MaxAttempts {
+		next = time.Now().Add(Backoff(dl.Attempts))
+	} else {
+		d.Logger.WarnContext(ctx, "webhook: giving up on delivery", "delivery", dl.ID, "attempts", dl.Attempts, "err", sendErr)
+	}
+	if err := d.Store.FailWebhookDelivery(ctx, dl.ID, next, sendErr.Error()); err != nil {
+		d.Logger.ErrorContext(ctx, "webhook: fail delivery", "delivery", dl.ID, "err", err)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, dl *model.WebhookDelivery) error {
+	ev := Event{
+		Type:  dl.Event,
+		ID:    dl.Submission.ID.String(),
+		AppID: dl.Submission.AppID.String(),
+		Kid:   dl.Submission.Kid,
+		TS:    dl.Submission.TS,
+		Size:  dl.Size,
+
+		FormID: dl.Submission.FormID,
+	}
+	if dl.Webhook.IncludeBlob && dl.Submission.Blob != nil {
+		ev.Blob = base64.StdEncoding.EncodeToString(dl.Submission.Blob)
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, Sign(dl.Webhook.Secret, time.Now(), body))
+	req.Header.Set(HeaderEventID, eventID(ev))
+	req.Header.Set(HeaderAttempt, strconv.Itoa(dl.Attempts))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// eventID keeps the bare submission ID for creation events, so receivers
+// can dedupe on HeaderEventID without the deletion looking like a replay.
+func eventID(ev Event) string {
+	if ev.Type == EventSubmissionCreated {
+		return ev.ID
+	}
+	return ev.ID + ":" + ev.Type
+}
+
+// Backoff returns the delay before retry number attempt+1: 10s doubling per
+// attempt, capped at one hour.
+func Backoff(attempt int) time.Duration {
+	const base, ceiling = 10 * time.Second, time.Hour
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 10 {
+		return ceiling
+	}
+	return min(base<<(attempt-1), ceiling)
+}
+
+// Sign builds the HeaderSignature value for body sent at ts.
+func Sign(secret []byte, ts time.Time, body []byte) string {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac(secret, unix, body))
+}
+
+var (
+	ErrBadSignature   = errors.New("webhook signature mismatch")
+	ErrStaleSignature = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// Verify checks a HeaderSignature value on the receiving side. Signatures
+// older or newer than tolerance relative to now are rejected to limit
+// replays.
+func Verify(secret []byte, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var unix, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			unix = v
+		case "v1":
+			sig = v
+		}
+	}
+	sec, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return ErrStaleSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac(secret, unix, body)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func mac(secret []byte, unix string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(unix))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return h.Sum(nil)
+}
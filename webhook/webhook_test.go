@@ -0,0 +1,236 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/collapsinghierarchy/noisybuffer/model"
+	"github.com/collapsinghierarchy/noisybuffer/store"
+	"github.com/collapsinghierarchy/noisybuffer/webhook"
+)
+
+// fakeStore serves a fixed set of deliveries and records their outcome.
+// Deliveries settle concurrently, hence the mutex.
+type fakeStore struct {
+	store.Store
+	mu        sync.Mutex
+	due       []*model.WebhookDelivery
+	completed []int64
+	failed    map[int64]time.Time
+}
+
+func (f *fakeStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*model.WebhookDelivery, error) {
+	due := f.due
+	f.due = nil
+	return due, nil
+}
+
+func (f *fakeStore) CompleteWebhookDelivery(ctx context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.completed = append(f.completed, id)
+	return nil
+}
+
+func (f *fakeStore) FailWebhookDelivery(ctx context.Context, id int64, next time.Time, reason string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failed == nil {
+		f.failed = make(map[int64]time.Time)
+	}
+	f.failed[id] = next
+	return nil
+}
+
+func delivery(id int64, url string, attempts int) *model.WebhookDelivery {
+	appID := uuid.New()
+	return &model.WebhookDelivery{
+		ID:       id,
+		Event:    webhook.EventSubmissionCreated,
+		Attempts: attempts,
+		Size:     4,
+		Webhook: model.Webhook{
+			ID: uuid.New(), AppID: appID, URL: url,
+			Secret: []byte("s3cret"), IncludeBlob: true,
+		},
+		Submission: model.Submission{
+			ID: uuid.New(), AppID: appID, Kid: 1,
+			TS: time.Now().UTC(), Blob: []byte("blob"),
+		},
+	}
+}
+
+func TestRunOnce_SignedDelivery(t *testing.T) {
+	var got webhook.Event
+	var sigErr error
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sigErr = webhook.Verify([]byte("s3cret"), r.Header.Get(webhook.HeaderSignature), body, time.Minute, time.Now())
+		_ = json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer rcv.Close()
+
+	dl := delivery(7, rcv.URL, 1)
+	fs := &fakeStore{due: []*model.WebhookDelivery{dl}}
+	n, err := newDispatcher(fs).RunOnce(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("RunOnce = %d, %v", n, err)
+	}
+	if sigErr != nil {
+		t.Fatalf("signature did not verify: %v", sigErr)
+	}
+	if got.Type != webhook.EventSubmissionCreated || got.ID != dl.Submission.ID.String() {
+		t.Errorf("unexpected event: %+v", got)
+	}
+	if got.Blob != "YmxvYg==" || got.Size != 4 {
+		t.Errorf("blob/size not attached: %+v", got)
+	}
+	if len(fs.completed) != 1 || fs.completed[0] != 7 {
+		t.Errorf("delivery not completed: %v", fs.completed)
+	}
+}
+
+func TestRunOnce_DeletionEvent(t *testing.T) {
+	var got webhook.Event
+	var eventID string
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eventID = r.Header.Get(webhook.HeaderEventID)
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer rcv.Close()
+
+	dl := delivery(1, rcv.URL, 1)
+	dl.Event, dl.Size, dl.Submission.Blob = webhook.EventSubmissionDeleted, 0, nil
+	if _, err := newDispatcher(&fakeStore{due: []*model.WebhookDelivery{dl}}).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if got.Type != webhook.EventSubmissionDeleted || got.ID != dl.Submission.ID.String() || got.Blob != "" {
+		t.Errorf("unexpected event %+v", got)
+	}
+	if want := dl.Submission.ID.String() + ":" + webhook.EventSubmissionDeleted; eventID != want {
+		t.Errorf("event id %q, want %q (distinct from the creation event)", eventID, want)
+	}
+}
+
+func TestRunOnce_RetryAndGiveUp(t *testing.T) {
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer rcv.Close()
+
+	d := newDispatcher(nil)
+	fs := &fakeStore{due: []*model.WebhookDelivery{
+		delivery(1, rcv.URL, 1),
+		delivery(2, rcv.URL, d.MaxAttempts),
+	}}
+	d.Store = fs
+	if _, err := d.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if len(fs.completed) != 0 {
+		t.Fatalf("failed deliveries must not complete: %v", fs.completed)
+	}
+	if next := fs.failed[1]; next.IsZero() || time.Until(next) > webhook.Backoff(1)+time.Second {
+		t.Errorf("delivery 1 should be retried after backoff, got %v", next)
+	}
+	if next, ok := fs.failed[2]; !ok || !next.IsZero() {
+		t.Errorf("delivery 2 should be parked, got %v (recorded=%v)", next, ok)
+	}
+}
+
+// newDispatcher allows loopback receivers, where httptest servers live.
+func newDispatcher(st store.Store) *webhook.Dispatcher {
+	d := webhook.NewDispatcher(st)
+	d.Client = webhook.NewClient(true)
+	return d
+}
+
+func TestRunOnce_PrivateAddressesAndRedirects(t *testing.T) {
+	var hit atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit.Add(1)
+	}))
+	defer internal.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	fs := &fakeStore{due: []*model.WebhookDelivery{delivery(1, internal.URL, 1)}}
+	if _, err := webhook.NewDispatcher(fs).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if hit.Load() != 0 || len(fs.completed) != 0 {
+		t.Fatal("default dispatcher delivered to a loopback address")
+	}
+
+	fs = &fakeStore{due: []*model.WebhookDelivery{delivery(2, redirect.URL, 1)}}
+	if _, err := newDispatcher(fs).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if hit.Load() != 0 || len(fs.completed) != 0 {
+		t.Fatal("redirect was followed")
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":      true,
+		"2606:4700::1111":    true,
+		"127.0.0.1":          false,
+		"10.1.2.3":           false,
+		"172.16.0.1":         false,
+		"192.168.1.1":        false,
+		"169.254.169.254":    false,
+		"100.64.0.1":         false,
+		"0.0.0.0":            false,
+		"::1":                false,
+		"fd00::1":            false,
+		"fe80::1":            false,
+		"::ffff:127.0.0.1":   false,
+		"64:ff9b::a9fe:a9fe": false,
+		"255.255.255.255":    false,
+	} {
+		if got := webhook.PublicAddr(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("PublicAddr(%s) = %v, want %v", addr, got, public)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if webhook.Backoff(1) != 10*time.Second || webhook.Backoff(2) != 20*time.Second {
+		t.Errorf("unexpected early backoff: %v, %v", webhook.Backoff(1), webhook.Backoff(2))
+	}
+	if webhook.Backoff(50) != time.Hour {
+		t.Errorf("backoff should cap at 1h, got %v", webhook.Backoff(50))
+	}
+}
+
+func TestVerify_Rejects(t *testing.T) {
+	body := []byte(`{"type":"submission.created"}`)
+	now := time.Now()
+	sig := webhook.Sign([]byte("k"), now, body)
+
+	if err := webhook.Verify([]byte("other"), sig, body, time.Minute, now); !errors.Is(err, webhook.ErrBadSignature) {
+		t.Errorf("wrong secret: got %v", err)
+	}
+	if err := webhook.Verify([]byte("k"), sig, []byte("tampered"), time.Minute, now); !errors.Is(err, webhook.ErrBadSignature) {
+		t.Errorf("tampered body: got %v", err)
+	}
+	if err := webhook.Verify([]byte("k"), sig, body, time.Minute, now.Add(time.Hour)); !errors.Is(err, webhook.ErrStaleSignature) {
+		t.Errorf("stale signature: got %v", err)
+	}
+}